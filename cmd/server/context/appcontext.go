@@ -77,7 +77,7 @@ func (c *AppContext) GetWantedContentType() string {
 		return "atom"
 	} else {
 		accept := strings.ToLower(c.Request().Header.Get("accept"))
-		if strings.HasPrefix(accept, "application/json") {
+		if strings.HasPrefix(accept, "application/json") || strings.HasPrefix(accept, "application/activity+json") || strings.HasPrefix(accept, "application/ld+json") {
 			return "json"
 		}
 		if strings.HasPrefix(accept, "application/atom+xml") {
@@ -0,0 +1,22 @@
+create table activities
+(
+	id integer
+		primary key
+		 autoincrement,
+	iri varchar(255),
+	type varchar(50),
+	actor varchar(255),
+	object varchar(255),
+	user_id int DEFAULT 0,
+	raw text,
+	received_at datetime
+)
+;
+
+create unique index uix_activities_iri
+	on activities (iri)
+;
+
+create index idx_activities_actor
+	on activities (actor)
+;
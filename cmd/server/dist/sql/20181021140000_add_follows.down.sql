@@ -0,0 +1,3 @@
+DROP TABLE followers;
+DROP TABLE following;
+ALTER TABLE users DROP COLUMN manually_approves_followers;
\ No newline at end of file
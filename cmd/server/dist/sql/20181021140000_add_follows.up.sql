@@ -0,0 +1,42 @@
+create table followers
+(
+	id integer
+		primary key
+		 autoincrement,
+	user_id int,
+	actor varchar(255),
+	inbox varchar(255),
+	shared_inbox varchar(255),
+	activity_iri varchar(255),
+	state varchar(20),
+	created_at datetime
+)
+;
+
+create unique index uix_followers_user_actor
+	on followers (user_id, actor)
+;
+
+create table following
+(
+	id integer
+		primary key
+		 autoincrement,
+	user_id int,
+	actor varchar(255),
+	inbox varchar(255),
+	activity_iri varchar(255),
+	state varchar(20),
+	created_at datetime
+)
+;
+
+create unique index uix_following_user_actor
+	on following (user_id, actor)
+;
+
+create index idx_following_activity_iri
+	on following (activity_iri)
+;
+
+ALTER TABLE users ADD manually_approves_followers bool DEFAULT 0;
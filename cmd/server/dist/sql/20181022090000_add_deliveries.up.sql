@@ -0,0 +1,20 @@
+create table deliveries
+(
+	id integer
+		primary key
+		 autoincrement,
+	user_id int,
+	inbox varchar(255),
+	host varchar(255),
+	body text,
+	attempts int DEFAULT 0,
+	state varchar(20),
+	last_error text DEFAULT '',
+	next_attempt_at datetime,
+	created_at datetime
+)
+;
+
+create index idx_deliveries_state_next_attempt_at
+	on deliveries (state, next_attempt_at)
+;
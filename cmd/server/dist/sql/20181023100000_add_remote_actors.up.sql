@@ -0,0 +1,33 @@
+create table remote_actors
+(
+	id integer
+		primary key
+		 autoincrement,
+	iri varchar(255),
+	acct varchar(255) DEFAULT '',
+	type varchar(50),
+	preferred_username varchar(255),
+	name varchar(255),
+	inbox varchar(255),
+	shared_inbox varchar(255),
+	outbox varchar(255),
+	followers varchar(255),
+	key_id varchar(255),
+	public_key_pem text,
+	magic_key text,
+	manually_approves_followers bool DEFAULT 0,
+	fetched_at datetime
+)
+;
+
+create unique index uix_remote_actors_iri
+	on remote_actors (iri)
+;
+
+create index idx_remote_actors_acct
+	on remote_actors (acct)
+;
+
+create index idx_remote_actors_key_id
+	on remote_actors (key_id)
+;
@@ -0,0 +1 @@
+ALTER TABLE photos DROP COLUMN exif;
\ No newline at end of file
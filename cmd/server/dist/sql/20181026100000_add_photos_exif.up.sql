@@ -0,0 +1 @@
+ALTER TABLE photos ADD exif text NULL;
\ No newline at end of file
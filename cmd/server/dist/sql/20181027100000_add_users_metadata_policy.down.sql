@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN metadata_policy;
\ No newline at end of file
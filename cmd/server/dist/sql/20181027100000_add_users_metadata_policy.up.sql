@@ -0,0 +1 @@
+ALTER TABLE users ADD metadata_policy integer DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE photos DROP COLUMN original_hash;
+ALTER TABLE photos DROP COLUMN original_type;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE photos ADD original_hash varchar(100) DEFAULT '';
+ALTER TABLE photos ADD original_type varchar(50) DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+UPDATE users SET metadata_policy = 1 - metadata_policy WHERE metadata_policy IN (0, 1);
\ No newline at end of file
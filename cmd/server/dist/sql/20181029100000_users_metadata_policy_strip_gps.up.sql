@@ -0,0 +1 @@
+UPDATE users SET metadata_policy = 0 WHERE metadata_policy = 1;
\ No newline at end of file
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/cmd/server/queue"
+	"github.com/peerpx/peerpx/entities/delivery"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/datastore"
+)
+
+// AdminDeliveriesList returns deliveries of the federation queue
+// GET /api/v1/admin/deliveries?state=pending&limit=100
+// state defaults to dead (failed deliveries)
+func AdminDeliveriesList(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	state := delivery.State(c.QueryParam("state"))
+	switch state {
+	case "":
+		state = delivery.StateDead
+	case delivery.StateDead, delivery.StatePending:
+	default:
+		response.Code = "badState"
+		return response.KO(http.StatusBadRequest)
+	}
+	limit := 100
+	if c.QueryParam("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || limit < 1 {
+			response.Code = "badLimit"
+			return response.KO(http.StatusBadRequest)
+		}
+	}
+
+	deliveries, err := delivery.List(state, limit)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDeliveriesList - delivery.List(%s, %d) failed: %v", state, limit, err)
+		response.Code = "deliveryListFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if deliveries == nil {
+		deliveries = []delivery.Delivery{}
+	}
+	response.Data, err = json.Marshal(deliveries)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDeliveriesList - json.Marshal(deliveries) failed: %v", err)
+		response.Code = "deliveriesMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// AdminDeliveryRetry schedules an immediate new attempt of a delivery
+// POST /api/v1/admin/deliveries/:id/retry
+func AdminDeliveryRetry(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	d, err := deliveryFromParam(c)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "noSuchDelivery"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.AdminDeliveryRetry - get delivery %s failed: %v", c.Param("id"), err)
+		response.Code = "deliveryGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if err = d.Retry(); err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDeliveryRetry - delivery.Retry() failed: %v", err)
+		response.Code = "deliveryUpdateFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	queue.Wake()
+	return response.OK(http.StatusOK)
+}
+
+// AdminDeliveryDelete removes a delivery from the queue
+// DELETE /api/v1/admin/deliveries/:id
+func AdminDeliveryDelete(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	d, err := deliveryFromParam(c)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "noSuchDelivery"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.AdminDeliveryDelete - get delivery %s failed: %v", c.Param("id"), err)
+		response.Code = "deliveryGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if err = d.Delete(); err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDeliveryDelete - delivery.Delete() failed: %v", err)
+		response.Code = "deliveryDeleteFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// AdminDatastoreScrub verifies integrity of photos in datastore
+// POST /api/v1/admin/datastore/scrub?quarantine=true
+// corrupted photos are reported, and moved to quarantine if requested
+func AdminDatastoreScrub(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	quarantine := c.QueryParam("quarantine") == "true"
+	report, err := datastore.Scrub(quarantine)
+	if err != nil {
+		if err == datastore.ErrNotSupported {
+			response.Code = "scrubNotSupported"
+			return response.KO(http.StatusNotImplemented)
+		}
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreScrub - datastore.Scrub(%v) failed: %v", quarantine, err)
+		response.Code = "scrubFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	for _, key := range report.Corrupted {
+		c.LogErrorf("handlers.AdminDatastoreScrub - %s is corrupted", key)
+	}
+	response.Data, err = json.Marshal(report)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreScrub - json.Marshal(report) failed: %v", err)
+		response.Code = "reportMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// AdminDatastoreGC reconciles datastore with photos (see photo.GC)
+// POST /api/v1/admin/datastore/gc?repair=true&grace=24h
+// orphan keys and dangling photos are reported, and deleted if repair
+// is requested
+// config: datastore.gc.grace (age of orphans kept for in-flight uploads, default 24h)
+func AdminDatastoreGC(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	grace := config.GetDurationDefault("datastore.gc.grace", 24*time.Hour)
+	if c.QueryParam("grace") != "" {
+		var err error
+		grace, err = time.ParseDuration(c.QueryParam("grace"))
+		if err != nil || grace < 0 {
+			response.Code = "badGrace"
+			return response.KO(http.StatusBadRequest)
+		}
+	}
+	repair := c.QueryParam("repair") == "true"
+
+	report, err := photo.GC(grace, repair)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreGC - photo.GC(%v, %v) failed: %v", grace, repair, err)
+		response.Code = "gcFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	response.Data, err = json.Marshal(report)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreGC - json.Marshal(report) failed: %v", err)
+		response.Code = "reportMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// AdminDatastoreRotate seals again datastore values which are not sealed
+// with the current encryption key (see datastore.RotateKeys)
+// POST /api/v1/admin/datastore/rotate
+func AdminDatastoreRotate(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	rotated, err := datastore.RotateKeys()
+	if err != nil {
+		if err == datastore.ErrNotSupported {
+			response.Code = "datastoreNotEncrypted"
+			return response.KO(http.StatusNotImplemented)
+		}
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreRotate - datastore.RotateKeys() failed after %d values: %v", rotated, err)
+		response.Code = "rotateFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	response.Data, err = json.Marshal(map[string]int{"rotated": rotated})
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.AdminDatastoreRotate - json.Marshal failed: %v", err)
+		response.Code = "reportMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// deliveryFromParam returns delivery referenced by param id
+func deliveryFromParam(c *context.AppContext) (*delivery.Delivery, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+	return delivery.GetByID(uint(id))
+}
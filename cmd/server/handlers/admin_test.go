@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestAdminDeliveriesList(t *testing.T) {
+	e := echo.New()
+
+	// bad state
+	req := httptest.NewRequest(echo.GET, "/api/v1/admin/deliveries?state=foo", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDeliveriesList(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// ok
+	req = httptest.NewRequest(echo.GET, "/api/v1/admin/deliveries", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WithArgs("dead", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "state"}).AddRow(1, "dead"))
+	if assert.NoError(t, AdminDeliveriesList(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			assert.True(t, response.Success)
+		}
+	}
+}
+
+func TestAdminDeliveryRetry(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.POST, "/api/v1/admin/deliveries/1/retry", nil)
+
+	// not found
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, AdminDeliveryRetry(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "attempts", "state"}).AddRow(1, 10, "dead"))
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WithArgs(0, "pending", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, AdminDeliveryRetry(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestAdminDatastoreScrub(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.POST, "/api/v1/admin/datastore/scrub?quarantine=true", nil)
+
+	// not supported
+	datastore.InitMokedDatastore(nil, nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDatastoreScrub(c)) {
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	}
+
+	// ok
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J", []byte("truncated")); err != nil {
+		panic(err)
+	}
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDatastoreScrub(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			report := datastore.ScrubReport{}
+			if assert.NoError(t, json.Unmarshal(response.Data, &report)) {
+				assert.Equal(t, 1, report.Checked)
+				assert.Equal(t, []string{"2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J"}, report.Quarantined)
+			}
+		}
+	}
+}
+
+func TestAdminDatastoreGC(t *testing.T) {
+	e := echo.New()
+	datastore.InitMokedDatastore(nil, nil)
+
+	// bad grace
+	req := httptest.NewRequest(echo.POST, "/api/v1/admin/datastore/gc?grace=foo", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDatastoreGC(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// ok
+	req = httptest.NewRequest(echo.POST, "/api/v1/admin/datastore/gc?grace=1h", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT hash, original_hash FROM photos").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "original_hash"}).AddRow("hash", ""))
+	if assert.NoError(t, AdminDatastoreGC(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			report := photo.GCReport{}
+			if assert.NoError(t, json.Unmarshal(response.Data, &report)) {
+				assert.Equal(t, []string{"hash"}, report.Dangling)
+				assert.False(t, report.Repaired)
+			}
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestAdminDatastoreRotate(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.POST, "/api/v1/admin/datastore/rotate", nil)
+
+	// not encrypted
+	datastore.InitMokedDatastore(nil, nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDatastoreRotate(c)) {
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	}
+
+	// ok
+	if err := datastore.InitEncryptedDatastore(&[32]byte{1}, nil, false); err != nil {
+		panic(err)
+	}
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, AdminDatastoreRotate(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, `{"rotated": 0}`, string(response.Data))
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/follow"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/services/config"
+)
+
+// collectionSource provides items of a paginated collection
+type collectionSource struct {
+	// count returns the total number of items
+	count func() (int, error)
+	// page returns at most limit items whose id is lower than maxID (0: no limit)
+	// and the id of the last returned item
+	page func(maxID uint, limit int) (items []interface{}, lastID uint, err error)
+}
+
+// UserFollowers returns accepted followers of user :username
+// GET /users/:username/followers
+func UserFollowers(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	u, err := collectionOwner(c)
+	if u == nil {
+		return err
+	}
+	return serveCollection(c, u.IRI()+"/followers", collectionSource{
+		count: func() (int, error) {
+			return follow.CountFollowers(u.ID, follow.StateAccepted)
+		},
+		page: func(maxID uint, limit int) ([]interface{}, uint, error) {
+			followers, err := follow.ListFollowersBefore(u.ID, follow.StateAccepted, maxID, limit)
+			if err != nil || len(followers) == 0 {
+				return nil, 0, err
+			}
+			items := make([]interface{}, len(followers))
+			for i, f := range followers {
+				items[i] = f.Actor
+			}
+			return items, followers[len(followers)-1].ID, nil
+		},
+	})
+}
+
+// UserFollowing returns actors followed by user :username
+// GET /users/:username/following
+func UserFollowing(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	u, err := collectionOwner(c)
+	if u == nil {
+		return err
+	}
+	return serveCollection(c, u.IRI()+"/following", collectionSource{
+		count: func() (int, error) {
+			return follow.CountFollowing(u.ID, follow.StateAccepted)
+		},
+		page: func(maxID uint, limit int) ([]interface{}, uint, error) {
+			followings, err := follow.ListFollowingBefore(u.ID, follow.StateAccepted, maxID, limit)
+			if err != nil || len(followings) == 0 {
+				return nil, 0, err
+			}
+			items := make([]interface{}, len(followings))
+			for i, f := range followings {
+				items[i] = f.Actor
+			}
+			return items, followings[len(followings)-1].ID, nil
+		},
+	})
+}
+
+// UserOutbox returns public photos of user :username as Create activities
+// GET /users/:username/outbox
+func UserOutbox(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	u, err := collectionOwner(c)
+	if u == nil {
+		return err
+	}
+	return serveCollection(c, u.IRI()+"/outbox", collectionSource{
+		count: func() (int, error) {
+			return photo.Count(photo.ListArgs{UserID: u.ID, PublicOnly: true})
+		},
+		page: func(maxID uint, limit int) ([]interface{}, uint, error) {
+			photos, err := photo.List(photo.ListArgs{UserID: u.ID, MaxID: maxID, Limit: limit, PublicOnly: true})
+			if err != nil || len(photos) == 0 {
+				return nil, 0, err
+			}
+			items := make([]interface{}, len(photos))
+			for i := range photos {
+				create, err := photoCreate(&photos[i], u)
+				if err != nil {
+					return nil, 0, err
+				}
+				create.Context = nil
+				items[i] = create
+			}
+			return items, photos[len(photos)-1].ID, nil
+		},
+	})
+}
+
+// UserFeatured returns featured photos of user :username
+// GET /users/:username/collections/featured
+// Featured photos are not implemented yet: collection is always empty
+func UserFeatured(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	u, err := collectionOwner(c)
+	if u == nil {
+		return err
+	}
+	return serveCollection(c, u.IRI()+"/collections/featured", collectionSource{
+		count: func() (int, error) {
+			return 0, nil
+		},
+		page: func(maxID uint, limit int) ([]interface{}, uint, error) {
+			return nil, 0, nil
+		},
+	})
+}
+
+// collectionOwner returns user :username
+// if user is nil, response has been sent and err must be returned by the handler
+func collectionOwner(c *context.AppContext) (*user.User, error) {
+	u, err := user.GetByUsername(c.Param("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, c.String(http.StatusNotFound, "no such user")
+		}
+		c.LogErrorf("handlers.collectionOwner - user.GetByUsername(%s) failed: %v", c.Param("username"), err)
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	return u, nil
+}
+
+// serveCollection sends collection id as an OrderedCollection
+// or, if query param page is set, as an OrderedCollectionPage
+// Pages are cursor based: ?page=true&max_id={id of the last item of the previous page}
+func serveCollection(c *context.AppContext, id string, src collectionSource) error {
+	total, err := src.count()
+	if err != nil {
+		c.LogErrorf("handlers.serveCollection - count %s items failed: %v", id, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if c.QueryParam("page") == "" {
+		return activityJSON(c, activitypub.NewOrderedCollection(id, total, id+"?page=true"))
+	}
+
+	var maxID uint64
+	pageID := id + "?page=true"
+	if c.QueryParam("max_id") != "" {
+		maxID, err = strconv.ParseUint(c.QueryParam("max_id"), 10, 64)
+		if err != nil || maxID == 0 {
+			return c.String(http.StatusBadRequest, "bad max_id")
+		}
+		pageID = fmt.Sprintf("%s&max_id=%d", pageID, maxID)
+	}
+
+	limit := config.GetIntDefault("federation.collectionPageSize", 20)
+	items, lastID, err := src.page(uint(maxID), limit)
+	if err != nil {
+		c.LogErrorf("handlers.serveCollection - get %s items failed: %v", pageID, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	page := activitypub.NewOrderedCollectionPage(pageID, id, total)
+	if len(items) != 0 {
+		page.OrderedItems = items
+	}
+	if len(items) == limit {
+		page.Next = fmt.Sprintf("%s?page=true&max_id=%d", id, lastID)
+	}
+	return activityJSON(c, page)
+}
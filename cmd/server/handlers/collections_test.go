@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestUserOutbox(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	config.Set("federation.collectionPageSize", "2")
+	e := echo.New()
+
+	// no such user
+	req := httptest.NewRequest(echo.GET, "/users/john/outbox", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("john")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, UserOutbox(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// collection
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	if assert.NoError(t, UserOutbox(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), activitypub.ContentType))
+		collection := new(activitypub.OrderedCollection)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), collection)) {
+			assert.Equal(t, "OrderedCollection", collection.Type)
+			assert.Equal(t, 3, collection.TotalItems)
+			assert.Equal(t, "https://peerpx.social/users/toorop/outbox?page=true", collection.First)
+		}
+	}
+
+	// first page
+	req = httptest.NewRequest(echo.GET, "/users/toorop/outbox?page=true", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	db.Mock.ExpectQuery("^SELECT \\* FROM photos (.*)").
+		WithArgs(1, false, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "name"}).AddRow(7, "hash7", "seven").AddRow(5, "hash5", "five"))
+	if assert.NoError(t, UserOutbox(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := struct {
+			activitypub.OrderedCollectionPage
+			OrderedItems []activitypub.Activity `json:"orderedItems"`
+		}{}
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page)) {
+			assert.Equal(t, "OrderedCollectionPage", page.Type)
+			assert.Equal(t, "https://peerpx.social/users/toorop/outbox", page.PartOf)
+			assert.Equal(t, "https://peerpx.social/users/toorop/outbox?page=true&max_id=5", page.Next)
+			if assert.Equal(t, 2, len(page.OrderedItems)) {
+				assert.Equal(t, "Create", page.OrderedItems[0].Type)
+				assert.Equal(t, "Image", page.OrderedItems[0].ObjectType())
+				assert.Equal(t, "https://peerpx.social/photos/hash7", page.OrderedItems[0].ObjectIRI())
+			}
+		}
+	}
+
+	// last page
+	req = httptest.NewRequest(echo.GET, "/users/toorop/outbox?page=true&max_id=5", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	db.Mock.ExpectQuery("^SELECT \\* FROM photos (.*)").
+		WithArgs(1, 5, false, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(2, "hash2"))
+	if assert.NoError(t, UserOutbox(c)) {
+		page := new(activitypub.OrderedCollectionPage)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), page)) {
+			assert.Equal(t, "", page.Next)
+			assert.Equal(t, 1, len(page.OrderedItems))
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestUserFollowers(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	e := echo.New()
+
+	req := httptest.NewRequest(echo.GET, "/users/toorop/followers?page=true", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	db.Mock.ExpectQuery("^SELECT \\* FROM followers (.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor"}).AddRow(3, "https://remote.social/users/john"))
+	if assert.NoError(t, UserFollowers(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		page := new(activitypub.OrderedCollectionPage)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), page)) {
+			assert.Equal(t, "https://peerpx.social/users/toorop/followers?page=true", page.ID)
+			assert.Equal(t, []interface{}{"https://remote.social/users/john"}, page.OrderedItems)
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/cmd/server/queue"
+	"github.com/peerpx/peerpx/entities/follow"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/entities/remoteactor"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// deliver queues activity for delivery to inbox on behalf of u
+// errors are only logged
+// (var for testing purpose)
+var deliver = func(u *user.User, inbox string, activity interface{}) {
+	if err := queue.Enqueue(u, []string{inbox}, activity); err != nil {
+		log.Errorf("handlers.deliver - queue.Enqueue to %s failed: %v", inbox, err)
+	}
+}
+
+// deliverToFollowers queues activity for delivery to followers of u
+// errors are only logged
+// (var for testing purpose)
+var deliverToFollowers = func(u *user.User, activity interface{}) {
+	if err := queue.EnqueueToFollowers(u, activity); err != nil {
+		log.Errorf("handlers.deliverToFollowers - queue.EnqueueToFollowers for %s failed: %v", u.Username, err)
+	}
+}
+
+// fetchActor returns a remote actor (cached)
+// (var for testing purpose)
+var fetchActor = remoteactor.Resolve
+
+// fetchActorByAcct returns a remote actor from its acct: user@domain (cached)
+// (var for testing purpose)
+var fetchActorByAcct = remoteactor.ResolveAcct
+
+// sendFollowResponse sends an Accept or a Reject (activityType)
+// of the Follow activity of follower f
+func sendFollowResponse(u *user.User, f *follow.Follower, activityType string) error {
+	followActivity, err := activitypub.NewActivity(f.ActivityIRI, "Follow", f.Actor, u.IRI())
+	if err != nil {
+		return err
+	}
+	followActivity.Context = nil
+	id := fmt.Sprintf("%s#%ss/follows/%d", u.IRI(), strings.ToLower(activityType), f.ID)
+	response, err := activitypub.NewActivity(id, activityType, u.IRI(), followActivity)
+	if err != nil {
+		return err
+	}
+	response.To = activitypub.IRIs{activitypub.IRI(f.Actor)}
+	deliver(u, f.Inbox, response)
+	return nil
+}
+
+// sendFollow sends the Follow activity of following f
+func sendFollow(u *user.User, f *follow.Following) error {
+	followActivity, err := activitypub.NewActivity(f.ActivityIRI, "Follow", u.IRI(), f.Actor)
+	if err != nil {
+		return err
+	}
+	followActivity.To = activitypub.IRIs{activitypub.IRI(f.Actor)}
+	deliver(u, f.Inbox, followActivity)
+	return nil
+}
+
+// sendUndoFollow sends an Undo of the Follow activity of following f
+func sendUndoFollow(u *user.User, f *follow.Following) error {
+	followActivity, err := activitypub.NewActivity(f.ActivityIRI, "Follow", u.IRI(), f.Actor)
+	if err != nil {
+		return err
+	}
+	followActivity.Context = nil
+	undo, err := activitypub.NewActivity(fmt.Sprintf("%s#undos/follows/%d", u.IRI(), f.ID), "Undo", u.IRI(), followActivity)
+	if err != nil {
+		return err
+	}
+	undo.To = activitypub.IRIs{activitypub.IRI(f.Actor)}
+	deliver(u, f.Inbox, undo)
+	return nil
+}
+
+// activityJSON sends v as an ActivityPub document
+func activityJSON(c *context.AppContext, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.LogErrorf("handlers.activityJSON - json.Marshal failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.Blob(http.StatusOK, activitypub.ContentType+"; charset=utf-8", b)
+}
+
+// photoImage returns photo p of user u as an ActivityStreams Image
+func photoImage(p *photo.Photo, u *user.User) *activitypub.Image {
+	image := &activitypub.Image{
+		ID:           p.IRI(),
+		Type:         "Image",
+		AttributedTo: u.IRI(),
+		Name:         p.Name,
+		Content:      html.EscapeString(p.Description),
+		MediaType:    "image/jpeg",
+		Width:        p.Width,
+		Height:       p.Height,
+		Sensitive:    p.Nsfw,
+		License:      p.LicenceType.URL(),
+		Location:     photoPlace(p, u),
+		Attachment:   photoExif(p),
+		To:           activitypub.IRIs{activitypub.Public},
+		Cc:           activitypub.IRIs{activitypub.IRI(u.IRI() + "/followers")},
+	}
+	for _, size := range p.AvailableSizes() {
+		width, height := size.Dimensions(p)
+		image.URL = append(image.URL, activitypub.Link{
+			Type:      "Link",
+			Href:      p.SizeURL(size.Name),
+			Name:      size.Name,
+			MediaType: "image/jpeg",
+			Width:     width,
+			Height:    height,
+		})
+	}
+	if !p.AddedAt.IsZero() {
+		published := p.AddedAt.UTC()
+		image.Published = &published
+	}
+	return image
+}
+
+// photoPlace returns the location of photo p of user u as a Place (nil if unknown)
+// coordinates are rounded if required by the metadata policy of u
+func photoPlace(p *photo.Photo, u *user.User) *activitypub.Place {
+	if p.Location == "" && p.Latitude == 0 && p.Longitude == 0 {
+		return nil
+	}
+	if !u.MetadataPolicy.ExactLocation() {
+		coarse := *p
+		coarse.CoarseLocation()
+		p = &coarse
+	}
+	place := &activitypub.Place{Type: "Place", Name: p.Location}
+	if p.Latitude != 0 || p.Longitude != 0 {
+		// float32 -> float64 without noise (48.8566 not 48.85660171508789)
+		latitude, _ := strconv.ParseFloat(strconv.FormatFloat(float64(p.Latitude), 'f', -1, 32), 64)
+		longitude, _ := strconv.ParseFloat(strconv.FormatFloat(float64(p.Longitude), 'f', -1, 32), 64)
+		place.Latitude = &latitude
+		place.Longitude = &longitude
+	}
+	return place
+}
+
+// photoExif returns EXIF properties of photo p as PropertyValue
+func photoExif(p *photo.Photo) []activitypub.PropertyValue {
+	var properties []activitypub.PropertyValue
+	if p.Camera != "" {
+		properties = append(properties, activitypub.NewPropertyValue("Camera", p.Camera))
+	}
+	if p.Lens != "" {
+		properties = append(properties, activitypub.NewPropertyValue("Lens", p.Lens))
+	}
+	if p.FocalLength != 0 {
+		properties = append(properties, activitypub.NewPropertyValue("Focal length", fmt.Sprintf("%d mm", p.FocalLength)))
+	}
+	if p.Aperture != 0 {
+		properties = append(properties, activitypub.NewPropertyValue("Aperture", fmt.Sprintf("f/%g", p.Aperture)))
+	}
+	if p.ShutterSpeed != "" {
+		properties = append(properties, activitypub.NewPropertyValue("Shutter speed", p.ShutterSpeed+" s"))
+	}
+	if p.Iso != 0 {
+		properties = append(properties, activitypub.NewPropertyValue("ISO", strconv.Itoa(int(p.Iso))))
+	}
+	if !p.TakenAt.IsZero() {
+		properties = append(properties, activitypub.NewPropertyValue("Taken at", p.TakenAt.Format(time.RFC3339)))
+	}
+	return properties
+}
+
+// photoCreate returns the Create activity of photo p of user u
+func photoCreate(p *photo.Photo, u *user.User) (*activitypub.Activity, error) {
+	image := photoImage(p, u)
+	create, err := activitypub.NewActivity(p.IRI()+"/activity", "Create", u.IRI(), image)
+	if err != nil {
+		return nil, err
+	}
+	create.Context = activitypub.ImageContext
+	create.To = image.To
+	create.Cc = image.Cc
+	create.Published = image.Published
+	return create, nil
+}
+
+// photoUpdate returns the Update activity of photo p of user u
+func photoUpdate(p *photo.Photo, u *user.User) (*activitypub.Activity, error) {
+	image := photoImage(p, u)
+	updated := time.Now().UTC()
+	image.Updated = &updated
+	update, err := activitypub.NewActivity(fmt.Sprintf("%s#updates/%d", p.IRI(), updated.UnixNano()), "Update", u.IRI(), image)
+	if err != nil {
+		return nil, err
+	}
+	update.Context = activitypub.ImageContext
+	update.To = image.To
+	update.Cc = image.Cc
+	update.Published = &updated
+	return update, nil
+}
+
+// photoDelete returns the Delete activity of photo p of user u
+func photoDelete(p *photo.Photo, u *user.User) (*activitypub.Activity, error) {
+	tombstone := activitypub.NewTombstone(p.IRI(), "Image")
+	del, err := activitypub.NewActivity(p.IRI()+"#delete", "Delete", u.IRI(), tombstone)
+	if err != nil {
+		return nil, err
+	}
+	del.To = activitypub.IRIs{activitypub.Public}
+	del.Cc = activitypub.IRIs{activitypub.IRI(u.IRI() + "/followers")}
+	del.Published = tombstone.Deleted
+	return del, nil
+}
+
+// federatePhotoChange sends to followers of the owner the activity matching
+// the change of photo from before to after (nil after: photo deleted)
+// private photos are never federated, so a photo becoming private is
+// deleted and a photo becoming public is created
+// errors are only logged
+func federatePhotoChange(c *context.AppContext, before, after *photo.Photo) {
+	if before.Privacy && (after == nil || after.Privacy) {
+		return
+	}
+	u, err := user.GetByID(int(before.UserID))
+	if err != nil {
+		c.LogErrorf("handlers.federatePhotoChange - user.GetByID(%d) failed: %v", before.UserID, err)
+		return
+	}
+	var a *activitypub.Activity
+	switch {
+	case after == nil || after.Privacy:
+		a, err = photoDelete(before, u)
+	case before.Privacy:
+		a, err = photoCreate(after, u)
+	default:
+		a, err = photoUpdate(after, u)
+	}
+	if err != nil {
+		c.LogErrorf("handlers.federatePhotoChange - build activity of %s failed: %v", before.Hash, err)
+		return
+	}
+	deliverToFollowers(u, a)
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/follow"
+	"github.com/peerpx/peerpx/entities/remoteactor"
+	"github.com/peerpx/peerpx/entities/user"
+)
+
+// FollowersList returns followers of the logged user
+// GET /api/v1/user/followers?state=pending
+// state defaults to accepted
+func FollowersList(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowersList - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	state := follow.State(c.QueryParam("state"))
+	switch state {
+	case "":
+		state = follow.StateAccepted
+	case follow.StateAccepted, follow.StatePending:
+	default:
+		response.Code = "badState"
+		return response.KO(http.StatusBadRequest)
+	}
+
+	followers, err := follow.ListFollowers(u.ID, state)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowersList - follow.ListFollowers(%d, %s) failed: %v", u.ID, state, err)
+		response.Code = "followListFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if followers == nil {
+		followers = []follow.Follower{}
+	}
+	response.Data, err = json.Marshal(followers)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowersList - json.Marshal(followers) failed: %v", err)
+		response.Code = "followersMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// FollowerAccept accepts a pending follow request
+// POST /api/v1/user/followers/:id/accept
+func FollowerAccept(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowerAccept - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	f, err := followerFromParam(c, u)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "noSuchFollower"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.FollowerAccept - get follower %s failed: %v", c.Param("id"), err)
+		response.Code = "followerGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if f.State != follow.StatePending {
+		response.Code = "followerNotPending"
+		return response.KO(http.StatusConflict)
+	}
+
+	f.State = follow.StateAccepted
+	if err = f.Update(); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowerAccept - follower.Update() failed: %v", err)
+		response.Code = "followerUpdateFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if err = sendFollowResponse(u, f, "Accept"); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowerAccept - send Accept to %s failed: %v", f.Actor, err)
+		response.Code = "acceptSendFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// FollowerReject rejects a follow request (or removes a follower)
+// POST /api/v1/user/followers/:id/reject
+func FollowerReject(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowerReject - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	f, err := followerFromParam(c, u)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "noSuchFollower"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.FollowerReject - get follower %s failed: %v", c.Param("id"), err)
+		response.Code = "followerGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	if err = f.Delete(); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowerReject - follower.Delete() failed: %v", err)
+		response.Code = "followerDeleteFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if err = sendFollowResponse(u, f, "Reject"); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowerReject - send Reject to %s failed: %v", f.Actor, err)
+		response.Code = "rejectSendFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// followerFromParam returns follower referenced by param id
+// sql.ErrNoRows is returned if follower doesn't belong to u
+func followerFromParam(c *context.AppContext, u *user.User) (*follow.Follower, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+	f, err := follow.GetFollowerByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if f.UserID != u.ID {
+		return nil, sql.ErrNoRows
+	}
+	return f, nil
+}
+
+// FollowingList returns actors followed by the logged user
+// GET /api/v1/user/following?state=pending
+// state defaults to accepted
+func FollowingList(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowingList - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	state := follow.State(c.QueryParam("state"))
+	switch state {
+	case "":
+		state = follow.StateAccepted
+	case follow.StateAccepted, follow.StatePending:
+	default:
+		response.Code = "badState"
+		return response.KO(http.StatusBadRequest)
+	}
+
+	followings, err := follow.ListFollowing(u.ID, state)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingList - follow.ListFollowing(%d, %s) failed: %v", u.ID, state, err)
+		response.Code = "followListFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if followings == nil {
+		followings = []follow.Following{}
+	}
+	response.Data, err = json.Marshal(followings)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingList - json.Marshal(followings) failed: %v", err)
+		response.Code = "followingMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// FollowingCreate sends a Follow request to a remote actor
+// POST /api/v1/user/following {"actor": "https://remote.social/users/john"}
+// actor can also be an acct: john@remote.social
+func FollowingCreate(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowingCreate - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - failed to read request body: %v", err)
+		response.Code = "requestBodyNotReadable"
+		return response.KO(http.StatusBadRequest)
+	}
+	requestData := struct {
+		Actor string `json:"actor"`
+	}{}
+	if err = json.Unmarshal(body, &requestData); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - unmarshall request body failed: %v", err)
+		response.Code = "requestBodyNotValidJson"
+		return response.KO(http.StatusBadRequest)
+	}
+	if requestData.Actor == "" {
+		response.Code = "actorIsEmpty"
+		return response.KO(http.StatusBadRequest)
+	}
+
+	var actor *remoteactor.RemoteActor
+	if strings.HasPrefix(requestData.Actor, "https://") || strings.HasPrefix(requestData.Actor, "http://") {
+		actor, err = fetchActor(requestData.Actor)
+	} else {
+		actor, err = fetchActorByAcct(requestData.Actor)
+	}
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - fetch actor %s failed: %v", requestData.Actor, err)
+		response.Code = "actorFetchFailed"
+		return response.KO(http.StatusBadGateway)
+	}
+
+	// already following ?
+	_, err = follow.GetFollowing(u.ID, actor.IRI)
+	switch err {
+	case nil:
+		response.Code = "alreadyFollowing"
+		return response.KO(http.StatusConflict)
+	case sql.ErrNoRows:
+	default:
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - follow.GetFollowing(%d, %s) failed: %v", u.ID, actor.IRI, err)
+		response.Code = "followingGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	f := &follow.Following{
+		UserID: u.ID,
+		Actor:  actor.IRI,
+		Inbox:  actor.Inbox,
+		State:  follow.StatePending,
+	}
+	if err = f.Create(); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - following.Create() failed: %v", err)
+		response.Code = "followingCreateFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	// follow activity IRI depends on following ID
+	f.ActivityIRI = fmt.Sprintf("%s#follows/%d", u.IRI(), f.ID)
+	if err = f.Update(); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - following.Update() failed: %v", err)
+		response.Code = "followingUpdateFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	if err = sendFollow(u, f); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - send Follow to %s failed: %v", f.Actor, err)
+		response.Code = "followSendFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	response.Data, err = json.Marshal(f)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingCreate - json.Marshal(following) failed: %v", err)
+		response.Code = "followingMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusCreated)
+}
+
+// FollowingDelete unfollows a remote actor
+// DELETE /api/v1/user/following/:id
+func FollowingDelete(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.FollowingDelete - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Code = "noSuchFollowing"
+		return response.KO(http.StatusNotFound)
+	}
+	f, err := follow.GetFollowingByID(uint(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "noSuchFollowing"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.FollowingDelete - follow.GetFollowingByID(%d) failed: %v", id, err)
+		response.Code = "followingGetFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	if f.UserID != u.ID {
+		response.Code = "noSuchFollowing"
+		return response.KO(http.StatusNotFound)
+	}
+
+	if err = f.Delete(); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingDelete - following.Delete() failed: %v", err)
+		response.Code = "followingDeleteFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	if err = sendUndoFollow(u, f); err != nil {
+		response.Log = fmt.Sprintf("handlers.FollowingDelete - send Undo to %s failed: %v", f.Actor, err)
+		response.Code = "undoSendFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
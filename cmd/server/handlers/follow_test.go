@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/remoteactor"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// mockFederation replaces deliver, deliverToFollowers and fetchActor
+// delivered activities are appended to sent
+func mockFederation(sent *[]*activitypub.Activity) func() {
+	saveDeliver, saveDeliverToFollowers, saveFetchActor := deliver, deliverToFollowers, fetchActor
+	deliver = func(u *user.User, inbox string, a interface{}) {
+		*sent = append(*sent, a.(*activitypub.Activity))
+	}
+	deliverToFollowers = func(u *user.User, a interface{}) {
+		*sent = append(*sent, a.(*activitypub.Activity))
+	}
+	fetchActor = func(iri string) (*remoteactor.RemoteActor, error) {
+		return &remoteactor.RemoteActor{
+			IRI:         iri,
+			Type:        "Person",
+			Inbox:       iri + "/inbox",
+			SharedInbox: "https://remote.social/inbox",
+		}, nil
+	}
+	return func() {
+		deliver, deliverToFollowers, fetchActor = saveDeliver, saveDeliverToFollowers, saveFetchActor
+	}
+}
+
+func TestInboxFollow(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	var sent []*activitypub.Activity
+	defer mockFederation(&sent)()
+	c := context.NewMockedContext(echo.New().NewContext(httptest.NewRequest(echo.POST, "/inbox", nil), httptest.NewRecorder()))
+
+	follow, err := activitypub.ParseActivity([]byte(`{"id":"https://remote.social/1","type":"Follow","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop"}`))
+	handleErr(err)
+
+	// unknown user
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	assert.NoError(t, inboxFollow(c, follow, nil))
+	assert.Equal(t, 0, len(sent))
+
+	// auto accept
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	if assert.NoError(t, inboxFollow(c, follow, nil)) && assert.Equal(t, 1, len(sent)) {
+		assert.Equal(t, "Accept", sent[0].Type)
+		assert.Equal(t, "https://peerpx.social/users/toorop#accepts/follows/2", sent[0].ID)
+		assert.Equal(t, "https://remote.social/1", sent[0].ObjectIRI())
+	}
+
+	// manual approval
+	sent = nil
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "manually_approves_followers"}).AddRow(1, "toorop", true))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	if assert.NoError(t, inboxFollow(c, follow, nil)) {
+		assert.Equal(t, 0, len(sent))
+	}
+
+	// undo
+	undo, err := activitypub.ParseActivity([]byte(`{"id":"https://remote.social/2","type":"Undo","actor":"https://remote.social/users/john","object":{"id":"https://remote.social/1","type":"Follow","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop"}}`))
+	handleErr(err)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "actor"}).AddRow(2, 1, "https://remote.social/users/john"))
+	db.Mock.ExpectPrepare("^DELETE FROM followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	assert.NoError(t, inboxUndo(c, undo, nil))
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestInboxAccept(t *testing.T) {
+	c := context.NewMockedContext(echo.New().NewContext(httptest.NewRequest(echo.POST, "/inbox", nil), httptest.NewRecorder()))
+	accept, err := activitypub.ParseActivity([]byte(`{"id":"https://remote.social/3","type":"Accept","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop#follows/1"}`))
+	handleErr(err)
+
+	// not from followed actor
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "state"}).AddRow(1, "https://remote.social/users/jane", "pending"))
+	assert.NoError(t, inboxAccept(c, accept, nil))
+
+	// ok
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "state"}).AddRow(1, "https://remote.social/users/john", "pending"))
+	db.Mock.ExpectPrepare("^UPDATE following (.*)").
+		ExpectExec().
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "accepted", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, inboxAccept(c, accept, nil))
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestFollowingCreate(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	var sent []*activitypub.Activity
+	defer mockFederation(&sent)()
+	e := echo.New()
+
+	// no actor
+	req := httptest.NewRequest(echo.POST, "/api/v1/user/following", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1, Username: "toorop"})
+	if assert.NoError(t, FollowingCreate(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// already following
+	req = httptest.NewRequest(echo.POST, "/api/v1/user/following", strings.NewReader(`{"actor":"https://remote.social/users/john"}`))
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1, Username: "toorop"})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if assert.NoError(t, FollowingCreate(c)) {
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	}
+
+	// ok
+	req = httptest.NewRequest(echo.POST, "/api/v1/user/following", strings.NewReader(`{"actor":"https://remote.social/users/john"}`))
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1, Username: "toorop"})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO following (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	db.Mock.ExpectPrepare("^UPDATE following (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	if assert.NoError(t, FollowingCreate(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		if assert.Equal(t, 1, len(sent)) {
+			assert.Equal(t, "Follow", sent[0].Type)
+			assert.Equal(t, "https://peerpx.social/users/toorop#follows/4", sent[0].ID)
+			assert.Equal(t, "https://remote.social/users/john", sent[0].ObjectIRI())
+		}
+	}
+}
+
+func TestFollowerAccept(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	var sent []*activitypub.Activity
+	defer mockFederation(&sent)()
+	e := echo.New()
+
+	// not owner
+	req := httptest.NewRequest(echo.POST, "/api/v1/user/followers/2/accept", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	c.Set("u", &user.User{ID: 1, Username: "toorop"})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "state"}).AddRow(2, 3, "pending"))
+	if assert.NoError(t, FollowerAccept(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	c.Set("u", &user.User{ID: 1, Username: "toorop"})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "actor", "activity_iri", "state"}).AddRow(2, 1, "https://remote.social/users/john", "https://remote.social/1", "pending"))
+	db.Mock.ExpectPrepare("^UPDATE followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	if assert.NoError(t, FollowerAccept(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Equal(t, 1, len(sent)) {
+			assert.Equal(t, "Accept", sent[0].Type)
+			assert.Equal(t, "https://remote.social/1", sent[0].ObjectIRI())
+		}
+	}
+}
+
+func TestInboxUpdateDelete(t *testing.T) {
+	c := context.NewMockedContext(echo.New().NewContext(httptest.NewRequest(echo.POST, "/inbox", nil), httptest.NewRecorder()))
+	refreshed := ""
+	saveRefreshActor := refreshActor
+	refreshActor = func(iri string) (*remoteactor.RemoteActor, error) {
+		refreshed = iri
+		return &remoteactor.RemoteActor{IRI: iri}, nil
+	}
+	defer func() { refreshActor = saveRefreshActor }()
+
+	// actor update
+	update, err := activitypub.ParseActivity([]byte(`{"id":"https://remote.social/4","type":"Update","actor":"https://remote.social/users/john","object":{"id":"https://remote.social/users/john","type":"Person"}}`))
+	handleErr(err)
+	if assert.NoError(t, inboxUpdate(c, update, nil)) {
+		assert.Equal(t, "https://remote.social/users/john", refreshed)
+	}
+
+	// someone else
+	refreshed = ""
+	update, err = activitypub.ParseActivity([]byte(`{"id":"https://remote.social/5","type":"Update","actor":"https://remote.social/users/jane","object":{"id":"https://remote.social/users/john","type":"Person"}}`))
+	handleErr(err)
+	if assert.NoError(t, inboxUpdate(c, update, nil)) {
+		assert.Equal(t, "", refreshed)
+	}
+
+	// object update: cached Create is updated
+	update, err = activitypub.ParseActivity([]byte(`{"id":"https://remote.social/6","type":"Update","actor":"https://remote.social/users/john","object":{"id":"https://remote.social/photos/1","type":"Image","name":"new"}}`))
+	handleErr(err)
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WithArgs("https://remote.social/users/john", "https://remote.social/photos/1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "iri", "type", "raw"}).
+			AddRow(1, "https://remote.social/photos/1/activity", "Create", `{"id":"https://remote.social/photos/1/activity","type":"Create","object":{"id":"https://remote.social/photos/1","type":"Image","name":"old"}}`))
+	db.Mock.ExpectPrepare("^UPDATE activities (.*)").
+		ExpectExec().
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			`{"id":"https://remote.social/photos/1/activity","object":{"id":"https://remote.social/photos/1","type":"Image","name":"new"},"type":"Create"}`, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, inboxUpdate(c, update, nil))
+
+	// object delete
+	del, err := activitypub.ParseActivity([]byte(`{"id":"https://remote.social/7","type":"Delete","actor":"https://remote.social/users/john","object":{"id":"https://remote.social/photos/1","type":"Tombstone"}}`))
+	handleErr(err)
+	db.Mock.ExpectPrepare("^DELETE FROM activities (.*)").
+		ExpectExec().
+		WithArgs("https://remote.social/users/john", "https://remote.social/photos/1", "https://remote.social/7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, inboxDelete(c, del, nil))
+
+	// actor delete
+	del, err = activitypub.ParseActivity([]byte(`{"id":"https://remote.social/8","type":"Delete","actor":"https://remote.social/users/john","object":"https://remote.social/users/john"}`))
+	handleErr(err)
+	db.Mock.ExpectPrepare("^DELETE FROM followers (.*)").ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	db.Mock.ExpectPrepare("^DELETE FROM following (.*)").ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "iri"}).AddRow(3, "https://remote.social/users/john"))
+	db.Mock.ExpectPrepare("^DELETE FROM remote_actors (.*)").ExpectExec().WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	db.Mock.ExpectPrepare("^DELETE FROM activities (.*)").ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, inboxDelete(c, del, nil))
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/tiler"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/pkg/iiif"
+	"github.com/peerpx/peerpx/pkg/image"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/log"
+	"github.com/peerpx/peerpx/services/processing"
+)
+
+// iiifMaxArea returns the max area (in pixels) of IIIF images
+// config: iiif.maxArea
+func iiifMaxArea() int {
+	return config.GetIntDefault("iiif.maxArea", 4096*4096)
+}
+
+// IIIFBase redirects the IIIF base URI of a photo to its information
+// GET /iiif/3/:id
+func IIIFBase(c echo.Context) error {
+	return c.Redirect(http.StatusSeeOther, "/iiif/3/"+c.Param("id")+"/info.json")
+}
+
+// IIIFInfo returns the IIIF Image API 3.0 information of a photo
+// GET /iiif/3/:id/info.json
+// size variants are advertised as preferred sizes, deep zoom pyramid
+// levels as tiles
+func IIIFInfo(c echo.Context) error {
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	c.Response().Header().Set("Vary", "Accept")
+
+	p, err := photo.GetByHash(c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		log.Errorf("%v - controllers.IIIFInfo - unable to get photo %s: %v", c.RealIP(), c.Param("id"), err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	info := iiif.NewInfo(p.IIIFURL(), int(p.Width), int(p.Height), iiifMaxArea())
+	for _, s := range p.AvailableSizes() {
+		width, height := s.Dimensions(p)
+		info.AddSize(int(width), int(height))
+	}
+	// deep zoom tiles (once generated)
+	if pyramid, err := photo.GetPyramid(p.Hash); err == nil {
+		info.AddTiles(pyramid.TileSize, pyramid.ScaleFactors())
+	} else if photo.NeedsTiles(p.Width, p.Height) {
+		tiler.Enqueue(p.Hash)
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		log.Errorf("%v - controllers.IIIFInfo - json.Marshal failed: %v", c.RealIP(), err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	contentType := "application/json"
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/ld+json") {
+		contentType = iiif.InfoContentType
+	}
+	c.Response().Header().Set("Cache-Control", "max-age=3600")
+	return c.Blob(http.StatusOK, contentType, b)
+}
+
+// IIIFImage returns a photo transformed by an IIIF Image API 3.0 request
+// GET /iiif/3/:id/:region/:size/:rotation/:file ({quality}.{format})
+func IIIFImage(c echo.Context) error {
+	hash := c.Param("id")
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+
+	request, err := iiif.ParseRequest(c.Param("region"), c.Param("size"), c.Param("rotation"), c.Param("file"))
+	if err != nil {
+		return iiifError(c, err)
+	}
+
+	// cache
+	etag := strings.Join([]string{hash, c.Param("region"), c.Param("size"), c.Param("rotation"), c.Param("file")}, "/")
+	if c.Request().Header.Get("if-none-match") == etag {
+		// check if the photo still exists in datastore
+		if exists, _ := datastore.Exists(hash); exists {
+			c.Response().Header().Set("Etag", etag)
+			c.Response().Header().Set("Cache-Control", "max-age=3600")
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+
+	p, err := photo.GetByHash(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		log.Errorf("%v - controllers.IIIFImage - unable to get photo %s: %v", c.RealIP(), hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	t, err := request.Transform(int(p.Width), int(p.Height), iiifMaxArea())
+	if err != nil {
+		return iiifError(c, err)
+	}
+
+	// tiles are served from the pyramid
+	if request.Format == image.FormatJPEG && !t.Mirror && t.Rotation == 0 && (t.Quality == iiif.QualityDefault || t.Quality == iiif.QualityColor) {
+		if pyramid, err := photo.GetPyramid(hash); err == nil {
+			if level, col, row, ok := pyramid.TileAt(t.Region, t.Width, t.Height); ok {
+				if b, err := photo.GetTileData(hash, level, col, row); err == nil {
+					c.Response().Header().Set("Etag", etag)
+					return tileBlob(c, b)
+				}
+			}
+		}
+	}
+
+	b, err := renderDerivative(p, t, request.Format)
+	if err != nil {
+		return derivativeError(c, "IIIFImage", err)
+	}
+
+	c.Response().Header().Set("Etag", etag)
+	c.Response().Header().Set("Cache-Control", "max-age=3600")
+	return c.Blob(http.StatusOK, image.ContentType(request.Format), b)
+}
+
+// renderDerivative returns photo p transformed by t and encoded in format
+// renditions are rendered by the processing pool (identical renditions
+// in flight are coalesced) and cached in datastore (see photo.PutDerivative)
+// processing.ErrSaturated is returned if the pool is saturated
+func renderDerivative(p *photo.Photo, t *iiif.Transform, format string) ([]byte, error) {
+	spec := t.String() + "." + format
+	b, err := photo.GetDerivative(p.Hash, spec)
+	if err != datastore.ErrNotFound {
+		return b, err
+	}
+	return processing.Do(photo.DerivativeKey(p.Hash, spec), func() ([]byte, error) {
+		// rendered by a coalesced request in the meantime
+		if b, err := photo.GetDerivative(p.Hash, spec); err != datastore.ErrNotFound {
+			return b, err
+		}
+		b, err := photo.GetSizeData(p.Hash, derivativeSource(p, t))
+		if err != nil {
+			return nil, err
+		}
+		img, err := image.NewFromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		if err = t.Apply(img); err != nil {
+			return nil, err
+		}
+		if b, err = photo.Encode(img, format); err != nil {
+			return nil, err
+		}
+		if err = photo.PutDerivative(p.Hash, spec, b); err != nil {
+			log.Errorf("handlers.renderDerivative - photo.PutDerivative(%s, %s) failed: %v", p.Hash, spec, err)
+		}
+		return b, nil
+	})
+}
+
+// derivativeSource returns the size variant of photo p to render t from:
+// the smallest one at least as large as the output if t keeps the whole
+// photo, the full size photo otherwise
+func derivativeSource(p *photo.Photo, t *iiif.Transform) photo.Size {
+	if t.FullRegion(int(p.Width), int(p.Height)) {
+		for _, s := range p.AvailableSizes() {
+			if width, height := s.Dimensions(p); int(width) >= t.Width && int(height) >= t.Height {
+				return s
+			}
+		}
+	}
+	size, _ := photo.GetSize(photo.SizeMax)
+	return size
+}
+
+// derivativeError responds with error err of renderDerivative
+// (handler is the name of the calling handler)
+func derivativeError(c echo.Context, handler string, err error) error {
+	if _, ok := err.(*iiif.Error); ok {
+		return iiifError(c, err)
+	}
+	switch err {
+	case datastore.ErrNotFound:
+		return c.NoContent(http.StatusNotFound)
+	case processing.ErrSaturated:
+		c.Response().Header().Set("Retry-After", "5")
+		return c.NoContent(http.StatusServiceUnavailable)
+	}
+	log.Errorf("%v - controllers.%s - unable to render %s: %v", c.RealIP(), handler, c.Request().URL.Path, err)
+	return c.NoContent(http.StatusInternalServerError)
+}
+
+// iiifError responds with IIIF request error err
+func iiifError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	if e, ok := err.(*iiif.Error); ok {
+		status = e.Status
+	}
+	return c.String(status, err.Error())
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	imageStd "image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/pkg/iiif"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// iiifPhoto returns a 40x20 JPEG
+func iiifPhoto() []byte {
+	src := imageStd.NewRGBA(imageStd.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 12), B: 128, A: 255})
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, src, nil); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIIIFBase(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/iiif/3/hash", nil)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("hash")
+	if assert.NoError(t, IIIFBase(c)) {
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/iiif/3/hash/info.json", rec.Header().Get("Location"))
+	}
+}
+
+func TestIIIFInfo(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/iiif/3/hash/info.json", nil)
+
+	// db error
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(errors.New("mocked"))
+	if assert.NoError(t, IIIFInfo(c)) {
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	}
+
+	// not found
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, IIIFInfo(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok (JSON-LD)
+	req.Header.Set("Accept", "application/ld+json")
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("hash")
+	rows := sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 1000, 500)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(rows)
+	if assert.NoError(t, IIIFInfo(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, iiif.InfoContentType, rec.Header().Get("Content-Type"))
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		info := new(iiif.Info)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), info)) {
+			assert.True(t, strings.HasSuffix(info.ID, "/iiif/3/hash"))
+			assert.Equal(t, "ImageService3", info.Type)
+			assert.Equal(t, 1000, info.Width)
+			// xs, s and max
+			if assert.Len(t, info.Sizes, 3) {
+				assert.Equal(t, iiif.InfoSize{Type: "Size", Width: 320, Height: 160}, info.Sizes[0])
+				assert.Equal(t, iiif.InfoSize{Type: "Size", Width: 1000, Height: 500}, info.Sizes[2])
+			}
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestIIIFImage(t *testing.T) {
+	e := echo.New()
+	// renditions are cached: filesystem datastore
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", iiifPhoto()); err != nil {
+		panic(err)
+	}
+	newContext := func(params ...string) (*context.AppContext, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(echo.GET, "/iiif/3/hash", nil)
+		rec := httptest.NewRecorder()
+		c := context.NewMockedContext(e.NewContext(req, rec))
+		c.SetParamNames("id", "region", "size", "rotation", "file")
+		c.SetParamValues(append([]string{"hash"}, params...)...)
+		return c, rec
+	}
+
+	// bad request
+	c, rec := newContext("full", "max", "0", "default.bmp")
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// not implemented
+	c, rec = newContext("full", "max", "0", "default.tif")
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	}
+
+	// not found
+	c, rec = newContext("full", "max", "0", "default.jpg")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// upscaling without ^
+	c, rec = newContext("full", "80,", "0", "default.jpg")
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 40, 20))
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// ok
+	c, rec = newContext("10,0,20,20", "10,", "90", "gray.png")
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 40, 20))
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "hash/10,0,20,20/10,/90/gray.png", rec.Header().Get("Etag"))
+		img, _, err := imageStd.Decode(rec.Body)
+		if assert.NoError(t, err) {
+			assert.Equal(t, imageStd.Rect(0, 0, 10, 10), img.Bounds())
+		}
+	}
+
+	// not modified
+	c, rec = newContext("10,0,20,20", "10,", "90", "gray.png")
+	c.Request().Header.Set("If-None-Match", "hash/10,0,20,20/10,/90/gray.png")
+	if assert.NoError(t, IIIFImage(c)) {
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
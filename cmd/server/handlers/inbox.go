@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/activity"
+	"github.com/peerpx/peerpx/entities/follow"
+	"github.com/peerpx/peerpx/entities/remoteactor"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/services/config"
+)
+
+// inboxMaxBodySize is the max size of an incoming activity
+const inboxMaxBodySize = 1 << 20
+
+// inboxSignedHeaders are the headers that must be covered by the HTTP signature
+var inboxSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// inboxHandler handles an incoming activity regarding its type
+// recipient is nil if the activity was posted on the shared inbox
+type inboxHandler func(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error
+
+var inboxHandlers = map[string]inboxHandler{
+	"Follow": inboxFollow,
+	"Accept": inboxAccept,
+	"Reject": inboxReject,
+	"Undo":   inboxUndo,
+	"Like":   inboxLike,
+	"Create": inboxCreate,
+	"Delete": inboxDelete,
+	"Update": inboxUpdate,
+}
+
+// fetchPublicKey returns the public key referenced by a keyId and its owner
+// (var for testing purpose)
+var fetchPublicKey = remoteactor.ResolvePublicKey
+
+// refreshActor refetches a remote actor and updates its cached copy
+// (var for testing purpose)
+var refreshActor = remoteactor.Refresh
+
+// Inbox handles activities posted by remote servers
+// POST /users/:username/inbox
+// POST /inbox (shared inbox)
+func Inbox(ac echo.Context) error {
+	return inbox(ac.(*context.AppContext))
+}
+
+// inbox handles an incoming activity
+// if acceptedTypes is not empty, other activity types are refused
+func inbox(c *context.AppContext, acceptedTypes ...string) error {
+	// recipient
+	var recipient *user.User
+	if username := c.Param("username"); username != "" {
+		u, err := user.GetByUsername(username)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.String(http.StatusNotFound, "no such user")
+			}
+			c.LogErrorf("handlers.Inbox - user.GetByUsername(%s) failed: %v", username, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		recipient = u
+	}
+
+	// read body
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, inboxMaxBodySize))
+	defer c.Request().Body.Close()
+	if err != nil {
+		c.LogErrorf("handlers.Inbox - read request body failed: %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	// check signature
+	keyOwner, err := inboxVerifySignature(c.Request(), body)
+	if err != nil {
+		c.LogInfof("handlers.Inbox - signature verification failed: %v", err)
+		return c.String(http.StatusUnauthorized, "signature verification failed")
+	}
+
+	// parse activity
+	a, err := activitypub.ParseActivity(body)
+	if err != nil {
+		c.LogInfof("handlers.Inbox - activitypub.ParseActivity failed: %v", err)
+		return c.String(http.StatusBadRequest, "bad activity")
+	}
+	if a.ID == "" {
+		return c.String(http.StatusBadRequest, "activity id is missing")
+	}
+	if len(acceptedTypes) != 0 && !inboxTypeAccepted(a.Type, acceptedTypes) {
+		return c.String(http.StatusBadRequest, "unexpected activity type "+a.Type)
+	}
+
+	// actor must be the key owner
+	if string(a.Actor) != keyOwner {
+		c.LogInfof("handlers.Inbox - actor %s is not the owner of the key (%s)", a.Actor, keyOwner)
+		return c.String(http.StatusUnauthorized, "actor mismatch")
+	}
+
+	// already received ?
+	_, err = activity.GetByIRI(a.ID)
+	switch err {
+	case nil:
+		return c.NoContent(http.StatusAccepted)
+	case sql.ErrNoRows:
+	default:
+		c.LogErrorf("handlers.Inbox - activity.GetByIRI(%s) failed: %v", a.ID, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// dispatch
+	// activity is persisted once handled: if handling fails, the sender's
+	// retry must not be taken for a duplicate
+	if handler, found := inboxHandlers[a.Type]; found {
+		if err = handler(c, a, recipient); err != nil {
+			c.LogErrorf("handlers.Inbox - handle %s %s failed: %v", a.Type, a.ID, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	} else {
+		c.LogInfof("handlers.Inbox - unsupported activity %s from %s", a.Type, a.Actor)
+	}
+
+	// persist
+	record := &activity.Activity{
+		IRI:    a.ID,
+		Type:   a.Type,
+		Actor:  string(a.Actor),
+		Object: a.ObjectIRI(),
+		Raw:    string(body),
+	}
+	if recipient != nil {
+		record.UserID = recipient.ID
+	}
+	if err = record.Create(); err != nil {
+		c.LogErrorf("handlers.Inbox - activity.Create(%s) failed: %v", a.ID, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// inboxTypeAccepted returns true if activityType is in acceptedTypes
+func inboxTypeAccepted(activityType string, acceptedTypes []string) bool {
+	for _, t := range acceptedTypes {
+		if t == activityType {
+			return true
+		}
+	}
+	return false
+}
+
+// inboxVerifySignature checks Digest and HTTP Signature of r
+// and returns the owner of the key used to sign the request
+func inboxVerifySignature(r *http.Request, body []byte) (string, error) {
+	if err := cryptobox.DigestVerify(r.Header.Get("Digest"), body); err != nil {
+		return "", err
+	}
+	sig, err := cryptobox.HTTPSignatureParse(r)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range inboxSignedHeaders {
+		if !sig.Covers(h) {
+			return "", fmt.Errorf("header %s is not signed", h)
+		}
+	}
+	if err = cryptobox.HTTPSignatureCheckDate(r, config.GetDurationDefault("federation.signatureMaxAge", 12*time.Hour)); err != nil {
+		return "", err
+	}
+	pubKey, owner, err := fetchPublicKey(sig.KeyID, false)
+	if err != nil {
+		return "", fmt.Errorf("fetch key %s failed: %v", sig.KeyID, err)
+	}
+	if err = sig.Verify(r, pubKey); err != nil {
+		// key may have been rotated, refresh it and retry
+		pubKey, owner, err = fetchPublicKey(sig.KeyID, true)
+		if err != nil {
+			return "", fmt.Errorf("refresh key %s failed: %v", sig.KeyID, err)
+		}
+		if err = sig.Verify(r, pubKey); err != nil {
+			return "", err
+		}
+	}
+	return owner, nil
+}
+
+// inboxFollow handles Follow activities
+// follower is accepted right away unless the followed user
+// manually approves followers
+func inboxFollow(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	u, err := user.GetByIRI(a.ObjectIRI())
+	if err != nil {
+		if err == user.ErrNoSuchUser || err == sql.ErrNoRows {
+			c.LogInfof("handlers.Inbox - %s wants to follow unknown user %s", a.Actor, a.ObjectIRI())
+			return nil
+		}
+		return err
+	}
+	if recipient != nil && recipient.ID != u.ID {
+		c.LogInfof("handlers.Inbox - Follow %s posted to %s inbox targets another user", a.ID, recipient.Username)
+		return nil
+	}
+
+	actor, err := fetchActor(string(a.Actor))
+	if err != nil {
+		return fmt.Errorf("fetch actor %s failed: %v", a.Actor, err)
+	}
+
+	f, err := follow.GetFollower(u.ID, actor.IRI)
+	switch err {
+	case nil:
+		// already known, update follow activity and inboxes
+		f.Inbox = actor.Inbox
+		f.SharedInbox = actor.SharedInbox
+		f.ActivityIRI = a.ID
+		if err = f.Update(); err != nil {
+			return err
+		}
+	case sql.ErrNoRows:
+		f = &follow.Follower{
+			UserID:      u.ID,
+			Actor:       actor.IRI,
+			Inbox:       actor.Inbox,
+			SharedInbox: actor.SharedInbox,
+			ActivityIRI: a.ID,
+			State:       follow.StateAccepted,
+		}
+		if u.ManuallyApprovesFollowers {
+			f.State = follow.StatePending
+		}
+		if err = f.Create(); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	if f.State == follow.StatePending {
+		c.LogInfof("handlers.Inbox - follow request from %s to %s is waiting for approval", f.Actor, u.Username)
+		return nil
+	}
+	return sendFollowResponse(u, f, "Accept")
+}
+
+// inboxAccept handles Accept activities (response to our Follow)
+func inboxAccept(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	f, err := follow.GetFollowingByActivityIRI(a.ObjectIRI())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.LogInfof("handlers.Inbox - %s accepted unknown activity %s", a.Actor, a.ObjectIRI())
+			return nil
+		}
+		return err
+	}
+	if f.Actor != string(a.Actor) {
+		c.LogInfof("handlers.Inbox - %s can't accept a Follow sent to %s", a.Actor, f.Actor)
+		return nil
+	}
+	f.State = follow.StateAccepted
+	return f.Update()
+}
+
+// inboxReject handles Reject activities (response to our Follow)
+func inboxReject(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	f, err := follow.GetFollowingByActivityIRI(a.ObjectIRI())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.LogInfof("handlers.Inbox - %s rejected unknown activity %s", a.Actor, a.ObjectIRI())
+			return nil
+		}
+		return err
+	}
+	if f.Actor != string(a.Actor) {
+		c.LogInfof("handlers.Inbox - %s can't reject a Follow sent to %s", a.Actor, f.Actor)
+		return nil
+	}
+	return f.Delete()
+}
+
+// inboxUndo handles Undo activities
+func inboxUndo(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	if a.ObjectType() != "Follow" {
+		c.LogInfof("handlers.Inbox - %s undo %s", a.Actor, a.ObjectIRI())
+		return nil
+	}
+	followActivity, err := a.ObjectActivity()
+	if err != nil {
+		return err
+	}
+	if followActivity.Actor != a.Actor {
+		c.LogInfof("handlers.Inbox - %s can't undo a Follow of %s", a.Actor, followActivity.Actor)
+		return nil
+	}
+	u, err := user.GetByIRI(followActivity.ObjectIRI())
+	if err != nil {
+		if err == user.ErrNoSuchUser || err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	f, err := follow.GetFollower(u.ID, string(a.Actor))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	c.LogInfof("handlers.Inbox - %s unfollows %s", a.Actor, u.Username)
+	return f.Delete()
+}
+
+// inboxLike handles Like activities
+func inboxLike(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	c.LogInfof("handlers.Inbox - %s likes %s", a.Actor, a.ObjectIRI())
+	return nil
+}
+
+// inboxCreate handles Create activities
+func inboxCreate(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	c.LogInfof("handlers.Inbox - %s created %s", a.Actor, a.ObjectIRI())
+	return nil
+}
+
+// inboxDelete handles Delete activities
+// cached copies of the deleted object (activities embedding it) are removed,
+// if the actor deletes itself, every relationship with it is removed too
+func inboxDelete(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	objectIRI := a.ObjectIRI()
+	if objectIRI == "" {
+		return nil
+	}
+	if objectIRI == string(a.Actor) {
+		c.LogInfof("handlers.Inbox - actor %s has been deleted", a.Actor)
+		if err := follow.DeleteActor(objectIRI); err != nil {
+			return err
+		}
+		actor, err := remoteactor.GetByIRI(objectIRI)
+		switch err {
+		case nil:
+			if err = actor.Delete(); err != nil {
+				return err
+			}
+		case sql.ErrNoRows:
+		default:
+			return err
+		}
+	}
+	c.LogInfof("handlers.Inbox - %s deleted %s", a.Actor, objectIRI)
+	return activity.DeleteByObject(string(a.Actor), objectIRI, a.ID)
+}
+
+// inboxUpdate handles Update activities
+// actors are refetched, others objects replace their cached copies
+// (embedded in Create activities of the same actor)
+func inboxUpdate(c *context.AppContext, a *activitypub.Activity, recipient *user.User) error {
+	objectIRI := a.ObjectIRI()
+	objectType := a.ObjectType()
+	if activitypub.IsActorType(objectType) {
+		if objectIRI != string(a.Actor) {
+			c.LogInfof("handlers.Inbox - %s can't update actor %s", a.Actor, objectIRI)
+			return nil
+		}
+		_, err := refreshActor(objectIRI)
+		return err
+	}
+	if objectType == "" {
+		c.LogInfof("handlers.Inbox - %s updated %s (not embedded)", a.Actor, objectIRI)
+		return nil
+	}
+
+	activities, err := activity.ListByObject(string(a.Actor), objectIRI)
+	if err != nil {
+		return err
+	}
+	for _, cached := range activities {
+		if cached.Type != "Create" {
+			continue
+		}
+		raw, err := activityReplaceObject(cached.Raw, a.Object)
+		if err != nil {
+			c.LogInfof("handlers.Inbox - update cached copy of %s in %s failed: %v", objectIRI, cached.IRI, err)
+			continue
+		}
+		cached.Raw = raw
+		if err = cached.Update(); err != nil {
+			return err
+		}
+	}
+	c.LogInfof("handlers.Inbox - %s updated %s", a.Actor, objectIRI)
+	return nil
+}
+
+// activityReplaceObject returns raw activity with its object replaced by object
+func activityReplaceObject(raw string, object json.RawMessage) (string, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return "", err
+	}
+	doc["object"] = object
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/remoteactor"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const remoteActor = "https://remote.social/users/john"
+
+// newSignedInboxRequest returns a POST request on the shared inbox signed with key
+func newSignedInboxRequest(key *rsa.PrivateKey, body string) *http.Request {
+	req := httptest.NewRequest(echo.POST, "/inbox", strings.NewReader(body))
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", cryptobox.DigestSHA256([]byte(body)))
+	handleErr(cryptobox.HTTPSign(req, remoteActor+"#main-key", key, inboxSignedHeaders))
+	return req
+}
+
+func TestInbox(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	e := echo.New()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	handleErr(err)
+	// first call returns an outdated key
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	handleErr(err)
+	refreshed := false
+	fetchPublicKey = func(keyID string, forceRefresh bool) (*rsa.PublicKey, string, error) {
+		if forceRefresh {
+			refreshed = true
+			return &key.PublicKey, remoteActor, nil
+		}
+		return &oldKey.PublicKey, remoteActor, nil
+	}
+	defer func() { fetchPublicKey = remoteactor.ResolvePublicKey }()
+
+	follow := `{"id":"https://remote.social/1","type":"Follow","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop"}`
+
+	// not signed
+	req := httptest.NewRequest(echo.POST, "/inbox", strings.NewReader(follow))
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// digest mismatch
+	req = newSignedInboxRequest(key, follow)
+	req.Header.Set("Digest", cryptobox.DigestSHA256([]byte("foo")))
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// actor is not key owner
+	req = newSignedInboxRequest(key, strings.Replace(follow, "users/john", "users/jane", 1))
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// already received
+	req = newSignedInboxRequest(key, follow)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.True(t, refreshed)
+	}
+
+	// ok
+	req = newSignedInboxRequest(key, follow)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	// followed user doesn't exist
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO activities (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+	}
+
+	// handler failed: activity is not persisted (it will be retried)
+	req = newSignedInboxRequest(key, follow)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrConnDone)
+	if assert.NoError(t, Inbox(c)) {
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	}
+
+	// follow endpoint only accepts Follow
+	like := strings.Replace(strings.Replace(follow, "Follow", "Like", 1), "remote.social/1", "remote.social/2", 1)
+	req = newSignedInboxRequest(key, like)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("username")
+	c.SetParamValues("toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	if assert.NoError(t, UserNewFollower(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/webfinger"
+	"github.com/peerpx/peerpx/services/config"
+)
+
+// SoftwareVersion is the version of PeerPx advertised by NodeInfo
+// (set at build time with -ldflags "-X ...handlers.SoftwareVersion=x.y.z")
+var SoftwareVersion = "0.1.0-dev"
+
+// nodeInfoSchema is the NodeInfo schema URL prefix (append version)
+const nodeInfoSchema = "http://nodeinfo.diaspora.software/ns/schema/"
+
+// nodeInfoVersions are the supported NodeInfo versions
+var nodeInfoVersions = []string{"2.0", "2.1"}
+
+// nodeInfoSoftware is the software section of a NodeInfo document
+type nodeInfoSoftware struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"` // 2.1
+	Homepage   string `json:"homepage,omitempty"`   // 2.1
+}
+
+// nodeInfoUsers are the user counts of a NodeInfo document
+type nodeInfoUsers struct {
+	Total          int `json:"total"`
+	ActiveMonth    int `json:"activeMonth"`
+	ActiveHalfyear int `json:"activeHalfyear"`
+}
+
+// nodeInfo is a NodeInfo 2.0/2.1 document
+type nodeInfo struct {
+	Version   string           `json:"version"`
+	Software  nodeInfoSoftware `json:"software"`
+	Protocols []string         `json:"protocols"`
+	Services  struct {
+		Inbound  []string `json:"inbound"`
+		Outbound []string `json:"outbound"`
+	} `json:"services"`
+	OpenRegistrations bool `json:"openRegistrations"`
+	Usage             struct {
+		Users      nodeInfoUsers `json:"users"`
+		LocalPosts int           `json:"localPosts"`
+	} `json:"usage"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// NodeInfoDiscovery returns links to supported NodeInfo documents
+// GET /.well-known/nodeinfo
+func NodeInfoDiscovery(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	links := struct {
+		Links []webfinger.Link `json:"links"`
+	}{}
+	for _, version := range nodeInfoVersions {
+		links.Links = append(links.Links, webfinger.Link{
+			Rel:  nodeInfoSchema + version,
+			Href: fmt.Sprintf("https://%s/nodeinfo/%s", config.GetString("hostname"), version),
+		})
+	}
+	return c.JSON(http.StatusOK, links)
+}
+
+// NodeInfo returns NodeInfo document of version :version
+// GET /nodeinfo/:version
+// config:
+//   - registration.open: are registrations open (default true)
+//   - nodeinfo.name: node name
+//   - nodeinfo.description: node description
+func NodeInfo(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	version := c.Param("version")
+	supported := false
+	for _, v := range nodeInfoVersions {
+		supported = supported || v == version
+	}
+	if !supported {
+		return c.String(http.StatusNotFound, "unsupported NodeInfo version")
+	}
+
+	ni := nodeInfo{
+		Version: version,
+		Software: nodeInfoSoftware{
+			Name:    "peerpx",
+			Version: SoftwareVersion,
+		},
+		Protocols:         []string{"activitypub"},
+		OpenRegistrations: config.GetBoolDefault("registration.open", true),
+		Metadata: map[string]interface{}{
+			"nodeName":        config.GetString("nodeinfo.name"),
+			"nodeDescription": config.GetString("nodeinfo.description"),
+		},
+	}
+	if version == "2.1" {
+		ni.Software.Repository = "https://github.com/peerpx/peerpx"
+		ni.Software.Homepage = "https://github.com/peerpx/peerpx"
+	}
+	ni.Services.Inbound = []string{}
+	ni.Services.Outbound = []string{}
+
+	// usage
+	var err error
+	if ni.Usage.Users.Total, err = user.Count(); err != nil {
+		c.LogErrorf("handlers.NodeInfo - user.Count() failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// users are active if they added a photo during the period
+	now := time.Now()
+	if ni.Usage.Users.ActiveMonth, err = photo.CountUploadersSince(now.AddDate(0, -1, 0)); err != nil {
+		c.LogErrorf("handlers.NodeInfo - photo.CountUploadersSince() failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if ni.Usage.Users.ActiveHalfyear, err = photo.CountUploadersSince(now.AddDate(0, -6, 0)); err != nil {
+		c.LogErrorf("handlers.NodeInfo - photo.CountUploadersSince() failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// local posts are federated (public) photos
+	if ni.Usage.LocalPosts, err = photo.Count(photo.ListArgs{PublicOnly: true}); err != nil {
+		c.LogErrorf("handlers.NodeInfo - photo.Count() failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	photos, err := photo.Count()
+	if err != nil {
+		c.LogErrorf("handlers.NodeInfo - photo.Count() failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	ni.Metadata["photos"] = photos
+
+	b, err := json.Marshal(ni)
+	if err != nil {
+		c.LogErrorf("handlers.NodeInfo - json.Marshal failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.Blob(http.StatusOK, fmt.Sprintf(`application/json; profile="%s%s#"`, nodeInfoSchema, version), b)
+}
+
+// hostMetaLink is a link of the host-meta XRD document
+type hostMetaLink struct {
+	Rel      string `xml:"rel,attr"`
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// hostMetaXRD is the host-meta XRD document
+type hostMetaXRD struct {
+	XMLName xml.Name       `xml:"http://docs.oasis-open.org/ns/xri/xrd-1.0 XRD"`
+	Links   []hostMetaLink `xml:"Link"`
+}
+
+// HostMeta returns the host-meta document (RFC 6415) pointing to WebFinger
+// GET /.well-known/host-meta (XRD)
+// GET /.well-known/host-meta.json (JRD)
+func HostMeta(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	template := fmt.Sprintf("https://%s/.well-known/webfinger?resource={uri}", config.GetString("hostname"))
+
+	if c.Request().URL.Path == "/.well-known/host-meta.json" {
+		return c.JSON(http.StatusOK, webfinger.JRD{
+			Links: []webfinger.Link{{Rel: "lrdd", Type: webfinger.ContentType, Template: template}},
+		})
+	}
+
+	xrd := hostMetaXRD{
+		Links: []hostMetaLink{{Rel: "lrdd", Type: webfinger.ContentType, Template: template}},
+	}
+	b, err := xml.Marshal(xrd)
+	if err != nil {
+		c.LogErrorf("handlers.HostMeta - xml.Marshal failed: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.Blob(http.StatusOK, "application/xrd+xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestNodeInfoDiscovery(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(echo.New().NewContext(httptest.NewRequest(echo.GET, "/.well-known/nodeinfo", nil), rec))
+	if assert.NoError(t, NodeInfoDiscovery(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"href":"https://peerpx.social/nodeinfo/2.1"`)
+	}
+}
+
+func TestNodeInfo(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("registration.open", "false")
+	config.Set("nodeinfo.name", "My node")
+	e := echo.New()
+
+	// unsupported version
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(httptest.NewRequest(echo.GET, "/nodeinfo/1.0", nil), rec))
+	c.SetParamNames("version")
+	c.SetParamValues("1.0")
+	if assert.NoError(t, NodeInfo(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// 2.1
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(httptest.NewRequest(echo.GET, "/nodeinfo/2.1", nil), rec))
+	c.SetParamNames("version")
+	c.SetParamValues("2.1")
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM users").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(50))
+	if assert.NoError(t, NodeInfo(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `application/json; profile="http://nodeinfo.diaspora.software/ns/schema/2.1#"`, rec.Header().Get("Content-Type"))
+		ni := new(nodeInfo)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), ni)) {
+			assert.Equal(t, "2.1", ni.Version)
+			assert.Equal(t, "peerpx", ni.Software.Name)
+			assert.False(t, ni.OpenRegistrations)
+			assert.Equal(t, nodeInfoUsers{Total: 10, ActiveMonth: 2, ActiveHalfyear: 5}, ni.Usage.Users)
+			assert.Equal(t, 42, ni.Usage.LocalPosts)
+			assert.Equal(t, float64(50), ni.Metadata["photos"])
+			assert.Equal(t, "My node", ni.Metadata["nodeName"])
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestHostMeta(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(echo.New().NewContext(httptest.NewRequest(echo.GET, "/.well-known/host-meta", nil), rec))
+	if assert.NoError(t, HostMeta(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "application/xrd+xml"))
+		assert.Contains(t, rec.Body.String(), `<Link rel="lrdd" type="application/jrd+json" template="https://peerpx.social/.well-known/webfinger?resource={uri}"></Link>`)
+	}
+}
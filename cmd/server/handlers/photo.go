@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -16,18 +15,23 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/cmd/server/tiler"
 	"github.com/peerpx/peerpx/entities/photo"
 	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/exif"
 	"github.com/peerpx/peerpx/pkg/hasher"
+	"github.com/peerpx/peerpx/pkg/iiif"
 	"github.com/peerpx/peerpx/pkg/image"
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/datastore"
 	"github.com/peerpx/peerpx/services/log"
+	"github.com/peerpx/peerpx/services/processing"
 )
 
 // PhotoCreate handle POST /api/v1.photo request
 // response.Code:
-// unsupportedPhotoFormat: bad photo format (jpeg or png)
+// unsupportedPhotoFormat: bad photo format (jpeg, png, gif, bmp, tiff or webp)
 // badData: bad data (not valid photo struct/object)
 // badFile: bad file
 // duplicate: duplicate
@@ -88,21 +92,60 @@ func PhotoCreate(ac echo.Context) error {
 		return response.KO(http.StatusInternalServerError)
 	}
 
-	// check mime type
-	mimeType := http.DetectContentType(photoBytes)
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		response.Log = fmt.Sprintf("handlers.PhotoCreate - %s is not supported for photo", mimeType)
+	// check format (sniffed from magic bytes)
+	format := image.DetectFormat(photoBytes)
+	if !image.IsSupported(format) {
+		err = image.ErrUnsupportedFormat{Format: format}
+		response.Log = fmt.Sprintf("handlers.PhotoCreate - %v", err)
 		response.Code = "unsupportedPhotoFormat"
+		response.Message = err.Error()
 		return response.KO(http.StatusBadRequest)
 	}
+	// original upload is stored untouched
+	originalBytes := photoBytes
+	p.OriginalType = image.ContentType(format)
+
+	// get user
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.PhotoCreate - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+	p.UserID = u.ID
+
+	// EXIF (lost by re-encoding) filtered by user metadata policy
+	if e, err := exif.Parse(photoBytes); err == nil {
+		e.Filter(u.MetadataPolicy)
+		if err = p.SetExif(e); err != nil {
+			c.LogErrorf("handlers.PhotoCreate - p.SetExif failed: %v", err)
+		}
+	}
+	metadata, err := exif.Metadata(photoBytes, u.MetadataPolicy, p.LicenceType.URL())
+	if err != nil {
+		c.LogErrorf("handlers.PhotoCreate - exif.Metadata failed: %v", err)
+	}
+	if config.GetBoolDefault("photo.embedSRGBProfile", false) {
+		metadata = append(metadata, image.SRGBProfileSegment()...)
+	}
 
 	// resize && re-encode
 	img, err := image.NewFromBytes(photoBytes)
 	if err != nil {
 		response.Log = fmt.Sprintf("handlers.PhotoCreate - image.NewFromBytes(photoBytes) failed: %v", err)
+		if err == image.ErrTooLarge {
+			response.Code = "photoTooLarge"
+			response.Message = err.Error()
+			return response.KO(http.StatusBadRequest)
+		}
 		response.Code = "imageNewFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	// orientation & sRGB (unsupported ICC profiles are ignored)
+	if err = img.Normalize(); err != nil {
+		c.LogErrorf("handlers.PhotoCreate - img.Normalize failed: %v", err)
+	}
 
 	if img.Width() > config.GetIntDefault("photo.maxWidth", 2000) || img.Height() > config.GetIntDefault("photo.maxHeight", 2000) {
 		err = img.ResizeToFit(config.GetIntDefault("photo.maxWidth", 2000), config.GetIntDefault("photo.maxHeight", 2000))
@@ -113,13 +156,14 @@ func PhotoCreate(ac echo.Context) error {
 		}
 	}
 
-	// To JPEG
-	photoBytes, err = img.JPEG(100)
+	// display master
+	photoBytes, err = img.JPEG(config.GetIntDefault("photo.masterQuality", 92))
 	if err != nil {
 		response.Log = fmt.Sprintf("handlers.PhotoCreate - img.JPEG failed: %v", err)
 		response.Code = "conversionJpegFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	photoBytes = exif.Insert(photoBytes, metadata)
 
 	// get hash
 	p.Hash, err = hasher.GetHash(photoBytes)
@@ -128,27 +172,19 @@ func PhotoCreate(ac echo.Context) error {
 		response.Code = "conversionJpegFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	p.OriginalHash, err = hasher.GetHash(originalBytes)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoCreate - hasher.GetHash(originalBytes) failed: %v", err)
+		response.Code = "hashFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
 
 	//  size
 	p.Width = uint32(img.Width())
 	p.Height = uint32(img.Height())
 
 	// URL
-	if config.GetBool("http.tlsEnabled") {
-		p.URL = fmt.Sprintf("https://%s/api/v1/photo/%s/max", config.GetStringP("hostname"), p.Hash)
-	} else {
-		p.URL = fmt.Sprintf("http://%s/api/v1/photo/%s/max", config.GetStringP("hostname"), p.Hash)
-	}
-
-	// get user
-	ui := c.Get("u")
-	if ui == nil {
-		response.Log = "handlers.PhotoCreate - c.Get(u) return empty string"
-		response.Code = "userNotInContext"
-		return response.KO(http.StatusUnauthorized)
-	}
-	u := ui.(*user.User)
-	p.UserID = u.ID
+	p.URL = p.SizeURL(photo.SizeMax)
 
 	// save in datastore
 	if err = datastore.Put(p.Hash, photoBytes); err != nil {
@@ -156,6 +192,11 @@ func PhotoCreate(ac echo.Context) error {
 		response.Code = "datastoreFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	if err = datastore.Put(p.OriginalHash, originalBytes); err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoCreate - put original in store failed: %v", err)
+		response.Code = "datastoreFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
 
 	// create entry in DB
 	if err = p.Create(); err != nil {
@@ -165,8 +206,10 @@ func PhotoCreate(ac echo.Context) error {
 
 		// remove photo from datastore
 		if !strings.HasPrefix(err.Error(), "UNIQUE") {
-			if err2 := datastore.Delete(p.Hash); err2 != nil {
-				c.LogErrorf(" datastore.Delete(%s): %v", p.Hash, err2)
+			for _, key := range []string{p.Hash, p.OriginalHash} {
+				if err2 := datastore.Delete(key); err2 != nil {
+					c.LogErrorf(" datastore.Delete(%s): %v", key, err2)
+				}
 			}
 			response.Log = fmt.Sprintf("handlers.PhotoCreate - photo.Create failed: %v", err)
 			response.HTTPStatus = http.StatusInternalServerError
@@ -176,6 +219,25 @@ func PhotoCreate(ac echo.Context) error {
 		return c.JSON(response.HTTPStatus, response)
 	}
 
+	// size variants (missing ones will be generated on demand)
+	if err = photo.GenerateSizes(p.Hash, img, metadata); err != nil {
+		c.LogErrorf("handlers.PhotoCreate - photo.GenerateSizes failed: %v", err)
+	}
+	// deep zoom tiles are generated in background
+	if photo.NeedsTiles(p.Width, p.Height) {
+		tiler.Enqueue(p.Hash)
+	}
+
+	// federate
+	if !p.Privacy {
+		create, err := photoCreate(p, u)
+		if err != nil {
+			c.LogErrorf("handlers.PhotoCreate - photoCreate(%s) failed: %v", p.Hash, err)
+		} else {
+			deliverToFollowers(u, create)
+		}
+	}
+
 	// marshal photo
 	response.Data, err = json.Marshal(p)
 	if err != nil {
@@ -206,6 +268,11 @@ func PhotoGetProperties(ac echo.Context) error {
 		response.Code = "getByHashFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	if err = photoPublicLocation(c, p); err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoGetProperties - photoPublicLocation failed: %v", err)
+		response.Code = "getOwnerFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
 	// marshal photo
 	response.Data, err = json.Marshal(p)
 	if err != nil {
@@ -217,28 +284,289 @@ func PhotoGetProperties(ac echo.Context) error {
 	return response.OK(http.StatusOK)
 }
 
-// PhotoGet return a photo
+// photoPublicLocation rounds the location of photo p to a coarse grid if
+// the metadata policy of its owner requires it (unless owner is requesting)
+func photoPublicLocation(c *context.AppContext, p *photo.Photo) error {
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return nil
+	}
+	if u, ok := c.Get("u").(*user.User); ok && u.ID == p.UserID {
+		return nil
+	}
+	owner, err := user.GetByID(int(p.UserID))
+	if err != nil {
+		return err
+	}
+	if !owner.MetadataPolicy.ExactLocation() {
+		p.CoarseLocation()
+	}
+	return nil
+}
+
+// PhotoGetExif returns raw EXIF tags of a photo
+// GET /api/v1/photo/:id/exif
+// private photos tags are only returned to their owner, others get tags
+// filtered by the current metadata policy of the owner
+func PhotoGetExif(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	// get ID -> hash
+	hash := c.Param("id")
+
+	// get photo
+	p, err := photo.GetByHash(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.Code = "notFound"
+			return response.KO(http.StatusNotFound)
+		}
+		response.Log = fmt.Sprintf("handlers.PhotoGetExif - photo.GetByHash(%s) failed: %v", hash, err)
+		response.Code = "getByHashFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	u, _ := c.Get("u").(*user.User)
+	isOwner := u != nil && u.ID == p.UserID
+	if p.Privacy && !isOwner {
+		response.Code = "notFound"
+		return response.KO(http.StatusNotFound)
+	}
+	tags, err := p.ExifTags()
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoGetExif - p.ExifTags() failed: %v", err)
+		response.Code = "exifUnmarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	// policy may have been tightened since upload
+	if !isOwner {
+		owner, err := user.GetByID(int(p.UserID))
+		if err != nil {
+			response.Log = fmt.Sprintf("handlers.PhotoGetExif - user.GetByID(%d) failed: %v", p.UserID, err)
+			response.Code = "getOwnerFailed"
+			return response.KO(http.StatusInternalServerError)
+		}
+		e := &exif.Exif{Tags: tags}
+		e.Filter(owner.MetadataPolicy)
+		tags = e.Tags
+	}
+	response.Data, err = json.Marshal(tags)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoGetExif - json.Marshal(tags) failed: %v", err)
+		response.Code = "marshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
+// PhotoObject returns a public photo as an ActivityStreams Image
+// GET /photos/:hash
+func PhotoObject(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	if c.GetWantedContentType() != "json" {
+		return c.String(http.StatusNotFound, "html is not implemented yet")
+	}
+
+	hash := strings.TrimSuffix(c.Param("hash"), ".json")
+	p, err := photo.GetByHash(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.LogErrorf("handlers.PhotoObject - photo.GetByHash(%s) failed: %v", hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// private photos are not federated
+	if p.Privacy {
+		return c.NoContent(http.StatusNotFound)
+	}
+	u, err := user.GetByID(int(p.UserID))
+	if err != nil {
+		c.LogErrorf("handlers.PhotoObject - user.GetByID(%d) failed: %v", p.UserID, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	image := photoImage(p, u)
+	image.Context = activitypub.ImageContext
+	return activityJSON(c, image)
+}
+
+// PhotoGet return a photo in size :size (xs, s, m, l, xl, max)
+// photo is streamed from datastore (byte ranges are supported) or
+// redirected to if datastore presigns URLs
 func PhotoGet(c echo.Context) error {
 	// get hash & size
 	hash := c.Param("id")
-	size := c.Param("size")
-	// osef de size for now
-	_ = size
+	sizeName := c.Param("size")
+	if sizeName == "" {
+		sizeName = photo.SizeMax
+	}
+	size, found := photo.GetSize(sizeName)
+	if !found {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	format := negotiatePhotoFormat(c.Request().Header.Get("Accept"))
+	c.Response().Header().Set("Vary", "Accept")
 
 	// get photo from data store
-	photoBytes, err := datastore.Get(hash)
+	key, err := sizeFormatKey(hash, size, format)
 	if err != nil {
-		if err == datastore.ErrNotFound {
-			return c.NoContent(http.StatusNotFound)
-		}
-		log.Errorf("%v - controllers.PhotoGet - unable to get %s from datastore: %v", c.RealIP(), hash, err)
+		return derivativeError(c, "PhotoGet", err)
+	}
+	// direct download from storage
+	if url, err := datastore.PresignedURL(key); err == nil {
+		return c.Redirect(http.StatusFound, url)
+	}
+	info, err := datastore.Stat(key)
+	if err != nil {
+		log.Errorf("%v - controllers.PhotoGet - unable to stat %s: %v", c.RealIP(), key, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	r, err := datastore.GetReader(key)
+	if err != nil {
+		log.Errorf("%v - controllers.PhotoGet - unable to read %s: %v", c.RealIP(), key, err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	defer r.Close()
 	// cache
-	c.Response().Header().Set("Etag", hash)
+	c.Response().Header().Set("Etag", photo.FormatKey(size.Key(hash), format))
 	c.Response().Header().Set("Cache-Control", "max-age=120")
 
-	return c.Blob(http.StatusOK, "image/jpeg ", photoBytes)
+	return streamBlob(c, image.ContentType(format), r, info)
+}
+
+// sizeFormatKey returns the datastore key of size s of photo hash in format
+// missing variants are generated on the processing pool
+// (processing.ErrSaturated is returned if it is saturated)
+func sizeFormatKey(hash string, s photo.Size, format string) (string, error) {
+	key := photo.FormatKey(s.Key(hash), format)
+	if _, err := datastore.Stat(key); err != datastore.ErrNotFound {
+		return key, err
+	}
+	// pool results are bytes: the key is returned as such
+	b, err := processing.Do(key, func() ([]byte, error) {
+		key, err := photo.GetSizeFormatKey(hash, s, format)
+		return []byte(key), err
+	})
+	return string(b), err
+}
+
+// negotiatePhotoFormat returns the delivery format of photos regarding
+// Accept header (see photo.DeliveryFormats)
+// PNG must be explicitly accepted, JPEG is the default
+func negotiatePhotoFormat(accept string) string {
+	// media range -> quality
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaRange == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[mediaRange] = q
+	}
+
+	for _, format := range photo.DeliveryFormats {
+		q, found := accepted[image.ContentType(format)]
+		if !found && format == image.FormatJPEG {
+			// JPEG is matched by wildcards (or no Accept header at all)
+			if q, found = accepted["image/*"]; !found {
+				if q, found = accepted["*/*"]; !found {
+					q, found = 1, len(accepted) == 0
+				}
+			}
+		}
+		if found && q > 0 {
+			return format
+		}
+	}
+	return image.FormatJPEG
+}
+
+// originalExtensions are file extensions of original uploads by content type
+var originalExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tif",
+	"image/webp": ".webp",
+}
+
+// PhotoGetOriginal returns the untouched upload of a photo
+// GET /api/v1/photo/:id/original
+// only the owner can download it unless the photo is public,
+// under a Creative Commons licence and its owner keeps all metadata
+// (originals are not filtered by metadata policy)
+// original is streamed from datastore (byte ranges are supported)
+func PhotoGetOriginal(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	hash := c.Param("id")
+
+	p, err := photo.GetByHash(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.LogErrorf("handlers.PhotoGetOriginal - photo.GetByHash(%s) failed: %v", hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// photos uploaded before originals were kept
+	if p.OriginalHash == "" {
+		return c.NoContent(http.StatusNotFound)
+	}
+	u, _ := c.Get("u").(*user.User)
+	owner := u != nil && u.ID == p.UserID
+	if !owner && !p.OriginalIsPublic() {
+		if p.Privacy {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return c.NoContent(http.StatusForbidden)
+	}
+	// original is untouched: its metadata must not bypass owner policy
+	if !owner {
+		o, err := user.GetByID(int(p.UserID))
+		if err != nil {
+			c.LogErrorf("handlers.PhotoGetOriginal - user.GetByID(%d) failed: %v", p.UserID, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if o.MetadataPolicy != exif.PolicyKeepAll {
+			return c.NoContent(http.StatusForbidden)
+		}
+	}
+
+	info, err := datastore.Stat(p.OriginalHash)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.LogErrorf("handlers.PhotoGetOriginal - datastore.Stat(%s) failed: %v", p.OriginalHash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	r, err := datastore.GetReader(p.OriginalHash)
+	if err != nil {
+		c.LogErrorf("handlers.PhotoGetOriginal - datastore.GetReader(%s) failed: %v", p.OriginalHash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer r.Close()
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, p.Hash, originalExtensions[p.OriginalType]))
+	c.Response().Header().Set("Etag", p.OriginalHash)
+	if p.OriginalIsPublic() {
+		c.Response().Header().Set("Cache-Control", "max-age=3600")
+	} else {
+		c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	}
+	return streamBlob(c, p.OriginalType, r, info)
 }
 
 // PhotoPut alter photo properties
@@ -282,6 +610,14 @@ func PhotoPut(ac echo.Context) error {
 		return response.KO(http.StatusInternalServerError)
 	}
 
+	// only owner can alter its photos
+	if u, ok := c.Get("u").(*user.User); !ok || u.ID != photoOri.UserID {
+		response.Code = "notOwner"
+		return response.KO(http.StatusForbidden)
+	}
+
+	before := *photoOri
+
 	// PhotoNew -> PhotoOri (update)
 	photoOri.Name = photoNew.Name
 	photoOri.Description = photoNew.Description
@@ -307,6 +643,9 @@ func PhotoPut(ac echo.Context) error {
 		return response.KO(http.StatusInternalServerError)
 	}
 
+	// federate
+	federatePhotoChange(c, &before, photoOri)
+
 	// return photo
 	response.Data, err = json.Marshal(photoOri)
 	if err != nil {
@@ -324,28 +663,47 @@ func PhotoDel(ac echo.Context) error {
 
 	// get hash
 	hash := c.Param("id")
-	if err := photo.DeleteByHash(hash); err != nil {
+	p, err := photo.GetByHash(hash)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			response.Code = "notFound"
 			return response.KO(http.StatusNotFound)
 		}
-		response.Log = fmt.Sprintf("handlers.PhotoDel - photo.DeleteByHash(%s) failed: %v", hash, err)
+		response.Log = fmt.Sprintf("handlers.PhotoDel - photo.GetByHash(%s) failed: %v", hash, err)
+		response.Code = "getByHashFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	// only owner can delete its photos
+	if u, ok := c.Get("u").(*user.User); !ok || u.ID != p.UserID {
+		response.Code = "notOwner"
+		return response.KO(http.StatusForbidden)
+	}
+	if err = p.Delete(); err != nil {
+		response.Log = fmt.Sprintf("handlers.PhotoDel - p.Delete(%s) failed: %v", hash, err)
 		response.Code = "photoDeleteByHashFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+
+	// federate
+	federatePhotoChange(c, p, nil)
 	return response.OK(http.StatusOK)
 }
 
 // PhotoResize returns resized photo
+// deprecated: use the IIIF Image API (see IIIFImage)
 func PhotoResize(c echo.Context) error {
 	hash := c.Param("id")
+	format := negotiatePhotoFormat(c.Request().Header.Get("Accept"))
+	etag := photo.FormatKey(hash, format)
+	c.Response().Header().Set("Vary", "Accept")
 
 	// cache
-	if IfNoneMatch := c.Request().Header.Get("if-none-match"); IfNoneMatch == hash {
+	if IfNoneMatch := c.Request().Header.Get("if-none-match"); IfNoneMatch == etag {
 		// check if the photo still exists in datastore
 		exists, _ := datastore.Exists(hash)
 		if exists {
-			c.Response().Header().Set("Etag", hash)
+			c.Response().Header().Set("Etag", etag)
 			c.Response().Header().Set("Cache-Control", "max-age=3600")
 			return c.NoContent(http.StatusNotModified)
 		}
@@ -382,34 +740,32 @@ func PhotoResize(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	imgBytes, err := datastore.Get(hash)
+	p, err := photo.GetByHash(hash)
 	if err != nil {
-		log.Errorf("%v - controllers.PhotoResize - datastore.get(%s) failed: %v", c.RealIP(), c.Param("id"), err)
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		log.Errorf("%v - controllers.PhotoResize - unable to get photo %s: %v", c.RealIP(), hash, err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	img, err := image.New(bytes.NewBuffer(imgBytes))
+	// same as IIIF full/{width},{height}/0/default
+	request := &iiif.Request{Region: iiif.Region{Full: true}, Size: iiif.Size{W: width, H: height}, Quality: iiif.QualityDefault}
+	t, err := request.Transform(int(p.Width), int(p.Height), 0)
 	if err != nil {
-		log.Errorf("%v - controllers.PhotoResize - unable to core.NewImageFromDataStore(%s): %v", c.RealIP(), c.Param("id"), err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-
-	if err = img.Resize(width, height); err != nil {
-		log.Errorf("%v - controllers.PhotoResize - unable to img.ResizeToFit(%d, %d): %v", c.RealIP(), width, height, err)
-		return c.NoContent(http.StatusInternalServerError)
+		log.Errorf("%v - controllers.PhotoResize - unable to resize to %dx%d: %v", c.RealIP(), width, height, err)
+		return c.NoContent(http.StatusBadRequest)
 	}
-
-	b, err := img.JPEG(100)
+	b, err := renderDerivative(p, t, format)
 	if err != nil {
-		log.Errorf("%v - controllers.PhotoResize - unable to img.JPEG(): %v", c.RealIP(), err)
-		return c.NoContent(http.StatusInternalServerError)
+		return derivativeError(c, "PhotoResize", err)
 	}
 
 	// cache
-	c.Response().Header().Set("Etag", hash)
+	c.Response().Header().Set("Etag", etag)
 	c.Response().Header().Set("Cache-Control", "max-age=3600")
 
-	return c.Blob(http.StatusOK, "image/jpeg", b)
+	return c.Blob(http.StatusOK, image.ContentType(format), b)
 }
 
 // PhotoSearchResponse response structure for PhotoSearch
@@ -431,6 +787,13 @@ func PhotoSearch(ac echo.Context) error {
 		response.Code = "photoListFailed"
 		return response.KO(http.StatusInternalServerError)
 	}
+	for i := range photos {
+		if err = photoPublicLocation(c, &photos[i]); err != nil {
+			response.Log = fmt.Sprintf("handlers.PhotoSearch - photoPublicLocation failed: %v", err)
+			response.Code = "getOwnerFailed"
+			return response.KO(http.StatusInternalServerError)
+		}
+	}
 	data := PhotoSearchResponse{
 		Total:  len(photos),
 		Limit:  0,
@@ -5,27 +5,38 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	imageStd "image"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/peerpx/peerpx/cmd/server/context"
 	"github.com/peerpx/peerpx/entities/photo"
 	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/exif"
+	"github.com/peerpx/peerpx/pkg/image"
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/datastore"
 	"github.com/peerpx/peerpx/services/db"
+	"github.com/peerpx/peerpx/services/processing"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 func init() {
 	db.InitMockedDatabase()
+	if err := processing.InitPool(2, 8); err != nil {
+		panic(err)
+	}
 }
 
 func TestPhotoCreate(t *testing.T) {
@@ -133,6 +144,28 @@ func TestPhotoCreate(t *testing.T) {
 		}
 	}
 
+	// detected but unsupported format (HEIF)
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	handleErr(writer.WriteField("properties", properties))
+	part, err = writer.CreateFormFile("file", "robin.heic")
+	handleErr(err)
+	_, err = part.Write([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+	handleErr(err)
+	handleErr(writer.Close())
+	req = httptest.NewRequest(echo.POST, "/api/v1/photo", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, PhotoCreate(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "unsupportedPhotoFormat", response.Code)
+			assert.Equal(t, "unsupported photo format heif", response.Message)
+		}
+	}
+
 	// no user
 	body = new(bytes.Buffer)
 	writer = multipart.NewWriter(body)
@@ -159,7 +192,7 @@ func TestPhotoCreate(t *testing.T) {
 		}
 	}
 
-	// datastore failed
+	// too many pixels
 	u := new(user.User)
 	u.ID = 1
 	body = new(bytes.Buffer)
@@ -178,6 +211,34 @@ func TestPhotoCreate(t *testing.T) {
 	rec = httptest.NewRecorder()
 	c = context.NewMockedContext(e.NewContext(req, rec))
 	c.Set("u", u)
+	maxPixels := image.MaxPixels
+	image.MaxPixels = 1000 * 1000
+	if assert.NoError(t, PhotoCreate(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "photoTooLarge", response.Code)
+		}
+	}
+	image.MaxPixels = maxPixels
+
+	// datastore failed
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	handleErr(writer.WriteField("properties", properties))
+	file, err = os.Open("../../../etc/samples/photos/robin.jpg")
+	handleErr(err)
+	defer file.Close()
+	part, err = writer.CreateFormFile("file", "robin.jpg")
+	handleErr(err)
+	_, err = io.Copy(part, file)
+	handleErr(err)
+	handleErr(writer.Close())
+	req = httptest.NewRequest(echo.POST, "/api/v1/photo", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", u)
 	datastore.InitMokedDatastore([]byte{}, errors.New("mocked"))
 	if assert.NoError(t, PhotoCreate(c)) {
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
@@ -266,11 +327,20 @@ func TestPhotoCreate(t *testing.T) {
 	rec = httptest.NewRecorder()
 	c = context.NewMockedContext(e.NewContext(req, rec))
 
-	datastore.InitMokedDatastore([]byte{}, nil)
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
 	c.Set("u", u)
 	db.Mock.ExpectPrepare("^INSERT INTO photos (.*)").
 		ExpectExec().
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	// followers (none)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	if assert.NoError(t, PhotoCreate(c)) {
 		assert.Equal(t, http.StatusCreated, rec.Code)
 		response, err := APIResponseFromBody(rec.Body)
@@ -281,12 +351,85 @@ func TestPhotoCreate(t *testing.T) {
 			err = json.Unmarshal(response.Data, p)
 			if assert.NoError(t, err) {
 				assert.Equal(t, uint(1), p.ID)
-				assert.Equal(t, "H62MqsYPjtrQ56bgEJyaMVSGNJH3koXkBHgpj4uigR8T", p.Hash)
+				assert.Equal(t, "3mqerkvotGey4XTxRhZ5vdvWrK8W2Tv4jTiASyJRT185", p.Hash)
+				// original
+				assert.Equal(t, "2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J", p.OriginalHash)
+				assert.Equal(t, "image/jpeg", p.OriginalType)
+				// both are stored
+				for _, key := range []string{p.Hash, p.OriginalHash} {
+					exists, err := datastore.Exists(key)
+					assert.NoError(t, err)
+					assert.True(t, exists, key)
+				}
+				// from EXIF
+				assert.Equal(t, "SONY ILCE-7M2", p.Camera)
+				assert.Equal(t, "1/20", p.ShutterSpeed)
 			}
 		}
 	}
 }
 
+func TestPhotoGetOriginal_metadataPolicy(t *testing.T) {
+	e := echo.New()
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("photo.maxWidth", "100")
+	config.Set("photo.maxHeight", "100")
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+
+	// upload a GPS tagged photo under StripGPS
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	handleErr(writer.WriteField("properties", `{"name":"gps","licence_type":1}`))
+	original, err := ioutil.ReadFile("../../../etc/samples/photos/exif-gps.jpg")
+	handleErr(err)
+	part, err := writer.CreateFormFile("file", "exif-gps.jpg")
+	handleErr(err)
+	_, err = part.Write(original)
+	handleErr(err)
+	handleErr(writer.Close())
+	req := httptest.NewRequest(echo.POST, "/api/v1/photo", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1, MetadataPolicy: exif.PolicyStripGPS})
+	db.Mock.ExpectPrepare("^INSERT INTO photos (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	p := new(photo.Photo)
+	if assert.NoError(t, PhotoCreate(c)) && assert.Equal(t, http.StatusCreated, rec.Code) {
+		response, err := APIResponseFromBody(rec.Body)
+		handleErr(err)
+		handleErr(json.Unmarshal(response.Data, p))
+	}
+	if x, err := exif.Parse(original); assert.NoError(t, err) {
+		assert.True(t, x.HasLocation)
+	}
+
+	// anonymous download has no GPS IFD
+	req = httptest.NewRequest(echo.GET, "/api/v1/photo/"+p.Hash+"/original", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy", "licence_type", "original_hash", "original_type"}).
+		AddRow(1, 1, p.Hash, false, p.LicenceType, p.OriginalHash, p.OriginalType))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "metadata_policy"}).
+		AddRow(1, "toorop", exif.PolicyStripGPS))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		if x, err := exif.Parse(rec.Body.Bytes()); err == nil {
+			assert.False(t, x.HasLocation)
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
 func TestPhotoGetProperties(t *testing.T) {
 	e := echo.New()
 	req := httptest.NewRequest(echo.POST, "/api/v1/photo", nil)
@@ -335,6 +478,272 @@ func TestPhotoGetProperties(t *testing.T) {
 			}
 		}
 	}
+
+	// coarse location (owner copyright only)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "latitude", "longitude"}).
+		AddRow(1, 1, "mocked", 48.8584, 2.2945))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "metadata_policy"}).AddRow(1, "toorop", exif.PolicyCopyrightOnly))
+	if assert.NoError(t, PhotoGetProperties(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			p := new(photo.Photo)
+			if assert.NoError(t, json.Unmarshal(response.Data, p)) {
+				assert.Equal(t, float32(48.9), p.Latitude)
+				assert.Equal(t, float32(2.3), p.Longitude)
+			}
+		}
+	}
+
+	// exact location for owner
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "latitude", "longitude"}).
+		AddRow(1, 1, "mocked", 48.8584, 2.2945))
+	if assert.NoError(t, PhotoGetProperties(c)) {
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			p := new(photo.Photo)
+			if assert.NoError(t, json.Unmarshal(response.Data, p)) {
+				assert.Equal(t, float32(48.8584), p.Latitude)
+			}
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPhotoGetExif(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/api/v1/photo/mocked/exif", nil)
+
+	// not found
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, PhotoGetExif(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// private photo of another user
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy", "exif"}).
+		AddRow(1, 1, "mocked", true, `{"Make":"SONY","GPSLatitude":"48"}`))
+	if assert.NoError(t, PhotoGetExif(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok: filtered by the current policy of the owner
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "exif"}).
+		AddRow(1, 1, "mocked", `{"Make":"SONY","ISOSpeedRatings":"50","GPSLatitude":"48"}`))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "metadata_policy"}).
+		AddRow(1, "toorop", exif.PolicyStripGPS))
+	if assert.NoError(t, PhotoGetExif(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			tags := make(map[string]string)
+			if assert.NoError(t, json.Unmarshal(response.Data, &tags)) {
+				assert.Equal(t, map[string]string{"Make": "SONY", "ISOSpeedRatings": "50"}, tags)
+			}
+		}
+	}
+
+	// owner gets stored tags of its private photo
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy", "exif"}).
+		AddRow(1, 1, "mocked", true, `{"Make":"SONY","GPSLatitude":"48"}`))
+	if assert.NoError(t, PhotoGetExif(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			tags := make(map[string]string)
+			if assert.NoError(t, json.Unmarshal(response.Data, &tags)) {
+				assert.Equal(t, "48", tags["GPSLatitude"])
+			}
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPhotoObject(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/photos/hash", nil)
+	req.Header.Set("Accept", activitypub.ContentType)
+
+	// not found
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("hash")
+	c.SetParamValues("hash")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, PhotoObject(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// private
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("hash")
+	c.SetParamValues("hash")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "privacy"}).AddRow(1, "hash", true))
+	if assert.NoError(t, PhotoObject(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("hash")
+	c.SetParamValues("hash")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "name", "description", "camera", "iso", "nsfw", "licence_type", "latitude", "longitude", "width", "height"}).
+		AddRow(1, 1, "hash", "Eiffel", "<b>Paris</b>", "X-T2", 200, true, photo.LicenceCCBY, 48.8584, 2.2945, 3000, 2000))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "metadata_policy"}).AddRow(1, "toorop", exif.PolicyKeepAll))
+	if assert.NoError(t, PhotoObject(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), activitypub.ContentType))
+		image := new(activitypub.Image)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), image)) {
+			assert.Equal(t, "https://peerpx.social/photos/hash", image.ID)
+			assert.Equal(t, "Image", image.Type)
+			assert.Equal(t, "https://peerpx.social/users/toorop", image.AttributedTo)
+			assert.Equal(t, "&lt;b&gt;Paris&lt;/b&gt;", image.Content)
+			assert.True(t, image.Sensitive)
+			assert.Equal(t, "https://creativecommons.org/licenses/by/4.0/", image.License)
+			if assert.NotNil(t, image.Location) && assert.NotNil(t, image.Location.Latitude) {
+				assert.Equal(t, 48.8584, *image.Location.Latitude)
+			}
+			assert.Equal(t, []activitypub.PropertyValue{
+				activitypub.NewPropertyValue("Camera", "X-T2"),
+				activitypub.NewPropertyValue("ISO", "200"),
+			}, image.Attachment)
+			// xs, s, m, l and max
+			if assert.Equal(t, 5, len(image.URL)) {
+				assert.Equal(t, "http://peerpx.social/api/v1/photo/hash/xs", image.URL[0].Href)
+				assert.Equal(t, uint32(320), image.URL[0].Width)
+				assert.Equal(t, uint32(213), image.URL[0].Height)
+				assert.Equal(t, "max", image.URL[4].Name)
+				assert.Equal(t, uint32(3000), image.URL[4].Width)
+			}
+		}
+	}
+
+	// coarse location (default policy strips GPS)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("hash")
+	c.SetParamValues("hash")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "latitude", "longitude"}).
+		AddRow(1, 1, "hash", 48.8584, 2.2945))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	if assert.NoError(t, PhotoObject(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		image := new(activitypub.Image)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), image)) {
+			if assert.NotNil(t, image.Location) && assert.NotNil(t, image.Location.Latitude) {
+				assert.Equal(t, 48.9, *image.Location.Latitude)
+				assert.Equal(t, 2.3, *image.Location.Longitude)
+			}
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPhotoGetOriginal(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/api/v1/photo/hash/original", nil)
+	columns := []string{"id", "user_id", "hash", "privacy", "licence_type", "original_hash", "original_type"}
+	datastore.InitMokedDatastore([]byte{1, 2, 3}, nil)
+
+	// not found
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// private photo
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", true, photo.LicenceCCBY, "original", "image/png"))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// all rights reserved
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", false, photo.LicenceAllRightsReserved, "original", "image/png"))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+
+	// owner
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", true, photo.LicenceAllRightsReserved, "original", "image/png"))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="hash.png"`, rec.Header().Get("Content-Disposition"))
+		assert.Equal(t, "private, max-age=3600", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, []byte{1, 2, 3}, rec.Body.Bytes())
+	}
+
+	// public under Creative Commons licence, owner strips GPS
+	userColumns := []string{"id", "username", "metadata_policy"}
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", false, photo.LicenceCCBY, "original", "image/jpeg"))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(1, "toorop", exif.PolicyStripGPS))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+
+	// public under Creative Commons licence, owner keeps all metadata
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", false, photo.LicenceCCBY, "original", "image/jpeg"))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(1, "toorop", exif.PolicyKeepAll))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "max-age=3600", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, "3", rec.Header().Get("Content-Length"))
+	}
+
+	// byte range
+	req = httptest.NewRequest(echo.GET, "/api/v1/photo/hash/original", nil)
+	req.Header.Set("Range", "bytes=1-")
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, 1, "hash", false, photo.LicenceCCBY, "original", "image/jpeg"))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(1, "toorop", exif.PolicyKeepAll))
+	if assert.NoError(t, PhotoGetOriginal(c)) {
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "bytes 1-2/3", rec.Header().Get("Content-Range"))
+		assert.Equal(t, []byte{2, 3}, rec.Body.Bytes())
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
 }
 
 func TestPhotoGet(t *testing.T) {
@@ -363,6 +772,166 @@ func TestPhotoGet(t *testing.T) {
 	if assert.NoError(t, PhotoGet(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assert.Equal(t, []byte{1, 2, 3}, rec.Body.Bytes())
+		assert.Equal(t, "3", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	}
+
+	// range
+	req = httptest.NewRequest(echo.GET, "/api/v1/photo/hash/size", nil)
+	req.Header.Set("Range", "bytes=1-")
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, []byte{2, 3}, rec.Body.Bytes())
+		assert.Equal(t, "2", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 1-2/3", rec.Header().Get("Content-Range"))
+	}
+
+	// range not satisfiable
+	req.Header.Set("Range", "bytes=3-")
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+		assert.Equal(t, "bytes */3", rec.Header().Get("Content-Range"))
+	}
+	req.Header.Del("Range")
+
+	// size variant
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id", "size")
+	c.SetParamValues("hash", "m")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hash_m", rec.Header().Get("Etag"))
+		assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	}
+
+	// negotiated format
+	req = httptest.NewRequest(echo.GET, "/api/v1/photo/hash/m", nil)
+	req.Header.Set("Accept", "image/png")
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id", "size")
+	c.SetParamValues("hash", "m")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hash_m.png", rec.Header().Get("Etag"))
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	}
+
+	// unknown size
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id", "size")
+	c.SetParamValues("hash", "xxl")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// redirect to presigned URL (every object exists)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	err := datastore.InitS3Datastore(datastore.S3Config{Endpoint: server.URL, Bucket: "photos", PathStyle: true, Presign: time.Minute})
+	if err != nil {
+		panic(err)
+	}
+	req = httptest.NewRequest(echo.GET, "/api/v1/photo/hash/m", nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id", "size")
+	c.SetParamValues("hash", "m")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), server.URL+"/photos/hash_m?X-Amz-Algorithm="))
+	}
+}
+
+func TestPhotoGet_generated(t *testing.T) {
+	e := echo.New()
+	config.InitBasicConfig(strings.NewReader(""))
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	original, err := ioutil.ReadFile("../../../etc/samples/photos/robin.jpg")
+	if err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", original); err != nil {
+		panic(err)
+	}
+	newContext := func(size string) (*context.AppContext, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(echo.GET, "/api/v1/photo/hash/"+size, nil)
+		req.Header.Set("Accept", "image/png")
+		rec := httptest.NewRecorder()
+		c := context.NewMockedContext(e.NewContext(req, rec))
+		c.SetParamNames("id", "size")
+		c.SetParamValues("hash", size)
+		return c, rec
+	}
+
+	// pool is saturated: missing variant is not generated
+	if err = processing.InitPool(1, 0); err != nil {
+		panic(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		// rejected until the worker waits for jobs
+		for {
+			_, err := processing.Do("busy", func() ([]byte, error) {
+				close(started)
+				<-release
+				return nil, nil
+			})
+			if err != processing.ErrSaturated {
+				return
+			}
+			runtime.Gosched()
+		}
+	}()
+	<-started
+	c, rec := newContext("xs")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+	}
+	close(release)
+
+	// generated on the pool
+	if err = processing.InitPool(2, 8); err != nil {
+		panic(err)
+	}
+	c, rec = newContext("xs")
+	if assert.NoError(t, PhotoGet(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		assert.Equal(t, image.FormatPNG, image.DetectFormat(rec.Body.Bytes()))
+	}
+}
+
+func TestNegotiatePhotoFormat(t *testing.T) {
+	for accept, format := range map[string]string{
+		"":        image.FormatJPEG,
+		"*/*":     image.FormatJPEG,
+		"image/*": image.FormatJPEG,
+		"image/webp,image/apng,image/*,*/*;q=0.8": image.FormatJPEG,
+		"image/webp":                  image.FormatJPEG,
+		"image/png":                   image.FormatPNG,
+		"image/png, image/jpeg;q=0.5": image.FormatJPEG,
+		"image/jpeg;q=0, image/png":   image.FormatPNG,
+		"text/html":                   image.FormatJPEG,
+		"IMAGE/PNG ; q=0.9":           image.FormatPNG,
+	} {
+		assert.Equal(t, format, negotiatePhotoFormat(accept), accept)
 	}
 }
 
@@ -440,13 +1009,30 @@ func TestPhotoPut(t *testing.T) {
 		}
 	}
 
+	// not owner
+	photoJson = []byte(`{"hash": "bar", "name": "new name"}`)
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/api/v1/photo", bytes.NewBuffer(photoJson))
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 2})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "bar", false))
+	if assert.NoError(t, PhotoPut(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		response, err := APIResponseFromBody(rec.Body)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "notOwner", response.Code)
+		}
+	}
+
 	// update failed
 	photoJson = []byte(`{"hash": "bar"}`)
 	rec = httptest.NewRecorder()
 	req = httptest.NewRequest("PUT", "/api/v1/photo", bytes.NewBuffer(photoJson))
 	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
 	db.Mock.ExpectQuery("^SELECT(.*)").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(1, "mocked"))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash"}).AddRow(1, 1, "mocked"))
 	db.Mock.ExpectPrepare("^UPDATE photos (.*)").
 		WillReturnError(errors.New("prepare error"))
 
@@ -457,18 +1043,65 @@ func TestPhotoPut(t *testing.T) {
 			assert.Equal(t, "photoUpdateFailed", response.Code)
 		}
 	}
+
+	// ok: public photo becomes private, followers get a Delete
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	var sent []*activitypub.Activity
+	defer mockFederation(&sent)()
+	photoJson = []byte(`{"hash": "bar", "privacy": true}`)
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/api/v1/photo", bytes.NewBuffer(photoJson))
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "bar", false))
+	db.Mock.ExpectPrepare("^UPDATE photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	if assert.NoError(t, PhotoPut(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Equal(t, 1, len(sent)) {
+			assert.Equal(t, "Delete", sent[0].Type)
+			assert.Equal(t, "Tombstone", sent[0].ObjectType())
+			assert.Equal(t, "https://peerpx.social/photos/bar", sent[0].ObjectIRI())
+		}
+	}
+
+	// ok: public photo updated
+	sent = nil
+	photoJson = []byte(`{"hash": "bar", "name": "new name"}`)
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/api/v1/photo", bytes.NewBuffer(photoJson))
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "bar", false))
+	db.Mock.ExpectPrepare("^UPDATE photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	if assert.NoError(t, PhotoPut(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Equal(t, 1, len(sent)) {
+			assert.Equal(t, "Update", sent[0].Type)
+			assert.Equal(t, "Image", sent[0].ObjectType())
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
 }
 
 func TestPhotoDel(t *testing.T) {
-	// not found
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	var sent []*activitypub.Activity
+	defer mockFederation(&sent)()
 	e := echo.New()
 
 	// Not found
 	req := httptest.NewRequest(echo.DELETE, "/api/v1/photo", nil)
 	rec := httptest.NewRecorder()
 	c := context.NewMockedContext(e.NewContext(req, rec))
-	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
-		ExpectExec().WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
 	if assert.NoError(t, PhotoDel(c)) {
 		assert.Equal(t, http.StatusNotFound, rec.Code)
 		response, err := APIResponseFromBody(rec.Body)
@@ -477,9 +1110,27 @@ func TestPhotoDel(t *testing.T) {
 		}
 	}
 
+	// not owner
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 2})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "foo", false))
+	if assert.NoError(t, PhotoDel(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, 0, len(sent))
+	}
+
 	// db error
 	rec = httptest.NewRecorder()
 	c = context.NewMockedContext(e.NewContext(req, rec))
+	if err := datastore.InitMokedDatastore(nil, nil); err != nil {
+		panic(err)
+	}
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "foo", false))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnError(errors.New("mocked"))
 	if assert.NoError(t, PhotoDel(c)) {
@@ -493,11 +1144,16 @@ func TestPhotoDel(t *testing.T) {
 	// ok
 	rec = httptest.NewRecorder()
 	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
 	if err := datastore.InitMokedDatastore(nil, nil); err != nil {
 		panic(err)
 	}
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "foo", false))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
 
 	if assert.NoError(t, PhotoDel(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
@@ -505,7 +1161,26 @@ func TestPhotoDel(t *testing.T) {
 		if assert.NoError(t, err) {
 			assert.True(t, response.Success)
 		}
+		if assert.Equal(t, 1, len(sent)) {
+			assert.Equal(t, "Delete", sent[0].Type)
+		}
+	}
+
+	// private photo: not federated
+	sent = nil
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.Set("u", &user.User{ID: 1})
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "privacy"}).AddRow(1, 1, "foo", true))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, PhotoDel(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 0, len(sent))
 	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
 }
 
 func TestPhotoResize(t *testing.T) {
@@ -541,12 +1216,23 @@ func TestPhotoResize(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	}
 
-	// not found in datastore
+	// unknown photo
 	rec = httptest.NewRecorder()
 	c = context.NewMockedContext(e.NewContext(req, rec))
-	c.SetParamNames("width")
-	c.SetParamValues("100")
+	c.SetParamNames("id", "width")
+	c.SetParamValues("hash", "100")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, PhotoResize(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
 
+	// datastore error
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id", "width")
+	c.SetParamValues("hash", "100")
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 400, 200))
 	if err := datastore.InitMokedDatastore([]byte{0}, errors.New("notfound")); err != nil {
 		panic(err)
 	}
@@ -554,8 +1240,37 @@ func TestPhotoResize(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	}
 
-	// ok
-
+	// ok: rendered then cached
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", iiifPhoto()); err != nil {
+		panic(err)
+	}
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		c = context.NewMockedContext(e.NewContext(req, rec))
+		c.SetParamNames("id", "width")
+		c.SetParamValues("hash", "10")
+		db.Mock.ExpectQuery("^SELECT(.*)").
+			WillReturnRows(sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 40, 20))
+		if assert.NoError(t, PhotoResize(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+			img, _, err := imageStd.Decode(rec.Body)
+			if assert.NoError(t, err) {
+				assert.Equal(t, imageStd.Rect(0, 0, 10, 5), img.Bounds())
+			}
+		}
+		exists, _ := datastore.Exists(photo.DerivativeKey("hash", "0_0_40_20_10_5_0_default.jpeg"))
+		assert.True(t, exists)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
 }
 
 func TestPhotoSearch(t *testing.T) {
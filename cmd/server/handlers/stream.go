@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/services/datastore"
+)
+
+// errRangeNotSatisfiable is returned by parseRange if range is out of value
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// streamBlob streams value r (described by info) with status 200
+// a single byte range request is honoured with status 206
+// (416 if it is out of value, other ranges are ignored)
+func streamBlob(c echo.Context, contentType string, r io.Reader, info datastore.Info) error {
+	h := c.Response().Header()
+	h.Set("Accept-Ranges", "bytes")
+	if !info.ModTime.IsZero() {
+		h.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	start, length, err := parseRange(c.Request().Header.Get("Range"), info.Size)
+	if err != nil {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		return c.NoContent(http.StatusRequestedRangeNotSatisfiable)
+	}
+	status := http.StatusOK
+	if length != info.Size {
+		status = http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(start, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, r, start)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	return c.Stream(status, contentType, io.LimitReader(r, length))
+}
+
+// parseRange returns start and length of Range header value header
+// for a value of size bytes (the whole value if there is no range or if
+// it is not a single byte range)
+func parseRange(header string, size int64) (start, length int64, err error) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, size, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, size, nil
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	// suffix: last bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		start, length int64
+		err           error
+	}{
+		{"", 0, 10, nil},
+		{"bytes=0-4", 0, 5, nil},
+		{"bytes=5-", 5, 5, nil},
+		{"bytes=5-100", 5, 5, nil},
+		{"bytes=-3", 7, 3, nil},
+		{"bytes=-30", 0, 10, nil},
+		{"bytes=10-", 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, errRangeNotSatisfiable},
+		// ignored
+		{"bytes=0-1,4-5", 0, 10, nil},
+		{"bytes=4-1", 0, 10, nil},
+		{"bytes=x-", 0, 10, nil},
+		{"items=0-1", 0, 10, nil},
+	}
+	for _, test := range tests {
+		start, length, err := parseRange(test.header, 10)
+		assert.Equal(t, test.err, err, test.header)
+		assert.Equal(t, test.start, start, test.header)
+		assert.Equal(t, test.length, length, test.header)
+	}
+}
+
+func TestStreamBlob(t *testing.T) {
+	e := echo.New()
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", []byte("hello peerpx")); err != nil {
+		panic(err)
+	}
+	info, err := datastore.Stat("hash")
+	if err != nil {
+		panic(err)
+	}
+
+	// seekable reader
+	r, err := datastore.GetReader("hash")
+	if err != nil {
+		panic(err)
+	}
+	defer r.Close()
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	req.Header.Set("Range", "bytes=6-")
+	rec := httptest.NewRecorder()
+	if assert.NoError(t, streamBlob(e.NewContext(req, rec), "text/plain", r, info)) {
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "peerpx", rec.Body.String())
+		assert.Equal(t, "6", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 6-11/12", rec.Header().Get("Content-Range"))
+		assert.Equal(t, info.ModTime.UTC().Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+		lastModified, err := http.ParseTime(rec.Header().Get("Last-Modified"))
+		if assert.NoError(t, err) {
+			assert.WithinDuration(t, time.Now(), lastModified, time.Minute)
+		}
+	}
+
+	// plain reader
+	req.Header.Set("Range", "bytes=0-4")
+	rec = httptest.NewRecorder()
+	if assert.NoError(t, streamBlob(e.NewContext(req, rec), "text/plain", bytes.NewBufferString("hello peerpx"), info)) {
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "hello", rec.Body.String())
+	}
+}
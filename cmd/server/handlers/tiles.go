@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/tiler"
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// tileCacheControl is the Cache-Control header of tiles
+// (tiles of a photo never change)
+const tileCacheControl = "public, max-age=31536000, immutable"
+
+// PhotoTilesDZI returns the Deep Zoom Image descriptor of a photo
+// GET /api/v1/photo/:id/tiles.dzi
+// tiles are served by PhotoTile (tiles_files/ relative to the descriptor)
+// 503 is returned while the pyramid is generated
+func PhotoTilesDZI(c echo.Context) error {
+	hash := c.Param("id")
+	pyramid, err := photo.GetPyramid(hash)
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorf("%v - controllers.PhotoTilesDZI - unable to get pyramid of %s: %v", c.RealIP(), hash, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return tilesNotFound(c, hash)
+	}
+	b, err := pyramid.DZI()
+	if err != nil {
+		log.Errorf("%v - controllers.PhotoTilesDZI - unable to marshal DZI of %s: %v", c.RealIP(), hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	c.Response().Header().Set("Cache-Control", tileCacheControl)
+	return c.Blob(http.StatusOK, "application/xml", b)
+}
+
+// PhotoTile returns a deep zoom tile of a photo
+// GET /api/v1/photo/:id/tiles_files/:level/:tile ({col}_{row}.jpg)
+func PhotoTile(c echo.Context) error {
+	hash := c.Param("id")
+	level, err := strconv.Atoi(c.Param("level"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	var col, row int
+	var extension string
+	if n, _ := fmt.Sscanf(c.Param("tile"), "%d_%d.%s", &col, &row, &extension); n != 3 || extension != "jpg" {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	b, err := photo.GetTileData(hash, level, col, row)
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return c.NoContent(http.StatusNotFound)
+		}
+		log.Errorf("%v - controllers.PhotoTile - unable to get tile %d/%d_%d of %s: %v", c.RealIP(), level, col, row, hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return tileBlob(c, b)
+}
+
+// tileBlob responds with JPEG tile b
+func tileBlob(c echo.Context, b []byte) error {
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	c.Response().Header().Set("Cache-Control", tileCacheControl)
+	return c.Blob(http.StatusOK, "image/jpeg", b)
+}
+
+// tilesNotFound responds to a request for the missing pyramid of photo hash
+// generation is queued for photos large enough to be tiled
+// (503 with Retry-After, 404 otherwise)
+func tilesNotFound(c echo.Context, hash string) error {
+	p, err := photo.GetByHash(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		log.Errorf("%v - controllers.tilesNotFound - unable to get photo %s: %v", c.RealIP(), hash, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !photo.NeedsTiles(p.Width, p.Height) || !tiler.Enqueue(hash) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	c.Response().Header().Set("Retry-After", "30")
+	return c.NoContent(http.StatusServiceUnavailable)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestPhotoTilesDZI(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/api/v1/photo/hash/tiles.dzi", nil)
+
+	// not generated, photo too small
+	datastore.InitMokedDatastore(nil, datastore.ErrNotFound)
+	rec := httptest.NewRecorder()
+	c := context.NewMockedContext(e.NewContext(req, rec))
+	c.SetParamNames("id")
+	c.SetParamValues("hash")
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "width", "height"}).AddRow("hash", 1000, 500))
+	if assert.NoError(t, PhotoTilesDZI(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// unknown photo
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, PhotoTilesDZI(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// ok
+	datastore.InitMokedDatastore([]byte(`{"width":1000,"height":500,"tile_size":256}`), nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, PhotoTilesDZI(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, tileCacheControl, rec.Header().Get("Cache-Control"))
+		assert.Contains(t, rec.Body.String(), `<Size Width="1000" Height="500">`)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPhotoTile(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/api/v1/photo/hash/tiles_files/10/1_2.jpg", nil)
+	newContext := func(level, tile string) (*context.AppContext, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		c := context.NewMockedContext(e.NewContext(req, rec))
+		c.SetParamNames("id", "level", "tile")
+		c.SetParamValues("hash", level, tile)
+		return c, rec
+	}
+
+	// bad params
+	datastore.InitMokedDatastore([]byte{1, 2, 3}, nil)
+	for _, params := range [][2]string{{"x", "1_2.jpg"}, {"10", "1-2.jpg"}, {"10", "1_2.png"}} {
+		c, rec := newContext(params[0], params[1])
+		if assert.NoError(t, PhotoTile(c)) {
+			assert.Equal(t, http.StatusNotFound, rec.Code, params)
+		}
+	}
+
+	// ok
+	c, rec := newContext("10", "1_2.jpg")
+	if assert.NoError(t, PhotoTile(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+		assert.Equal(t, tileCacheControl, rec.Header().Get("Cache-Control"))
+		assert.Equal(t, []byte{1, 2, 3}, rec.Body.Bytes())
+	}
+
+	// not found
+	datastore.InitMokedDatastore(nil, datastore.ErrNotFound)
+	c, rec = newContext("10", "1_2.jpg")
+	if assert.NoError(t, PhotoTile(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/peerpx/peerpx/cmd/server/context"
 	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/exif"
 	"github.com/peerpx/peerpx/services/config"
 )
 
@@ -59,15 +60,17 @@ func UserProfile(ac echo.Context) error {
 		PubKey := bytes.Replace([]byte(u.PublicKey.String), []byte{10}, []byte{92, 110}, -1)
 
 		tplData := struct {
-			BaseURL  string
-			UserName string
-			Summary  string
-			PubKey   string
+			BaseURL                   string
+			UserName                  string
+			Summary                   string
+			PubKey                    string
+			ManuallyApprovesFollowers bool
 		}{
-			BaseURL:  config.GetString("hostname"),
-			UserName: u.Username,
-			Summary:  "",
-			PubKey:   string(PubKey),
+			BaseURL:                   config.GetString("hostname"),
+			UserName:                  u.Username,
+			Summary:                   "",
+			PubKey:                    string(PubKey),
+			ManuallyApprovesFollowers: u.ManuallyApprovesFollowers,
 		}
 		out := bytes.NewBuffer(nil)
 		if err = tpl.Execute(out, tplData); err != nil {
@@ -101,8 +104,9 @@ func UserGetPublicKey(ac echo.Context) error {
 }
 
 // UserNewFollower follow request
+// Works like the user inbox but only accepts (signed) Follow activities
 func UserNewFollower(ac echo.Context) error {
-	return nil
+	return inbox(ac.(*context.AppContext), "Follow")
 }
 
 /////////////////////////////////////////////////////
@@ -139,6 +143,12 @@ func UserCreate(ac echo.Context) error {
 	c := ac.(*context.AppContext)
 	response := NewAPIResponse(c)
 
+	// registrations closed
+	if !config.GetBoolDefault("registration.open", true) {
+		response.Code = "registrationClosed"
+		return response.KO(http.StatusForbidden)
+	}
+
 	// get body
 	body, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
@@ -343,6 +353,62 @@ func UserMe(ac echo.Context) error {
 	return response.OK(http.StatusOK)
 }
 
+// UserSettingsPut updates settings of the logged user
+// PUT /api/v1/user/settings {"manually_approves_followers": true, "metadata_policy": 2}
+// metadata_policy: 0 strip GPS (default), 1 keep all, 2 strip all, 3 copyright only
+func UserSettingsPut(ac echo.Context) error {
+	c := ac.(*context.AppContext)
+	response := NewAPIResponse(c)
+
+	ui := c.Get("u")
+	if ui == nil {
+		response.Log = "handlers.UserSettingsPut - c.Get(u) return empty string"
+		response.Code = "userNotInContext"
+		return response.KO(http.StatusUnauthorized)
+	}
+	u := ui.(*user.User)
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.UserSettingsPut - failed to read request body: %v", err)
+		response.Code = "requestBodyNotReadable"
+		return response.KO(http.StatusBadRequest)
+	}
+	// nil -> unchanged
+	requestData := struct {
+		ManuallyApprovesFollowers *bool        `json:"manually_approves_followers"`
+		MetadataPolicy            *exif.Policy `json:"metadata_policy"`
+	}{}
+	if err = json.Unmarshal(body, &requestData); err != nil {
+		response.Log = fmt.Sprintf("handlers.UserSettingsPut - unmarshall request body failed: %v", err)
+		response.Code = "requestBodyNotValidJson"
+		return response.KO(http.StatusBadRequest)
+	}
+	if requestData.ManuallyApprovesFollowers != nil {
+		u.ManuallyApprovesFollowers = *requestData.ManuallyApprovesFollowers
+	}
+	if requestData.MetadataPolicy != nil {
+		if !requestData.MetadataPolicy.Valid() {
+			response.Code = "invalidMetadataPolicy"
+			return response.KO(http.StatusBadRequest)
+		}
+		u.MetadataPolicy = *requestData.MetadataPolicy
+	}
+	if err = u.Update(); err != nil {
+		response.Log = fmt.Sprintf("handlers.UserSettingsPut - user.Update() failed: %v", err)
+		response.Code = "userUpdateFail"
+		return response.KO(http.StatusInternalServerError)
+	}
+
+	response.Data, err = json.Marshal(u)
+	if err != nil {
+		response.Log = fmt.Sprintf("handlers.UserSettingsPut - json.Marshal(user) failed: %v", err)
+		response.Code = "userMarshalFailed"
+		return response.KO(http.StatusInternalServerError)
+	}
+	return response.OK(http.StatusOK)
+}
+
 // a re-utiliser pour le PUT
 /*
 // UserPostRequest is request struct for adding user
@@ -3,16 +3,18 @@ package handlers
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/labstack/echo"
 	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/pkg/webfinger"
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/db"
-	"github.com/peerpx/peerpx/services/log"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
@@ -98,6 +100,53 @@ func TestWebfinger(t *testing.T) {
 	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
 	if assert.NoError(t, WebfingerAcct(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		log.Info(rec.Body.String())
+		jrd := new(webfinger.JRD)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), jrd)) {
+			assert.Equal(t, "acct:john@peerpx.social", jrd.Subject)
+			assert.Equal(t, []string{"https://peerpx.social/@john", "https://peerpx.social/users/john"}, jrd.Aliases)
+			if self := jrd.Link(webfinger.RelSelf); assert.NotNil(t, self) {
+				assert.Equal(t, "https://peerpx.social/users/john", self.Href)
+			}
+			assert.NotNil(t, jrd.Link(webfinger.RelProfilePage))
+			assert.NotNil(t, jrd.Link(webfinger.RelSubscribe))
+			assert.Nil(t, jrd.Link(webfinger.RelAvatar))
+			assert.NotEmpty(t, jrd.MagicKey())
+		}
 	}
+
+	// URL resource, rel filtering
+	req = httptest.NewRequest(echo.GET, "/.well-know/webfinger?resource="+url.QueryEscape("https://peerpx.social/users/john")+"&rel=self&rel="+url.QueryEscape(webfinger.RelAvatar), nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	row = sqlmock.NewRows([]string{"id", "username", "email", "public_key", "avatar_url"}).AddRow(1, "john", "john@doe.com", userPubKey, "https://peerpx.social/avatars/john.png")
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("john").WillReturnRows(row)
+	if assert.NoError(t, WebfingerAcct(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		jrd := new(webfinger.JRD)
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), jrd)) {
+			assert.Equal(t, "acct:john@peerpx.social", jrd.Subject)
+			if assert.Equal(t, 2, len(jrd.Links)) {
+				assert.Equal(t, webfinger.RelSelf, jrd.Links[0].Rel)
+				assert.Equal(t, webfinger.RelAvatar, jrd.Links[1].Rel)
+				assert.Equal(t, "image/png", jrd.Links[1].Type)
+			}
+		}
+	}
+
+	// URL resource, not local
+	req = httptest.NewRequest(echo.GET, "/.well-know/webfinger?resource="+url.QueryEscape("https://remote.social/users/john"), nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, WebfingerAcct(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	// URL resource, not an user
+	req = httptest.NewRequest(echo.GET, "/.well-know/webfinger?resource="+url.QueryEscape("https://peerpx.social/photos/hash"), nil)
+	rec = httptest.NewRecorder()
+	c = context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, WebfingerAcct(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
 }
@@ -1,24 +1,28 @@
 package handlers
 
 import (
-	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/mail"
+	"net/url"
+	"path"
 	"strings"
-	"text/template"
 
 	"github.com/labstack/echo"
 	"github.com/peerpx/peerpx/cmd/server/context"
 	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/pkg/activitypub"
 	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/pkg/webfinger"
 	"github.com/peerpx/peerpx/services/config"
-	"github.com/tdewolff/minify"
-	"github.com/tdewolff/minify/json"
 )
 
-// WebfingerAcct basic implementation
+// WebfingerAcct returns the JRD of a local user
+// GET /.well-known/webfinger?resource=acct:user@host[&rel=...]
+// GET /.well-known/webfinger?resource=https://host/users/user[&rel=...]
 func WebfingerAcct(ac echo.Context) error {
 	c := ac.(*context.AppContext)
 	// get resource
@@ -26,76 +30,102 @@ func WebfingerAcct(ac echo.Context) error {
 	if resource == "" {
 		return c.String(http.StatusBadRequest, "missing resource parameter")
 	}
-	resource = strings.ToLower(resource)
-	p := strings.SplitAfterN(resource, ":", 2)
-	if len(p) != 2 {
-		return c.String(http.StatusBadRequest, "bad request")
-	}
-
-	// p[0] must be acct
-	if p[0] != "acct:" {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("%s is not valid", p[0]))
-	}
-
-	// valid ? Same syntax as mail so...
-	if _, err := mail.ParseAddress(p[1]); err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid actor id", p[1]))
-	}
+	hostname := config.GetString("hostname")
 
-	// local domain ?
-	usernameDomain := strings.Split(p[1], "@")
-	if usernameDomain[1] != config.GetString("hostname") {
-		return c.String(http.StatusNotFound, fmt.Sprintf("%s is not referenced here", p[1]))
+	username, status, msg := webfingerUsername(resource, hostname)
+	if status != http.StatusOK {
+		return c.String(status, msg)
 	}
 
 	// Get user
-	u, err := user.GetByUsername(usernameDomain[0])
+	u, err := user.GetByUsername(username)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.String(http.StatusNotFound, "not found ")
 		}
+		c.LogErrorf("handlers.WebfingerAcct - user.GetByUsername(%s) failed: %v", username, err)
 		return c.String(http.StatusInternalServerError, "i'm sorry dave, something went wrong")
 	}
 
 	// magicKey
 	magicKey, err := cryptobox.RSAGetMagicKey(u.PublicKey.String)
 	if err != nil {
+		c.LogErrorf("handlers.WebfingerAcct - cryptobox.RSAGetMagicKey failed: %v", err)
 		return c.String(http.StatusInternalServerError, "get magic key failed")
 	}
 
-	// generate response body
-	t, err := tplBox.MustString("webfinger/acct.json")
-	if err != nil {
-		c.LogErrorf("handlers.WebfingerAcct - tplBox.MustString(webfinger/acct.json) failed: %v", err)
-		return c.String(http.StatusInternalServerError, "internal server error")
+	profilePage := fmt.Sprintf("https://%s/@%s", hostname, u.Username)
+	jrd := &webfinger.JRD{
+		Subject: fmt.Sprintf("acct:%s@%s", u.Username, hostname),
+		Aliases: []string{profilePage, u.IRI()},
+		Links: []webfinger.Link{
+			{Rel: webfinger.RelProfilePage, Type: "text/html", Href: profilePage},
+			{Rel: webfinger.RelSelf, Type: activitypub.ContentType, Href: u.IRI()},
+		},
 	}
-	tpl, err := template.New("acct").Parse(t)
-	if err != nil {
-		c.LogErrorf("handlers.WebfingerAcct - template new failed: %v", err)
-		return c.String(http.StatusInternalServerError, "internal server error")
+	if u.AvatarURL != "" {
+		jrd.Links = append(jrd.Links, webfinger.Link{
+			Rel:  webfinger.RelAvatar,
+			Type: mime.TypeByExtension(path.Ext(u.AvatarURL)),
+			Href: u.AvatarURL,
+		})
 	}
+	jrd.Links = append(jrd.Links,
+		webfinger.Link{Rel: webfinger.RelSubscribe, Template: fmt.Sprintf("https://%s/authorize_follow?acct={uri}", hostname)},
+		webfinger.NewMagicKeyLink(magicKey),
+	)
+	jrd.Filter(c.QueryParams()["rel"]...)
 
-	tplData := struct {
-		HostName string
-		UserName string
-		MagicKey string
-	}{
-		HostName: config.GetString("hostname"),
-		UserName: u.Username,
-		MagicKey: magicKey,
+	out, err := json.Marshal(jrd)
+	if err != nil {
+		c.LogErrorf("handlers.WebfingerAcct - json.Marshal failed: %v", err)
+		return c.String(http.StatusInternalServerError, "i'm sorry dave, something went wrong")
 	}
-	b := bytes.NewBuffer(nil)
-	if err = tpl.Execute(b, tplData); err != nil {
-		c.LogErrorf("handlers.WebfingerAcct - template execute failed: %v", err)
-		return c.String(http.StatusInternalServerError, "internal server error")
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	return c.Blob(http.StatusOK, webfinger.ContentType+"; charset=utf-8", out)
+}
+
+// webfingerUsername returns the local username referenced by resource
+// (acct:user@hostname or user URL) or an HTTP status and a message
+func webfingerUsername(resource, hostname string) (username string, status int, msg string) {
+	lower := strings.ToLower(resource)
+	switch {
+	case strings.HasPrefix(lower, "acct:"):
+		acct := lower[5:]
+		// valid ? Same syntax as mail so...
+		if _, err := mail.ParseAddress(acct); err != nil {
+			return "", http.StatusBadRequest, fmt.Sprintf("%s is not a valid actor id", acct)
+		}
+		// local domain ?
+		usernameDomain := strings.Split(acct, "@")
+		if usernameDomain[1] != hostname {
+			return "", http.StatusNotFound, fmt.Sprintf("%s is not referenced here", acct)
+		}
+		return usernameDomain[0], http.StatusOK, ""
+
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+		u, err := url.Parse(resource)
+		if err != nil {
+			return "", http.StatusBadRequest, fmt.Sprintf("%s is not a valid URL", resource)
+		}
+		if strings.ToLower(u.Host) != hostname {
+			return "", http.StatusNotFound, fmt.Sprintf("%s is not referenced here", resource)
+		}
+		// https://host/users/name or https://host/@name
+		switch {
+		case strings.HasPrefix(u.Path, "/users/"):
+			username = u.Path[7:]
+		case strings.HasPrefix(u.Path, "/@"):
+			username = u.Path[2:]
+		}
+		if username == "" || strings.Contains(username, "/") {
+			return "", http.StatusNotFound, fmt.Sprintf("%s is not referenced here", resource)
+		}
+		return strings.ToLower(username), http.StatusOK, ""
 	}
 
-	m := minify.New()
-	m.AddFunc("application/json", json.Minify)
-	out, err := m.Bytes("application/json", b.Bytes())
-	if err != nil {
-		// TODO log err
-		return c.String(http.StatusInternalServerError, "i'm sorry dave, something went wrong")
+	if p := strings.SplitN(lower, ":", 2); len(p) == 2 {
+		return "", http.StatusBadRequest, fmt.Sprintf("%s: is not valid", p[0])
 	}
-	return c.Blob(200, "application/jrd+json; charset=utf-8", out)
+	return "", http.StatusBadRequest, "bad request"
 }
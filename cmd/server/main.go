@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/labstack/echo"
@@ -16,10 +17,16 @@ import (
 	"github.com/peerpx/peerpx/cmd/server/context"
 	"github.com/peerpx/peerpx/cmd/server/handlers"
 	"github.com/peerpx/peerpx/cmd/server/middlewares"
+	"github.com/peerpx/peerpx/cmd/server/queue"
+	"github.com/peerpx/peerpx/cmd/server/tiler"
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/pkg/image"
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/datastore"
 	"github.com/peerpx/peerpx/services/db"
 	"github.com/peerpx/peerpx/services/log"
+	"github.com/peerpx/peerpx/services/processing"
 )
 
 const (
@@ -62,6 +69,9 @@ func main() {
 
 	// init logger props
 
+	// user agent used for federation requests
+	activitypub.UserAgent = fmt.Sprintf("PeerPx (+https://%s)", config.GetString("hostname"))
+
 	// init DB
 	if err = db.InitDatabase("sqlite3", "peerpx.db"); err != nil {
 		log.Errorf("DB initialization failed: %v ", err)
@@ -69,8 +79,54 @@ func main() {
 	}
 
 	// init datastore
-	if err = datastore.InitFilesystemDatastore(config.GetStringDefault(("datastore.path"), path.Join(workingDir, "datastore"))); err != nil {
-		log.Errorf("datastore initialization  PLOPfailed: %v", err)
+	switch dsType := config.GetStringDefault("datastore.type", "fs"); dsType {
+	case "fs":
+		err = datastore.InitFilesystemDatastore(config.GetStringDefault(("datastore.path"), path.Join(workingDir, "datastore")))
+	case "s3":
+		err = datastore.InitS3Datastore(datastore.S3Config{
+			Endpoint:  config.GetString("datastore.s3.endpoint"),
+			Region:    config.GetString("datastore.s3.region"),
+			Bucket:    config.GetString("datastore.s3.bucket"),
+			Prefix:    config.GetString("datastore.s3.prefix"),
+			PathStyle: config.GetBool("datastore.s3.pathStyle"),
+			AccessKey: config.GetString("datastore.s3.accessKey"),
+			SecretKey: config.GetString("datastore.s3.secretKey"),
+			PartSize:  int64(config.GetIntDefault("datastore.s3.partSize", 16)) << 20,
+			Presign:   config.GetDuration("datastore.s3.presign"),
+		})
+	default:
+		err = fmt.Errorf("unknown datastore type %s", dsType)
+	}
+	if err != nil {
+		log.Errorf("datastore initialization failed: %v", err)
+		os.Exit(1)
+	}
+	// encryption at rest
+	if config.GetString("datastore.encryption.key") != "" {
+		if err = initDatastoreEncryption(); err != nil {
+			log.Errorf("datastore encryption initialization failed: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// start federation delivery queue
+	if err = queue.Start(); err != nil {
+		log.Errorf("queue.Start failed: %v", err)
+		os.Exit(1)
+	}
+
+	// max pixels of decoded photos
+	image.MaxPixels = config.GetIntDefault("photo.maxPixels", image.MaxPixels)
+
+	// start image processing pool
+	if err = processing.InitPool(config.GetIntDefault("processing.workers", runtime.NumCPU()), config.GetIntDefault("processing.queueSize", 32)); err != nil {
+		log.Errorf("processing initialization failed: %v", err)
+		os.Exit(1)
+	}
+
+	// start deep zoom tiler
+	if err = tiler.Start(); err != nil {
+		log.Errorf("tiler.Start failed: %v", err)
 		os.Exit(1)
 	}
 
@@ -100,9 +156,31 @@ func main() {
 	// Webfinger
 	e.GET("/.well-known/webfinger", handlers.WebfingerAcct)
 
+	////
+	// Discovery
+	e.GET("/.well-known/nodeinfo", handlers.NodeInfoDiscovery)
+	e.GET("/nodeinfo/:version", handlers.NodeInfo)
+	e.GET("/.well-known/host-meta", handlers.HostMeta)
+	e.GET("/.well-known/host-meta.json", handlers.HostMeta)
+
 	////
 	// ActivityPub
 
+	// shared inbox
+	e.POST("/inbox", handlers.Inbox)
+
+	// user inbox
+	e.POST("/users/:username/inbox", handlers.Inbox)
+
+	// user collections
+	e.GET("/users/:username/outbox", handlers.UserOutbox)
+	e.GET("/users/:username/followers", handlers.UserFollowers)
+	e.GET("/users/:username/following", handlers.UserFollowing)
+	e.GET("/users/:username/collections/featured", handlers.UserFeatured)
+
+	// photo object
+	e.GET("/photos/:hash", handlers.PhotoObject)
+
 	////
 	// User
 
@@ -127,6 +205,27 @@ func main() {
 	// get me
 	e.GET("/api/v1/user/me", handlers.UserMe, middlewares.AuthRequired())
 
+	// update settings
+	e.PUT("/api/v1/user/settings", handlers.UserSettingsPut, middlewares.AuthRequired())
+
+	// followers (?state=pending for follow requests)
+	e.GET("/api/v1/user/followers", handlers.FollowersList, middlewares.AuthRequired())
+
+	// accept follow request
+	e.POST("/api/v1/user/followers/:id/accept", handlers.FollowerAccept, middlewares.AuthRequired())
+
+	// reject follow request / remove follower
+	e.POST("/api/v1/user/followers/:id/reject", handlers.FollowerReject, middlewares.AuthRequired())
+
+	// following
+	e.GET("/api/v1/user/following", handlers.FollowingList, middlewares.AuthRequired())
+
+	// follow a remote actor
+	e.POST("/api/v1/user/following", handlers.FollowingCreate, middlewares.AuthRequired())
+
+	// unfollow
+	e.DELETE("/api/v1/user/following/:id", handlers.FollowingDelete, middlewares.AuthRequired())
+
 	// update user
 	e.PUT("/api/v1/user", handlers.Todo)
 
@@ -159,13 +258,26 @@ func main() {
 	e.GET("/api/v1/photo/:id/:size", handlers.PhotoGet)
 
 	// resize photo by height (in pixel)
+	// deprecated: use IIIF /iiif/3/:id/full/,{height}/0/default.jpg
 	e.GET("/api/v1/photo/:id/height/:height", handlers.PhotoResize)
 
 	// returns photo resized by width
+	// deprecated: use IIIF /iiif/3/:id/full/{width},/0/default.jpg
 	e.GET("/api/v1/photo/:id/width/:width", handlers.PhotoResize)
 
+	// deep zoom (DZI descriptor and tiles)
+	e.GET("/api/v1/photo/:id/tiles.dzi", handlers.PhotoTilesDZI)
+	e.GET("/api/v1/photo/:id/tiles_files/:level/:tile", handlers.PhotoTile)
+
 	// get photo properties -> JSON object
-	e.GET("/api/v1/photo/:id/properties", handlers.PhotoGetProperties)
+	e.GET("/api/v1/photo/:id/properties", handlers.PhotoGetProperties, middlewares.AuthOptional())
+
+	// get original upload (owner only unless photo is public under a CC licence
+	// and owner keeps all metadata)
+	e.GET("/api/v1/photo/:id/original", handlers.PhotoGetOriginal, middlewares.AuthOptional())
+
+	// get photo raw EXIF tags -> JSON object
+	e.GET("/api/v1/photo/:id/exif", handlers.PhotoGetExif, middlewares.AuthOptional())
 
 	// update photo properties
 	e.PUT("/api/v1/photo", handlers.PhotoPut, middlewares.AuthRequired())
@@ -176,6 +288,33 @@ func main() {
 	// search
 	e.GET("/api/v1/photo/search", handlers.PhotoSearch)
 
+	////
+	// IIIF Image API 3.0
+	e.GET("/iiif/3/:id", handlers.IIIFBase)
+	e.GET("/iiif/3/:id/info.json", handlers.IIIFInfo)
+	e.GET("/iiif/3/:id/:region/:size/:rotation/:file", handlers.IIIFImage)
+
+	////
+	// admin
+
+	// federation queue (?state=dead|pending)
+	e.GET("/api/v1/admin/deliveries", handlers.AdminDeliveriesList, middlewares.AuthRequired(), middlewares.AdminRequired())
+
+	// retry delivery
+	e.POST("/api/v1/admin/deliveries/:id/retry", handlers.AdminDeliveryRetry, middlewares.AuthRequired(), middlewares.AdminRequired())
+
+	// remove delivery from queue
+	e.DELETE("/api/v1/admin/deliveries/:id", handlers.AdminDeliveryDelete, middlewares.AuthRequired(), middlewares.AdminRequired())
+
+	// verify photos integrity (?quarantine=true to move corrupted ones out of datastore)
+	e.POST("/api/v1/admin/datastore/scrub", handlers.AdminDatastoreScrub, middlewares.AuthRequired(), middlewares.AdminRequired())
+
+	// reconcile datastore with photos (?repair=true to delete orphans and dangling photos, &grace=24h)
+	e.POST("/api/v1/admin/datastore/gc", handlers.AdminDatastoreGC, middlewares.AuthRequired(), middlewares.AdminRequired())
+
+	// seal again values not sealed with the current encryption key
+	e.POST("/api/v1/admin/datastore/rotate", handlers.AdminDatastoreRotate, middlewares.AuthRequired(), middlewares.AdminRequired())
+
 	// API 404
 	e.Any("/api/*", func(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
@@ -193,3 +332,26 @@ func main() {
 	e.Logger.Fatal(e.Start(fmt.Sprintf("%s:%d", config.GetString("server.ip"), config.GetInt("server.port"))))
 
 }
+
+// initDatastoreEncryption seals datastore values (in chunks: values are
+// still streamed and byte ranges served without reading them in memory)
+// config:
+//   - datastore.encryption.key: sealing key (base64 of 32 bytes)
+//   - datastore.encryption.oldKeys: keys of values not yet sealed again (comma separated)
+//   - datastore.encryption.allowPlaintext: read values stored before encryption
+//     (sizes listed by GC are then sizes of sealed values)
+func initDatastoreEncryption() error {
+	key, err := cryptobox.KeyFromString(config.GetString("datastore.encryption.key"))
+	if err != nil {
+		return err
+	}
+	oldKeys := []*[32]byte{}
+	for _, s := range config.GetStringSlice("datastore.encryption.oldKeys") {
+		oldKey, err := cryptobox.KeyFromString(s)
+		if err != nil {
+			return err
+		}
+		oldKeys = append(oldKeys, oldKey)
+	}
+	return datastore.InitEncryptedDatastore(key, oldKeys, config.GetBool("datastore.encryption.allowPlaintext"))
+}
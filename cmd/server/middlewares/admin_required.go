@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/user"
+)
+
+// AdminRequired check that logged user is an admin
+// must be used after AuthRequired
+func AdminRequired() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ac echo.Context) error {
+			c := ac.(*context.AppContext)
+			u, ok := c.Get("u").(*user.User)
+			if !ok || !u.Admin {
+				c.LogInfof("middleware.AdminRequired - access denied")
+				return echo.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminRequired(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	handler := AdminRequired()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+
+	// no user
+	ctx := context.NewMockedContext(e.NewContext(req, rec))
+	assert.Equal(t, echo.ErrForbidden, handler(ctx))
+
+	// not admin
+	ctx.Set("u", &user.User{ID: 1})
+	assert.Equal(t, echo.ErrForbidden, handler(ctx))
+
+	// ok
+	ctx.Set("u", &user.User{ID: 1, Admin: true})
+	if assert.NoError(t, handler(ctx)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
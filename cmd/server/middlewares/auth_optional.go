@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"database/sql"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// AuthOptional sets the logged user in context (if any)
+// requests without valid session are passed as anonymous ones
+func AuthOptional() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ac echo.Context) error {
+			c := ac.(*context.AppContext)
+
+			username, err := c.SessionGet("username")
+			if err != nil {
+				log.Errorf("%s - %s - middleware.AuthOptional - unable to read session: %v", c.RealIP(), c.UUID, err)
+				return next(c)
+			}
+			if username == nil || username.(string) == "" {
+				return next(c)
+			}
+			u, err := user.GetByUsername(username.(string))
+			switch err {
+			case nil:
+				c.Set("u", u)
+			case sql.ErrNoRows:
+				c.LogInfof("middleware.AuthOptional - cookie is present but user %s is not found", username.(string))
+			default:
+				log.Errorf("%s - %s - middleware.AuthOptional - user.GetByUsername(%s) failed: %v", c.RealIP(), c.UUID, username.(string), err)
+				return err
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/peerpx/peerpx/cmd/server/context"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestAuthOptional(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	handler := AuthOptional()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+
+	// anonymous
+	ctx := context.NewMockedContext(e.NewContext(req, rec))
+	if assert.NoError(t, handler(ctx)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, ctx.Get("u"))
+	}
+
+	// user not found -> anonymous
+	ctx.SessionSet("username", "toorop")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnError(sql.ErrNoRows)
+	if assert.NoError(t, handler(ctx)) {
+		assert.Nil(t, ctx.Get("u"))
+	}
+
+	// ok
+	db.Mock.ExpectQuery("^SELECT(.*)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "toorop"))
+	if assert.NoError(t, handler(ctx)) {
+		assert.Equal(t, uint(1), ctx.Get("u").(*user.User).ID)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
@@ -0,0 +1,280 @@
+// Package queue is the outbound federation queue
+// Activities are stored in DB (see entities/delivery) and posted
+// to remote inboxes by a pool of workers, with exponential backoff
+// on failure and a concurrency limit per remote host
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peerpx/peerpx/entities/delivery"
+	"github.com/peerpx/peerpx/entities/follow"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// ErrAlreadyStarted is returned by Start if queue is running
+var ErrAlreadyStarted = errors.New("queue: already started")
+
+var (
+	mu       sync.Mutex
+	running  bool
+	inFlight map[uint]bool  // deliveries handled by a worker
+	perHost  map[string]int // number of deliveries in flight per host
+	jobs     chan delivery.Delivery
+	wake     chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+)
+
+// send posts body of d to its inbox
+// (var for testing purpose)
+var send = func(d *delivery.Delivery) error {
+	u, err := user.GetByID(int(d.UserID))
+	if err != nil {
+		return fmt.Errorf("get user %d failed: %v", d.UserID, err)
+	}
+	client, err := u.Client()
+	if err != nil {
+		return err
+	}
+	return client.Post(d.Inbox, []byte(d.Body))
+}
+
+// Enqueue queues activity for delivery to inboxes on behalf of u
+// Each inbox receives the activity once
+func Enqueue(u *user.User, inboxes []string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(inboxes))
+	for _, inbox := range inboxes {
+		if inbox == "" || seen[inbox] {
+			continue
+		}
+		seen[inbox] = true
+		iu, err := url.Parse(inbox)
+		if err != nil || iu.Host == "" {
+			log.Errorf("queue.Enqueue - bad inbox %s: %v", inbox, err)
+			continue
+		}
+		d := &delivery.Delivery{
+			UserID: u.ID,
+			Inbox:  inbox,
+			Host:   strings.ToLower(iu.Host),
+			Body:   string(body),
+		}
+		if err = d.Create(); err != nil {
+			return err
+		}
+	}
+	Wake()
+	return nil
+}
+
+// EnqueueToFollowers queues activity for delivery to accepted followers of u
+// Shared inboxes are used when available, so each remote server
+// receives the activity once
+func EnqueueToFollowers(u *user.User, activity interface{}) error {
+	followers, err := follow.ListFollowers(u.ID, follow.StateAccepted)
+	if err != nil {
+		return err
+	}
+	inboxes := make([]string, 0, len(followers))
+	for _, f := range followers {
+		if f.SharedInbox != "" {
+			inboxes = append(inboxes, f.SharedInbox)
+		} else {
+			inboxes = append(inboxes, f.Inbox)
+		}
+	}
+	return Enqueue(u, inboxes, activity)
+}
+
+// Start starts the dispatcher and the workers
+// config:
+//  - delivery.workers: number of workers (default 4)
+//  - delivery.perHostConcurrency: max deliveries in flight per host (default 2)
+//  - delivery.pollInterval: interval between DB polls (default 5s)
+func Start() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if running {
+		return ErrAlreadyStarted
+	}
+	running = true
+	inFlight = make(map[uint]bool)
+	perHost = make(map[string]int)
+	jobs = make(chan delivery.Delivery)
+	wake = make(chan struct{}, 1)
+	stop = make(chan struct{})
+
+	workers := config.GetIntDefault("delivery.workers", 4)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	wg.Add(1)
+	go dispatcher()
+	return nil
+}
+
+// Stop stops the dispatcher and waits for workers to finish
+// their current delivery
+func Stop() {
+	mu.Lock()
+	if !running {
+		mu.Unlock()
+		return
+	}
+	running = false
+	close(stop)
+	mu.Unlock()
+	wg.Wait()
+}
+
+// Wake triggers a dispatch without waiting for the next poll
+func Wake() {
+	mu.Lock()
+	defer mu.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatcher polls DB for due deliveries and hands them to workers
+func dispatcher() {
+	defer wg.Done()
+	defer close(jobs)
+	pollInterval := config.GetDurationDefault("delivery.pollInterval", 5*time.Second)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		dispatch()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// dispatchPageSize is the number of due deliveries fetched at once
+const dispatchPageSize = 100
+
+// dispatch hands due deliveries to workers
+// respecting per host concurrency limit
+// saturated hosts are excluded from due deliveries and pages are fetched
+// until one is not full or dispatches nothing: a busy host can't stall others
+func dispatch() {
+	maxPerHost := config.GetIntDefault("delivery.perHostConcurrency", 2)
+	for {
+		deliveries, err := delivery.ListDue(time.Now(), dispatchPageSize, saturatedHosts(maxPerHost)...)
+		if err != nil {
+			log.Errorf("queue.dispatch - delivery.ListDue failed: %v", err)
+			return
+		}
+		dispatched := 0
+		for _, d := range deliveries {
+			mu.Lock()
+			if inFlight[d.ID] || perHost[d.Host] >= maxPerHost {
+				mu.Unlock()
+				continue
+			}
+			inFlight[d.ID] = true
+			perHost[d.Host]++
+			mu.Unlock()
+			select {
+			case jobs <- d:
+				dispatched++
+			case <-stop:
+				release(&d)
+				return
+			}
+		}
+		if len(deliveries) < dispatchPageSize || dispatched == 0 {
+			return
+		}
+	}
+}
+
+// saturatedHosts returns hosts with maxPerHost deliveries in flight
+func saturatedHosts(maxPerHost int) (hosts []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for host, n := range perHost {
+		if n >= maxPerHost {
+			hosts = append(hosts, host)
+		}
+	}
+	return
+}
+
+// release marks d as no longer in flight
+func release(d *delivery.Delivery) {
+	mu.Lock()
+	delete(inFlight, d.ID)
+	perHost[d.Host]--
+	if perHost[d.Host] <= 0 {
+		delete(perHost, d.Host)
+	}
+	mu.Unlock()
+}
+
+// worker processes deliveries until jobs is closed
+func worker() {
+	defer wg.Done()
+	for d := range jobs {
+		process(&d)
+		release(&d)
+		// a slot is free for this host
+		Wake()
+	}
+}
+
+// process attempts delivery d
+func process(d *delivery.Delivery) {
+	err := send(d)
+	if err == nil {
+		if err = d.Delete(); err != nil {
+			log.Errorf("queue.process - delete delivery %d failed: %v", d.ID, err)
+		}
+		return
+	}
+	maxAttempts := config.GetIntDefault("delivery.maxAttempts", 10)
+	log.Infof("queue.process - delivery %d to %s failed (attempt %d/%d): %v", d.ID, d.Inbox, d.Attempts+1, maxAttempts, err)
+	if err = d.Failed(err, backoff, maxAttempts); err != nil {
+		log.Errorf("queue.process - update delivery %d failed: %v", d.ID, err)
+	}
+}
+
+// backoff returns delay before the next attempt
+// delivery.backoffBase * 2^(attempts-1), bounded by delivery.backoffMax
+func backoff(attempts int) time.Duration {
+	base := config.GetDurationDefault("delivery.backoffBase", 30*time.Second)
+	max := config.GetDurationDefault("delivery.backoffMax", 12*time.Hour)
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
@@ -0,0 +1,164 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peerpx/peerpx/entities/delivery"
+	"github.com/peerpx/peerpx/entities/user"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	db.InitMockedDatabase()
+}
+
+func TestEnqueue(t *testing.T) {
+	u := &user.User{ID: 1}
+	// duplicate and bad inboxes are skipped
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").
+		ExpectExec().
+		WithArgs(1, "https://remote.social/inbox", "remote.social", `{"type":"Create"}`, 0, "pending", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").
+		ExpectExec().
+		WithArgs(1, "https://other.social/users/jane/inbox", "other.social", `{"type":"Create"}`, 0, "pending", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	inboxes := []string{"https://remote.social/inbox", "", "https://remote.social/inbox", "https://other.social/users/jane/inbox", "foo"}
+	assert.NoError(t, Enqueue(u, inboxes, map[string]string{"type": "Create"}))
+
+	// followers: shared inbox first
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "inbox", "shared_inbox"}).
+		AddRow(1, "https://remote.social/users/john/inbox", "https://remote.social/inbox").
+		AddRow(2, "https://remote.social/users/jack/inbox", "https://remote.social/inbox").
+		AddRow(3, "https://other.social/users/jane/inbox", ""))
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").
+		ExpectExec().
+		WithArgs(1, "https://remote.social/inbox", "remote.social", `{"type":"Create"}`, 0, "pending", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").
+		ExpectExec().
+		WithArgs(1, "https://other.social/users/jane/inbox", "other.social", `{"type":"Create"}`, 0, "pending", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	assert.NoError(t, EnqueueToFollowers(u, map[string]string{"type": "Create"}))
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestProcess(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("delivery.maxAttempts", "2")
+	defer func(s func(d *delivery.Delivery) error) { send = s }(send)
+
+	// ok -> removed
+	send = func(d *delivery.Delivery) error {
+		return nil
+	}
+	db.Mock.ExpectPrepare("^DELETE FROM deliveries (.*)").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	process(&delivery.Delivery{ID: 1})
+
+	// failed -> dead after maxAttempts
+	send = func(d *delivery.Delivery) error {
+		return errors.New("503 Service Unavailable")
+	}
+	d := &delivery.Delivery{ID: 2, State: delivery.StatePending}
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	process(d)
+	assert.Equal(t, delivery.StatePending, d.State)
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	process(d)
+	assert.Equal(t, delivery.StateDead, d.State)
+	assert.Equal(t, "503 Service Unavailable", d.LastError)
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestBackoff(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("delivery.backoffBase", "1m")
+	config.Set("delivery.backoffMax", "1h")
+	assert.Equal(t, time.Minute, backoff(1))
+	assert.Equal(t, 2*time.Minute, backoff(2))
+	assert.Equal(t, 32*time.Minute, backoff(6))
+	assert.Equal(t, time.Hour, backoff(7))
+	assert.Equal(t, time.Hour, backoff(100))
+}
+
+func TestStartStop(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("delivery.pollInterval", "1h")
+	config.Set("delivery.perHostConcurrency", "1")
+	defer func(s func(d *delivery.Delivery) error) { send = s }(send)
+	sent := make(chan uint, 2)
+	send = func(d *delivery.Delivery) error {
+		sent <- d.ID
+		return nil
+	}
+
+	// two due deliveries for the same host, only one is dispatched
+	// at a time (perHostConcurrency)
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).
+		AddRow(1, "remote.social").
+		AddRow(2, "remote.social"))
+	db.Mock.ExpectPrepare("^DELETE FROM deliveries (.*)").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).
+		AddRow(2, "remote.social"))
+	db.Mock.ExpectPrepare("^DELETE FROM deliveries (.*)").
+		ExpectExec().
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}))
+
+	assert.NoError(t, Start())
+	assert.Equal(t, ErrAlreadyStarted, Start())
+	assert.Equal(t, uint(1), <-sent)
+	assert.Equal(t, uint(2), <-sent)
+	// let the last dispatch run
+	time.Sleep(100 * time.Millisecond)
+	Stop()
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestDispatch_busyHost(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("delivery.perHostConcurrency", "2")
+	inFlight = make(map[uint]bool)
+	perHost = make(map[string]int)
+	jobs = make(chan delivery.Delivery, 10)
+	stop = make(chan struct{})
+	defer func() { inFlight, perHost, jobs = nil, nil, nil }()
+
+	// 150 due deliveries to busy.social: the first page is full of them
+	rows := sqlmock.NewRows([]string{"id", "host"})
+	for id := 1; id <= dispatchPageSize; id++ {
+		rows.AddRow(id, "busy.social")
+	}
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("pending", sqlmock.AnyArg(), dispatchPageSize).WillReturnRows(rows)
+	// busy.social is saturated: the next page skips it
+	db.Mock.ExpectQuery(`^SELECT (.*) AND host NOT IN \(\?\)`).
+		WithArgs("pending", sqlmock.AnyArg(), "busy.social", dispatchPageSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(151, "other.social"))
+
+	dispatch()
+	close(jobs)
+	var dispatched []uint
+	for d := range jobs {
+		dispatched = append(dispatched, d.ID)
+	}
+	assert.Equal(t, []uint{1, 2, 151}, dispatched)
+	assert.Equal(t, map[string]int{"busy.social": 2, "other.social": 1}, perHost)
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
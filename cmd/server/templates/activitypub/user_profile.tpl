@@ -20,7 +20,7 @@
     "name": "{{.UserName}}",
     "summary": "{{.Summary}}",
     "url": "https://{{.BaseURL}}/@{{.UserName}}",
-    "manuallyApprovesFollowers": false,
+    "manuallyApprovesFollowers": {{.ManuallyApprovesFollowers}},
     "publicKey": {
         "id": "https://{{.BaseURL}}/users/{{.UserName}}#main-key",
         "owner": "https://{{.BaseURL}}/users/{{.UserName}}",
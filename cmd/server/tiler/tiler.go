@@ -0,0 +1,109 @@
+// Package tiler generates deep zoom tile pyramids of photos in background
+// (see photo.GenerateTiles)
+// Pending photos are kept in memory: pyramids missing after a restart
+// are queued again when they are requested
+package tiler
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/peerpx/peerpx/entities/photo"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// ErrAlreadyStarted is returned by Start if tiler is running
+var ErrAlreadyStarted = errors.New("tiler: already started")
+
+var (
+	mu      sync.Mutex
+	running bool
+	pending map[string]bool // photos queued or being tiled
+	jobs    chan string
+	wg      sync.WaitGroup
+)
+
+// generate generates tiles of photo hash
+// (var for testing purpose)
+var generate = photo.GenerateTiles
+
+// Enqueue queues generation of the tile pyramid of photo hash
+// it returns false if tiler is not running or its queue is full
+func Enqueue(hash string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if !running {
+		return false
+	}
+	if pending[hash] {
+		return true
+	}
+	select {
+	case jobs <- hash:
+		pending[hash] = true
+		return true
+	default:
+		log.Infof("tiler.Enqueue - queue is full, %s skipped", hash)
+		return false
+	}
+}
+
+// Start starts the workers
+// config:
+//   - tiles.workers: number of workers (default 1)
+//   - tiles.queueSize: max number of queued photos (default 100)
+func Start() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if running {
+		return ErrAlreadyStarted
+	}
+	running = true
+	pending = make(map[string]bool)
+	jobs = make(chan string, config.GetIntDefault("tiles.queueSize", 100))
+
+	workers := config.GetIntDefault("tiles.workers", 1)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	return nil
+}
+
+// Stop stops the workers once they have finished their current photo
+// (queued photos are dropped)
+func Stop() {
+	mu.Lock()
+	if !running {
+		mu.Unlock()
+		return
+	}
+	running = false
+	close(jobs)
+	mu.Unlock()
+	wg.Wait()
+}
+
+// worker tiles photos until jobs is closed
+func worker() {
+	defer wg.Done()
+	for hash := range jobs {
+		mu.Lock()
+		stopped := !running
+		mu.Unlock()
+		if !stopped {
+			process(hash)
+		}
+	}
+}
+
+// process generates tiles of photo hash
+func process(hash string) {
+	if err := generate(hash); err != nil {
+		log.Errorf("tiler.process - photo.GenerateTiles(%s) failed: %v", hash, err)
+	}
+	mu.Lock()
+	delete(pending, hash)
+	mu.Unlock()
+}
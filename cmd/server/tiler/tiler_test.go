@@ -0,0 +1,58 @@
+package tiler
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiler(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	defer func(g func(hash string) error) { generate = g }(generate)
+
+	var mu sync.Mutex
+	var generated []string
+	done := make(chan struct{})
+	generate = func(hash string) error {
+		mu.Lock()
+		generated = append(generated, hash)
+		mu.Unlock()
+		done <- struct{}{}
+		if hash == "bad" {
+			return errors.New("mocked")
+		}
+		return nil
+	}
+
+	// not running
+	assert.False(t, Enqueue("hash"))
+
+	if assert.NoError(t, Start()) {
+		assert.Equal(t, ErrAlreadyStarted, Start())
+		assert.True(t, Enqueue("bad"))
+		<-done
+		assert.True(t, Enqueue("hash"))
+		<-done
+		Stop()
+		assert.Equal(t, []string{"bad", "hash"}, generated)
+		assert.False(t, Enqueue("hash"))
+	}
+}
+
+func TestEnqueue_full(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("tiles.workers", "0")
+	config.Set("tiles.queueSize", "1")
+	if err := Start(); err != nil {
+		panic(err)
+	}
+	defer Stop()
+	assert.True(t, Enqueue("a"))
+	// already queued
+	assert.True(t, Enqueue("a"))
+	assert.False(t, Enqueue("b"))
+}
@@ -0,0 +1,72 @@
+package activity
+
+import (
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+)
+
+// Activity represents an ActivityPub activity received in one of our inboxes
+type Activity struct {
+	ID         uint      `json:"id"`
+	IRI        string    `json:"iri"`
+	Type       string    `json:"type"`
+	Actor      string    `json:"actor"`
+	Object     string    `json:"object"`
+	UserID     uint      `db:"user_id" json:"user_id"` // recipient, 0 if received on the shared inbox
+	Raw        string    `json:"raw"`
+	ReceivedAt time.Time `db:"received_at" json:"received_at"`
+}
+
+// GetByIRI returns activity from its IRI
+func GetByIRI(iri string) (activity *Activity, err error) {
+	activity = new(Activity)
+	err = db.Get(activity, "SELECT * FROM activities WHERE iri = ?", iri)
+	return
+}
+
+// Create save new activity in DB
+func (a *Activity) Create() error {
+	stmt, err := db.Preparex("INSERT INTO activities (iri, type, actor, object, user_id, raw, received_at) VALUES (?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	a.ReceivedAt = time.Now()
+	res, err := stmt.Exec(a.IRI, a.Type, a.Actor, a.Object, a.UserID, a.Raw, a.ReceivedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = uint(id)
+	return nil
+}
+
+// ListByObject returns activities of actor about object
+func ListByObject(actor, object string) (activities []Activity, err error) {
+	err = db.Select(&activities, "SELECT * FROM activities WHERE actor = ? AND object = ?", actor, object)
+	return
+}
+
+// Update update activity in DB
+func (a *Activity) Update() error {
+	stmt, err := db.Preparex("UPDATE activities SET iri = ?, type = ?, actor = ?, object = ?, user_id = ?, raw = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(a.IRI, a.Type, a.Actor, a.Object, a.UserID, a.Raw, a.ID)
+	return err
+}
+
+// DeleteByObject removes activities of actor about object
+// (but the one given by keepIRI if not empty, usually the Delete itself)
+func DeleteByObject(actor, object, keepIRI string) error {
+	stmt, err := db.Preparex("DELETE FROM activities WHERE actor = ? AND object = ? AND iri <> ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(actor, object, keepIRI)
+	return err
+}
@@ -0,0 +1,53 @@
+package activity
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	db.InitMockedDatabase()
+}
+
+func TestGetByIRI(t *testing.T) {
+	row := sqlmock.NewRows([]string{"id", "iri", "type"}).AddRow(1, "https://remote.social/1", "Follow")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
+	a, err := GetByIRI("https://remote.social/1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(1), a.ID)
+		assert.Equal(t, "Follow", a.Type)
+	}
+}
+
+func TestActivity_Create(t *testing.T) {
+	a := &Activity{
+		IRI:   "https://remote.social/1",
+		Type:  "Follow",
+		Actor: "https://remote.social/users/john",
+	}
+
+	// prepare failed
+	db.Mock.ExpectPrepare("^INSERT INTO activities (.*)").WillReturnError(errors.New("mocked"))
+	assert.EqualError(t, a.Create(), "mocked")
+
+	// ok
+	db.Mock.ExpectPrepare("^INSERT INTO activities (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, a.Create()) {
+		assert.Equal(t, uint(1), a.ID)
+		assert.False(t, a.ReceivedAt.IsZero())
+	}
+}
+
+func TestDeleteByObject(t *testing.T) {
+	db.Mock.ExpectPrepare("^DELETE FROM activities (.*)").
+		ExpectExec().
+		WithArgs("https://remote.social/users/john", "https://remote.social/photos/1", "https://remote.social/2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.NoError(t, DeleteByObject("https://remote.social/users/john", "https://remote.social/photos/1", "https://remote.social/2"))
+}
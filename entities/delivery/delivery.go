@@ -0,0 +1,132 @@
+package delivery
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+)
+
+// State is the state of a delivery
+type State string
+
+const (
+	// StatePending delivery is waiting for its next attempt
+	StatePending State = "pending"
+	// StateDead delivery failed too many times and will not be retried
+	StateDead State = "dead"
+)
+
+// Delivery is an activity waiting to be posted to a remote inbox
+// Successful deliveries are removed from DB
+type Delivery struct {
+	ID            uint      `json:"id"`
+	UserID        uint      `db:"user_id" json:"user_id"` // sender
+	Inbox         string    `json:"inbox"`
+	Host          string    `json:"host"` // inbox host
+	Body          string    `json:"body"` // activity (JSON)
+	Attempts      int       `json:"attempts"`
+	State         State     `json:"state"`
+	LastError     string    `db:"last_error" json:"last_error"`
+	NextAttemptAt time.Time `db:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+}
+
+// GetByID returns delivery by its ID
+func GetByID(id uint) (delivery *Delivery, err error) {
+	delivery = new(Delivery)
+	err = db.Get(delivery, "SELECT * FROM deliveries WHERE id = ?", id)
+	return
+}
+
+// ListDue returns at most limit pending deliveries whose next attempt is before t
+// deliveries to excludedHosts are skipped
+func ListDue(t time.Time, limit int, excludedHosts ...string) (deliveries []Delivery, err error) {
+	query := "SELECT * FROM deliveries WHERE state = ? AND next_attempt_at <= ?"
+	params := []interface{}{StatePending, t}
+	if len(excludedHosts) != 0 {
+		query += " AND host NOT IN (?" + strings.Repeat(", ?", len(excludedHosts)-1) + ")"
+		for _, host := range excludedHosts {
+			params = append(params, host)
+		}
+	}
+	err = db.Select(&deliveries, query+" ORDER BY next_attempt_at LIMIT ?", append(params, limit)...)
+	return
+}
+
+// List returns at most limit deliveries in state state (most recent first)
+func List(state State, limit int) (deliveries []Delivery, err error) {
+	err = db.Select(&deliveries, "SELECT * FROM deliveries WHERE state = ? ORDER BY id DESC LIMIT ?", state, limit)
+	return
+}
+
+// Create save new delivery in DB
+func (d *Delivery) Create() error {
+	stmt, err := db.Preparex("INSERT INTO deliveries (user_id, inbox, host, body, attempts, state, last_error, next_attempt_at, created_at) VALUES (?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	d.CreatedAt = time.Now()
+	if d.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = d.CreatedAt
+	}
+	if d.State == "" {
+		d.State = StatePending
+	}
+	res, err := stmt.Exec(d.UserID, d.Inbox, d.Host, d.Body, d.Attempts, d.State, d.LastError, d.NextAttemptAt, d.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	d.ID = uint(id)
+	return nil
+}
+
+// Update update delivery in DB
+func (d *Delivery) Update() error {
+	if d.ID == 0 {
+		return errors.New("delivery is not recorded in DB yet, i can't update it")
+	}
+	stmt, err := db.Preparex("UPDATE deliveries SET attempts = ?, state = ?, last_error = ?, next_attempt_at = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(d.Attempts, d.State, d.LastError, d.NextAttemptAt, d.ID)
+	return err
+}
+
+// Delete removes delivery from DB
+func (d *Delivery) Delete() error {
+	stmt, err := db.Preparex("DELETE FROM deliveries WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(d.ID)
+	return err
+}
+
+// Failed records a failed attempt
+// Next attempt is delayed by backoff or delivery is marked
+// as dead if it has been attempted maxAttempts times
+func (d *Delivery) Failed(err error, backoff func(attempts int) time.Duration, maxAttempts int) error {
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= maxAttempts {
+		d.State = StateDead
+	} else {
+		d.NextAttemptAt = time.Now().Add(backoff(d.Attempts))
+	}
+	return d.Update()
+}
+
+// Retry resets a delivery for an immediate new attempt
+func (d *Delivery) Retry() error {
+	d.Attempts = 0
+	d.State = StatePending
+	d.NextAttemptAt = time.Now()
+	return d.Update()
+}
@@ -0,0 +1,87 @@
+package delivery
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	db.InitMockedDatabase()
+}
+
+func TestDelivery_Create(t *testing.T) {
+	d := &Delivery{UserID: 1, Inbox: "https://remote.social/inbox", Host: "remote.social", Body: "{}"}
+
+	// prepare failed
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").WillReturnError(errors.New("mocked"))
+	assert.EqualError(t, d.Create(), "mocked")
+
+	// ok
+	db.Mock.ExpectPrepare("^INSERT INTO deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, d.Create()) {
+		assert.Equal(t, uint(1), d.ID)
+		assert.Equal(t, StatePending, d.State)
+		assert.False(t, d.NextAttemptAt.IsZero())
+	}
+}
+
+func TestListDue(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"id", "inbox", "state"}).AddRow(1, "https://remote.social/inbox", "pending")
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("pending", sqlmock.AnyArg(), 10).WillReturnRows(rows)
+	deliveries, err := ListDue(time.Now(), 10)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(deliveries))
+	}
+
+	// excluded hosts
+	db.Mock.ExpectQuery(`^SELECT (.*) AND host NOT IN \(\?, \?\) ORDER BY`).
+		WithArgs("pending", sqlmock.AnyArg(), "a.social", "b.social", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	deliveries, err = ListDue(time.Now(), 10, "a.social", "b.social")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, len(deliveries))
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestDelivery_Failed(t *testing.T) {
+	backoff := func(attempts int) time.Duration {
+		return time.Duration(attempts) * time.Hour
+	}
+	d := &Delivery{ID: 1, State: StatePending, Attempts: 1}
+
+	// retry later
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, d.Failed(errors.New("503"), backoff, 3)) {
+		assert.Equal(t, 2, d.Attempts)
+		assert.Equal(t, StatePending, d.State)
+		assert.Equal(t, "503", d.LastError)
+		assert.True(t, d.NextAttemptAt.After(time.Now().Add(time.Hour)))
+	}
+
+	// dead
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, d.Failed(errors.New("503"), backoff, 3)) {
+		assert.Equal(t, StateDead, d.State)
+	}
+
+	// retry
+	db.Mock.ExpectPrepare("^UPDATE deliveries (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, d.Retry()) {
+		assert.Equal(t, 0, d.Attempts)
+		assert.Equal(t, StatePending, d.State)
+	}
+}
@@ -0,0 +1,227 @@
+package follow
+
+import (
+	"errors"
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+)
+
+// State is the state of a follow relationship
+type State string
+
+const (
+	// StatePending follow request is waiting for approval
+	StatePending State = "pending"
+	// StateAccepted follow request has been accepted
+	StateAccepted State = "accepted"
+)
+
+// Follower represents a remote actor following a local user
+type Follower struct {
+	ID          uint      `json:"id"`
+	UserID      uint      `db:"user_id" json:"user_id"`
+	Actor       string    `json:"actor"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `db:"shared_inbox" json:"shared_inbox"`
+	ActivityIRI string    `db:"activity_iri" json:"activity_iri"` // IRI of the Follow activity
+	State       State     `json:"state"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// Following represents a remote actor followed by a local user
+type Following struct {
+	ID          uint      `json:"id"`
+	UserID      uint      `db:"user_id" json:"user_id"`
+	Actor       string    `json:"actor"`
+	Inbox       string    `json:"inbox"`
+	ActivityIRI string    `db:"activity_iri" json:"activity_iri"` // IRI of our Follow activity
+	State       State     `json:"state"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+////
+// Followers
+
+// GetFollower returns follower actor of user userID
+func GetFollower(userID uint, actor string) (follower *Follower, err error) {
+	follower = new(Follower)
+	err = db.Get(follower, "SELECT * FROM followers WHERE user_id = ? AND actor = ?", userID, actor)
+	return
+}
+
+// GetFollowerByID returns follower by its ID
+func GetFollowerByID(id uint) (follower *Follower, err error) {
+	follower = new(Follower)
+	err = db.Get(follower, "SELECT * FROM followers WHERE id = ?", id)
+	return
+}
+
+// ListFollowers returns followers of user userID in state state
+func ListFollowers(userID uint, state State) (followers []Follower, err error) {
+	err = db.Select(&followers, "SELECT * FROM followers WHERE user_id = ? AND state = ? ORDER BY id DESC", userID, state)
+	return
+}
+
+// ListFollowersBefore returns at most limit followers of user userID in state state
+// with an id lower than maxID (0: no limit)
+func ListFollowersBefore(userID uint, state State, maxID uint, limit int) (followers []Follower, err error) {
+	if maxID == 0 {
+		err = db.Select(&followers, "SELECT * FROM followers WHERE user_id = ? AND state = ? ORDER BY id DESC LIMIT ?", userID, state, limit)
+		return
+	}
+	err = db.Select(&followers, "SELECT * FROM followers WHERE user_id = ? AND state = ? AND id < ? ORDER BY id DESC LIMIT ?", userID, state, maxID, limit)
+	return
+}
+
+// CountFollowers returns the number of followers of user userID in state state
+func CountFollowers(userID uint, state State) (count int, err error) {
+	err = db.Get(&count, "SELECT COUNT(*) FROM followers WHERE user_id = ? AND state = ?", userID, state)
+	return
+}
+
+// Create save new follower in DB
+func (f *Follower) Create() error {
+	stmt, err := db.Preparex("INSERT INTO followers (user_id, actor, inbox, shared_inbox, activity_iri, state, created_at) VALUES (?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	f.CreatedAt = time.Now()
+	res, err := stmt.Exec(f.UserID, f.Actor, f.Inbox, f.SharedInbox, f.ActivityIRI, f.State, f.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	f.ID = uint(id)
+	return nil
+}
+
+// Update update follower in DB
+func (f *Follower) Update() error {
+	if f.ID == 0 {
+		return errors.New("follower is not recorded in DB yet, i can't update it")
+	}
+	stmt, err := db.Preparex("UPDATE followers SET inbox = ?, shared_inbox = ?, activity_iri = ?, state = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(f.Inbox, f.SharedInbox, f.ActivityIRI, f.State, f.ID)
+	return err
+}
+
+// Delete removes follower from DB
+func (f *Follower) Delete() error {
+	stmt, err := db.Preparex("DELETE FROM followers WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(f.ID)
+	return err
+}
+
+////
+// Following
+
+// GetFollowing returns actor followed by user userID
+func GetFollowing(userID uint, actor string) (following *Following, err error) {
+	following = new(Following)
+	err = db.Get(following, "SELECT * FROM following WHERE user_id = ? AND actor = ?", userID, actor)
+	return
+}
+
+// GetFollowingByID returns following by its ID
+func GetFollowingByID(id uint) (following *Following, err error) {
+	following = new(Following)
+	err = db.Get(following, "SELECT * FROM following WHERE id = ?", id)
+	return
+}
+
+// GetFollowingByActivityIRI returns following by the IRI of our Follow activity
+func GetFollowingByActivityIRI(iri string) (following *Following, err error) {
+	following = new(Following)
+	err = db.Get(following, "SELECT * FROM following WHERE activity_iri = ?", iri)
+	return
+}
+
+// ListFollowing returns actors followed by user userID in state state
+func ListFollowing(userID uint, state State) (followings []Following, err error) {
+	err = db.Select(&followings, "SELECT * FROM following WHERE user_id = ? AND state = ? ORDER BY id DESC", userID, state)
+	return
+}
+
+// ListFollowingBefore returns at most limit actors followed by user userID in state state
+// with an id lower than maxID (0: no limit)
+func ListFollowingBefore(userID uint, state State, maxID uint, limit int) (followings []Following, err error) {
+	if maxID == 0 {
+		err = db.Select(&followings, "SELECT * FROM following WHERE user_id = ? AND state = ? ORDER BY id DESC LIMIT ?", userID, state, limit)
+		return
+	}
+	err = db.Select(&followings, "SELECT * FROM following WHERE user_id = ? AND state = ? AND id < ? ORDER BY id DESC LIMIT ?", userID, state, maxID, limit)
+	return
+}
+
+// CountFollowing returns the number of actors followed by user userID in state state
+func CountFollowing(userID uint, state State) (count int, err error) {
+	err = db.Get(&count, "SELECT COUNT(*) FROM following WHERE user_id = ? AND state = ?", userID, state)
+	return
+}
+
+// Create save new following in DB
+func (f *Following) Create() error {
+	stmt, err := db.Preparex("INSERT INTO following (user_id, actor, inbox, activity_iri, state, created_at) VALUES (?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	f.CreatedAt = time.Now()
+	res, err := stmt.Exec(f.UserID, f.Actor, f.Inbox, f.ActivityIRI, f.State, f.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	f.ID = uint(id)
+	return nil
+}
+
+// Update update following in DB
+func (f *Following) Update() error {
+	if f.ID == 0 {
+		return errors.New("following is not recorded in DB yet, i can't update it")
+	}
+	stmt, err := db.Preparex("UPDATE following SET inbox = ?, activity_iri = ?, state = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(f.Inbox, f.ActivityIRI, f.State, f.ID)
+	return err
+}
+
+// Delete removes following from DB
+func (f *Following) Delete() error {
+	stmt, err := db.Preparex("DELETE FROM following WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(f.ID)
+	return err
+}
+
+// DeleteActor removes every relationship with actor
+// (used when a remote actor is deleted)
+func DeleteActor(actor string) error {
+	for _, table := range []string{"followers", "following"} {
+		stmt, err := db.Preparex("DELETE FROM " + table + " WHERE actor = ?")
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.Exec(actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package follow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	db.InitMockedDatabase()
+}
+
+func TestGetFollower(t *testing.T) {
+	row := sqlmock.NewRows([]string{"id", "user_id", "actor", "state"}).AddRow(1, 2, "https://remote.social/users/john", "pending")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
+	f, err := GetFollower(2, "https://remote.social/users/john")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(1), f.ID)
+		assert.Equal(t, StatePending, f.State)
+	}
+}
+
+func TestListFollowers(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"id", "actor"}).AddRow(2, "https://remote.social/users/john").AddRow(1, "https://remote.social/users/jane")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(rows)
+	followers, err := ListFollowers(1, StateAccepted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, len(followers))
+	}
+}
+
+func TestListFollowersBefore(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"id", "actor"}).AddRow(4, "https://remote.social/users/john")
+	db.Mock.ExpectQuery("^SELECT(.*) AND id < (.*)").WithArgs(1, "accepted", 5, 1).WillReturnRows(rows)
+	followers, err := ListFollowersBefore(1, StateAccepted, 5, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(followers))
+	}
+
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := CountFollowers(1, StateAccepted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+}
+
+func TestFollower_Create(t *testing.T) {
+	f := &Follower{UserID: 1, Actor: "https://remote.social/users/john", State: StateAccepted}
+
+	// prepare failed
+	db.Mock.ExpectPrepare("^INSERT INTO followers (.*)").WillReturnError(errors.New("mocked"))
+	assert.EqualError(t, f.Create(), "mocked")
+
+	// ok
+	db.Mock.ExpectPrepare("^INSERT INTO followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, f.Create()) {
+		assert.Equal(t, uint(1), f.ID)
+	}
+}
+
+func TestFollower_Update(t *testing.T) {
+	f := new(Follower)
+	assert.Error(t, f.Update())
+
+	f.ID = 1
+	db.Mock.ExpectPrepare("^UPDATE followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, f.Update())
+}
+
+func TestFollower_Delete(t *testing.T) {
+	f := &Follower{ID: 1}
+	db.Mock.ExpectPrepare("^DELETE FROM followers (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, f.Delete())
+}
+
+func TestFollowing(t *testing.T) {
+	f := &Following{UserID: 1, Actor: "https://remote.social/users/john", State: StatePending}
+	db.Mock.ExpectPrepare("^INSERT INTO following (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	if assert.NoError(t, f.Create()) {
+		assert.Equal(t, uint(3), f.ID)
+	}
+
+	db.Mock.ExpectPrepare("^UPDATE following (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	assert.NoError(t, f.Update())
+
+	row := sqlmock.NewRows([]string{"id", "activity_iri"}).AddRow(3, "https://peerpx.social/users/toorop#follows/3")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
+	f, err := GetFollowingByActivityIRI("https://peerpx.social/users/toorop#follows/3")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(3), f.ID)
+	}
+
+	db.Mock.ExpectPrepare("^DELETE FROM following (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	assert.NoError(t, f.Delete())
+}
+
+func TestDeleteActor(t *testing.T) {
+	db.Mock.ExpectPrepare("^DELETE FROM followers (.*)").
+		ExpectExec().
+		WithArgs("https://remote.social/users/john").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	db.Mock.ExpectPrepare("^DELETE FROM following (.*)").
+		ExpectExec().
+		WithArgs("https://remote.social/users/john").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.NoError(t, DeleteActor("https://remote.social/users/john"))
+}
@@ -0,0 +1,49 @@
+package photo
+
+import (
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/datastore"
+)
+
+// DerivativeKey returns the datastore key of derivative spec of photo hash
+// (spec must only contain chars usable in file names)
+func DerivativeKey(hash, spec string) string {
+	return hash + "_d_" + spec
+}
+
+// GetDerivative returns derivative spec of photo hash from datastore
+// datastore.ErrNotFound is returned if it is not cached
+func GetDerivative(hash, spec string) ([]byte, error) {
+	return datastore.Get(DerivativeKey(hash, spec))
+}
+
+// PutDerivative caches derivative spec of photo hash in datastore
+// derivatives beyond photo.derivatives.max (default 64) per photo
+// are not cached (derivatives put concurrently may exceed it)
+func PutDerivative(hash, spec string, b []byte) error {
+	count := 0
+	it := datastore.List(DerivativeKey(hash, ""))
+	for it.Next() {
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if count >= config.GetIntDefault("photo.derivatives.max", 64) {
+		return nil
+	}
+	return datastore.Put(DerivativeKey(hash, spec), b)
+}
+
+// deleteDerivatives deletes cached derivatives of photo hash
+// (they are listed from datastore)
+func deleteDerivatives(hash string) error {
+	it := datastore.List(DerivativeKey(hash, ""))
+	for it.Next() {
+		err := datastore.Delete(it.Entry().Key)
+		if err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,71 @@
+package photo
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/peerpx/peerpx/pkg/exif"
+	"github.com/peerpx/peerpx/services/config"
+)
+
+// SetExif fills properties of p left empty with EXIF metadata e
+// and keeps raw EXIF tags
+func (p *Photo) SetExif(e *exif.Exif) error {
+	if p.Camera == "" {
+		p.Camera = e.Camera
+	}
+	if p.Lens == "" {
+		p.Lens = e.Lens
+	}
+	if p.FocalLength == 0 {
+		p.FocalLength = e.FocalLength
+	}
+	if p.Iso == 0 {
+		p.Iso = e.Iso
+	}
+	if p.ShutterSpeed == "" {
+		p.ShutterSpeed = e.ShutterSpeed
+	}
+	if p.Aperture == 0 {
+		p.Aperture = e.Aperture
+	}
+	if p.TakenAt.IsZero() {
+		p.TakenAt = e.TakenAt
+	}
+	if e.HasLocation && p.Latitude == 0 && p.Longitude == 0 {
+		p.Latitude = float32(e.Latitude)
+		p.Longitude = float32(e.Longitude)
+	}
+
+	b, err := json.Marshal(e.Tags)
+	if err != nil {
+		return err
+	}
+	p.Exif.String = string(b)
+	p.Exif.Valid = true
+	return nil
+}
+
+// ExifTags returns raw EXIF tags of p (empty if p has no EXIF)
+func (p *Photo) ExifTags() (map[string]string, error) {
+	tags := make(map[string]string)
+	if !p.Exif.Valid || p.Exif.String == "" {
+		return tags, nil
+	}
+	err := json.Unmarshal([]byte(p.Exif.String), &tags)
+	return tags, err
+}
+
+// CoarseLocation rounds latitude and longitude of p to a coarse grid
+// config: photo.locationGrid grid step in degrees (default 0.1, ~11km)
+func (p *Photo) CoarseLocation() {
+	grid := config.GetFloat64Default("photo.locationGrid", 0.1)
+	if grid <= 0 {
+		return
+	}
+	round := func(v float32) float32 {
+		return float32(math.Floor(float64(v)/grid+0.5) * grid)
+	}
+	p.Latitude = round(p.Latitude)
+	p.Longitude = round(p.Longitude)
+}
@@ -0,0 +1,99 @@
+package photo
+
+import (
+	"strings"
+	"time"
+
+	"github.com/peerpx/peerpx/services/datastore"
+	"github.com/peerpx/peerpx/services/db"
+)
+
+// GCReport is the report of a datastore garbage collection (see GC)
+type GCReport struct {
+	Keys     int      `json:"keys"`     // number of keys in datastore
+	Orphans  []string `json:"orphans"`  // keys of no photo
+	Recent   int      `json:"recent"`   // keys of no photo younger than grace period (kept)
+	Dangling []string `json:"dangling"` // hashes of photos missing in datastore
+	Repaired bool     `json:"repaired"` // orphans and dangling photos were deleted
+}
+
+// GC reconciles datastore keys (variants included) with photos table
+//   - orphans are keys of no photo: uploads whose photo was never created,
+//     photos deleted from DB but not from datastore...
+//   - dangling photos are photos whose full size photo is not in datastore
+//     (deletions which failed once their files were deleted, see DeleteByHash)
+//
+// uploads are stored before their photo is created: keys younger than grace
+// are not orphans
+// with repair, orphans are deleted from datastore and dangling photos
+// are deleted
+func GC(grace time.Duration, repair bool) (*GCReport, error) {
+	// photos are loaded before keys are listed: a photo created meanwhile
+	// has recent keys
+	photos := []Photo{}
+	if err := db.Select(&photos, "SELECT hash, original_hash FROM photos"); err != nil {
+		return nil, err
+	}
+	owners := make(map[string]bool, 2*len(photos))
+	for _, p := range photos {
+		owners[p.Hash] = true
+		if p.OriginalHash != "" {
+			owners[p.OriginalHash] = true
+		}
+	}
+
+	report := &GCReport{Orphans: []string{}, Dangling: []string{}, Repaired: repair}
+	stored := make(map[string]bool, len(photos))
+	it := datastore.List("")
+	for it.Next() {
+		entry := it.Entry()
+		report.Keys++
+		owner := ownerHash(entry.Key)
+		if owners[owner] {
+			if entry.Key == owner {
+				stored[owner] = true
+			}
+			continue
+		}
+		if time.Since(entry.ModTime) < grace {
+			report.Recent++
+			continue
+		}
+		report.Orphans = append(report.Orphans, entry.Key)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range photos {
+		if !stored[p.Hash] {
+			report.Dangling = append(report.Dangling, p.Hash)
+		}
+	}
+	if !repair {
+		return report, nil
+	}
+
+	for _, key := range report.Orphans {
+		if err := datastore.Delete(key); err != nil && err != datastore.ErrNotFound {
+			return report, err
+		}
+	}
+	for i := range photos {
+		if stored[photos[i].Hash] {
+			continue
+		}
+		if err := photos[i].Delete(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// ownerHash returns the hash of the photo key belongs to
+// (keys of a photo are its hash, optionally followed by _ or .)
+func ownerHash(key string) string {
+	if i := strings.IndexAny(key, "_."); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
@@ -1,9 +1,13 @@
 package photo
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/datastore"
 	"github.com/peerpx/peerpx/services/db"
 )
@@ -14,40 +18,71 @@ type Category uint8
 // Tag temp
 type Tag string
 
-// Licence temp
+// Licence is the licence of a photo
 type Licence uint8
 
+// Licences
+const (
+	LicenceAllRightsReserved Licence = iota
+	LicenceCC0
+	LicenceCCBY
+	LicenceCCBYSA
+	LicenceCCBYND
+	LicenceCCBYNC
+	LicenceCCBYNCSA
+	LicenceCCBYNCND
+)
+
+// licenceURLs are the canonical URLs of licences
+var licenceURLs = map[Licence]string{
+	LicenceCC0:      "https://creativecommons.org/publicdomain/zero/1.0/",
+	LicenceCCBY:     "https://creativecommons.org/licenses/by/4.0/",
+	LicenceCCBYSA:   "https://creativecommons.org/licenses/by-sa/4.0/",
+	LicenceCCBYND:   "https://creativecommons.org/licenses/by-nd/4.0/",
+	LicenceCCBYNC:   "https://creativecommons.org/licenses/by-nc/4.0/",
+	LicenceCCBYNCSA: "https://creativecommons.org/licenses/by-nc-sa/4.0/",
+	LicenceCCBYNCND: "https://creativecommons.org/licenses/by-nc-nd/4.0/",
+}
+
+// URL returns the URL of licence l ("" for all rights reserved)
+func (l Licence) URL() string {
+	return licenceURLs[l]
+}
+
 // Comment temp
 type Comment struct {
 }
 
 // Photo represents a Photo
 type Photo struct {
-	ID           uint      `json:"id"`
-	UserID       uint      `db:"user_id" json:"user_id"`
-	Hash         string    `json:"hash"`
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	Camera       string    `json:"camera"`
-	Lens         string    `json:"lens"`
-	FocalLength  uint16    `db:"focal_length" json:"focal_length"`
-	Iso          uint16    `json:"iso"`
-	ShutterSpeed string    `db:"shutter_speed" json:"shutter_speed"` // or float ? "1/250" vs 0.004
-	Aperture     float32   `json:"aperture"`                         // 5.6, 32, 1.4
-	TimeViewed   uint64    `db:"time_viewed" json:"time_viewed"`
-	Rating       float32   `json:"rating"`
-	Category     Category  `json:"category"`
-	Location     string    `json:"location"`
-	Privacy      bool      `json:"privacy"` // true if private
-	Latitude     float32   `json:"latitude"`
-	Longitude    float32   `json:"longitude"`
-	AddedAt      time.Time `db:"added_at" json:"added_at"`
-	TakenAt      time.Time `db:"taken_at" json:"taken_at"`
-	Width        uint32    `json:"width"`
-	Height       uint32    `json:"height"`
-	Nsfw         bool      `json:"nsfw"`
-	LicenceType  Licence   `db:"licence_type" json:"licence_type"`
-	URL          string    `json:"url"`
+	ID           uint           `json:"id"`
+	UserID       uint           `db:"user_id" json:"user_id"`
+	Hash         string         `json:"hash"`
+	Name         string         `json:"name"`
+	Description  string         `json:"description"`
+	Camera       string         `json:"camera"`
+	Lens         string         `json:"lens"`
+	FocalLength  uint16         `db:"focal_length" json:"focal_length"`
+	Iso          uint16         `json:"iso"`
+	ShutterSpeed string         `db:"shutter_speed" json:"shutter_speed"` // or float ? "1/250" vs 0.004
+	Aperture     float32        `json:"aperture"`                         // 5.6, 32, 1.4
+	TimeViewed   uint64         `db:"time_viewed" json:"time_viewed"`
+	Rating       float32        `json:"rating"`
+	Category     Category       `json:"category"`
+	Location     string         `json:"location"`
+	Privacy      bool           `json:"privacy"` // true if private
+	Latitude     float32        `json:"latitude"`
+	Longitude    float32        `json:"longitude"`
+	AddedAt      time.Time      `db:"added_at" json:"added_at"`
+	TakenAt      time.Time      `db:"taken_at" json:"taken_at"`
+	Width        uint32         `json:"width"`
+	Height       uint32         `json:"height"`
+	Nsfw         bool           `json:"nsfw"`
+	LicenceType  Licence        `db:"licence_type" json:"licence_type"`
+	URL          string         `json:"url"`
+	Exif         sql.NullString `json:"-"`                                // raw EXIF tags (JSON)
+	OriginalHash string         `db:"original_hash" json:"original_hash"` // hash of the untouched upload
+	OriginalType string         `db:"original_type" json:"original_type"` // content type of the untouched upload
 }
 
 // GetByHash return photo from its hash
@@ -59,37 +94,158 @@ func GetByHash(hash string) (photo *Photo, err error) {
 	return
 }
 
-// DeleteByHash delete photo from DB and datastore
+// DeleteByHash delete photo from datastore then from DB
+// (if datastore fails, photo remains and can be deleted again, if DB fails,
+// photo is dangling and deleted by GC)
+// full size photo is kept if it is the original of another photo
 // we don't care if photo is not found
 func DeleteByHash(hash string) error {
-	stmt, err := db.Preparex("DELETE FROM photos WHERE hash = ?")
+	shared, err := sharedBlob(hash, hash)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(hash)
-	if err != nil {
+	// full size and variants (in all delivery formats)
+	for _, s := range Sizes {
+		for _, format := range DeliveryFormats {
+			key := FormatKey(s.Key(hash), format)
+			if shared && key == hash {
+				continue
+			}
+			err := datastore.Delete(key)
+			if err != nil && err != datastore.ErrNotFound {
+				return err
+			}
+		}
+	}
+	// cached derivatives
+	if err := deleteDerivatives(hash); err != nil {
 		return err
 	}
-	err = datastore.Delete(hash)
-	if err != nil && err != datastore.ErrNotFound {
+	// deep zoom tiles
+	if err := deleteTiles(hash); err != nil {
 		return err
 	}
-	return nil
+
+	stmt, err := db.Preparex("DELETE FROM photos WHERE hash = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(hash)
+	return err
+}
+
+// Delete delete photo p from datastore (original included) and DB
+// (see DeleteByHash)
+// original is kept if another photo uses it, we don't care if it is not found
+func (p *Photo) Delete() error {
+	if p.OriginalHash != "" && p.OriginalHash != p.Hash {
+		shared, err := sharedBlob(p.OriginalHash, p.Hash)
+		if err != nil {
+			return err
+		}
+		if !shared {
+			err = datastore.Delete(p.OriginalHash)
+			if err != nil && err != datastore.ErrNotFound {
+				return err
+			}
+		}
+	}
+	return DeleteByHash(p.Hash)
+}
+
+// sharedBlob returns true if datastore key is the full size photo or the
+// original of a photo other than photo hash
+// (keys are content hashes: photos can share them)
+func sharedBlob(key, hash string) (bool, error) {
+	var count int
+	err := db.Get(&count, "SELECT COUNT(*) FROM photos WHERE (hash = ? OR original_hash = ?) AND hash != ?", key, key, hash)
+	return count != 0, err
+}
+
+// OriginalIsPublic returns true if the original upload of p can be
+// shared (public photo with a Creative Commons licence)
+// as it is not filtered, owner metadata policy must keep all metadata too
+func (p *Photo) OriginalIsPublic() bool {
+	return !p.Privacy && p.LicenceType != LicenceAllRightsReserved
+}
+
+// ListArgs are optional filters used by List
+type ListArgs struct {
+	UserID     uint // only photos of user UserID (0: all users)
+	MaxID      uint // only photos with id < MaxID (0: no limit)
+	Limit      int  // max number of photos returned (0: no limit)
+	PublicOnly bool // exclude private photos
 }
 
 // List list photos regarding optional args
-func List(args ...interface{}) (photos []Photo, err error) {
-	err = db.Select(&photos, "SELECT * FROM photos ORDER BY id DESC")
+func List(args ...ListArgs) (photos []Photo, err error) {
+	where, params := listWhere(args...)
+	query := "SELECT * FROM photos" + where + " ORDER BY id DESC"
+	if len(args) != 0 && args[0].Limit > 0 {
+		query += " LIMIT ?"
+		params = append(params, args[0].Limit)
+	}
+	err = db.Select(&photos, query, params...)
+	return
+}
+
+// Count returns the number of photos regarding optional args
+// (MaxID and Limit are ignored)
+func Count(args ...ListArgs) (count int, err error) {
+	if len(args) != 0 {
+		a := args[0]
+		a.MaxID = 0
+		args = []ListArgs{a}
+	}
+	where, params := listWhere(args...)
+	err = db.Get(&count, "SELECT COUNT(*) FROM photos"+where, params...)
+	return
+}
+
+// CountUploadersSince returns the number of users who added a photo since t
+func CountUploadersSince(t time.Time) (count int, err error) {
+	err = db.Get(&count, "SELECT COUNT(DISTINCT user_id) FROM photos WHERE added_at >= ?", t)
 	return
 }
 
+// listWhere returns WHERE clause and its params regarding args
+func listWhere(args ...ListArgs) (string, []interface{}) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	var conditions []string
+	var params []interface{}
+	if args[0].UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		params = append(params, args[0].UserID)
+	}
+	if args[0].MaxID != 0 {
+		conditions = append(conditions, "id < ?")
+		params = append(params, args[0].MaxID)
+	}
+	if args[0].PublicOnly {
+		conditions = append(conditions, "privacy = ?")
+		params = append(params, false)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), params
+}
+
+// IRI returns the ActivityPub ID of the photo
+func (p *Photo) IRI() string {
+	return fmt.Sprintf("https://%s/photos/%s", config.GetString("hostname"), p.Hash)
+}
+
 // Create save new photo in DB
 func (p *Photo) Create() error {
-	stmt, err := db.Preparex("INSERT INTO photos (added_at, hash, name, description, camera,lens,focal_length,iso, shutter_speed, aperture, time_viewed, rating, category, location, privacy, latitude, longitude, taken_at, width, height, nsfw, licence_type, url, taken_at, user_id) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	stmt, err := db.Preparex("INSERT INTO photos (added_at, hash, name, description, camera,lens,focal_length,iso, shutter_speed, aperture, time_viewed, rating, category, location, privacy, latitude, longitude, taken_at, width, height, nsfw, licence_type, url, taken_at, user_id, exif, original_hash, original_type) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
-	res, err := stmt.Exec(time.Now(), p.Hash, p.Name, p.Description, p.Camera, p.Lens, p.FocalLength, p.Iso, p.ShutterSpeed, p.Aperture, p.TimeViewed, p.Rating, p.Category, p.Location, p.Privacy, p.Latitude, p.Longitude, p.TakenAt, p.Width, p.Height, p.Nsfw, p.LicenceType, p.URL, p.TakenAt, p.UserID)
+	p.AddedAt = time.Now()
+	res, err := stmt.Exec(p.AddedAt, p.Hash, p.Name, p.Description, p.Camera, p.Lens, p.FocalLength, p.Iso, p.ShutterSpeed, p.Aperture, p.TimeViewed, p.Rating, p.Category, p.Location, p.Privacy, p.Latitude, p.Longitude, p.TakenAt, p.Width, p.Height, p.Nsfw, p.LicenceType, p.URL, p.TakenAt, p.UserID, p.Exif.String, p.OriginalHash, p.OriginalType)
 	if err != nil {
 		return err
 	}
@@ -106,11 +262,11 @@ func (p *Photo) Update() error {
 	if p.ID == 0 {
 		return errors.New("photo is not recorded in DB yet, i can't update it")
 	}
-	stmt, err := db.Preparex("UPDATE photos SET added_at=?, hash=?, name=?, description=?, camera=?, lens=?, focal_length=?, iso=?, shutter_speed=?, aperture=?, time_viewed=?, rating=?, category=?, location=?, privacy=?, latitude=?, longitude=?, taken_at=?, width=?, height=?, nsfw=?, licence_type=?, url=?, taken_at=? WHERE id=?")
+	stmt, err := db.Preparex("UPDATE photos SET added_at=?, hash=?, name=?, description=?, camera=?, lens=?, focal_length=?, iso=?, shutter_speed=?, aperture=?, time_viewed=?, rating=?, category=?, location=?, privacy=?, latitude=?, longitude=?, taken_at=?, width=?, height=?, nsfw=?, licence_type=?, url=?, taken_at=?, exif=?, original_hash=?, original_type=? WHERE id=?")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(time.Now(), p.Hash, p.Name, p.Description, p.Camera, p.Lens, p.FocalLength, p.Iso, p.ShutterSpeed, p.Aperture, p.TimeViewed, p.Rating, p.Category, p.Location, p.Privacy, p.Latitude, p.Longitude, p.TakenAt, p.Width, p.Height, p.Nsfw, p.LicenceType, p.URL, p.TakenAt, p.ID)
+	_, err = stmt.Exec(time.Now(), p.Hash, p.Name, p.Description, p.Camera, p.Lens, p.FocalLength, p.Iso, p.ShutterSpeed, p.Aperture, p.TimeViewed, p.Rating, p.Category, p.Location, p.Privacy, p.Latitude, p.Longitude, p.TakenAt, p.Width, p.Height, p.Nsfw, p.LicenceType, p.URL, p.TakenAt, p.Exif.String, p.OriginalHash, p.OriginalType, p.ID)
 	return err
 }
 
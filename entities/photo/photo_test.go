@@ -7,8 +7,18 @@ import (
 
 	"errors"
 
+	"bytes"
 	"database/sql"
+	imageStd "image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/peerpx/peerpx/pkg/exif"
+	"github.com/peerpx/peerpx/pkg/image"
+	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/datastore"
 	"github.com/peerpx/peerpx/services/db"
 	"github.com/stretchr/testify/assert"
@@ -29,51 +39,227 @@ func TestGetByHash(t *testing.T) {
 	}
 }
 
+// expectSharedBlob expects the shared blob check of a deletion
+func expectSharedBlob(count int) {
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
 func TestDeleteByHash(t *testing.T) {
+	// error on datastore delete: photo is kept in DB
+	if err := datastore.InitMokedDatastore(nil, errors.New("mocked")); err != nil {
+		panic(err)
+	}
+	expectSharedBlob(0)
+	err := DeleteByHash("foo")
+	assert.EqualError(t, err, "mocked")
+
+	//not found in data store must returns nil error
+	if err = datastore.InitMokedDatastore(nil, datastore.ErrNotFound); err != nil {
+		panic(err)
+	}
+	expectSharedBlob(0)
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	err = DeleteByHash("foo")
+	assert.NoError(t, err)
+
+	// shared blob check failed
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WillReturnError(errors.New("mocked"))
+	err = DeleteByHash("foo")
+	assert.EqualError(t, err, "mocked")
+
 	// prepare failed
+	if err = datastore.InitMokedDatastore(nil, nil); err != nil {
+		panic(err)
+	}
+	expectSharedBlob(0)
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").WillReturnError(errors.New("mocked error"))
-	err := DeleteByHash("foo")
+	err = DeleteByHash("foo")
 	assert.EqualError(t, err, "mocked error")
 
 	// not found
+	expectSharedBlob(0)
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnError(sql.ErrNoRows)
 	err = DeleteByHash("foo")
 	assert.EqualError(t, err, sql.ErrNoRows.Error())
 
-	// error on datastore delete
-	if err = datastore.InitMokedDatastore(nil, errors.New("mocked")); err != nil {
-		panic(err)
-	}
+	// OK
+	expectSharedBlob(0)
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
 	err = DeleteByHash("foo")
-	assert.EqualError(t, err, "mocked")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
 
-	//not found in data store must returns nil error
-	if err = datastore.InitMokedDatastore(nil, datastore.ErrNotFound); err != nil {
+func TestPhoto_Delete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
 		panic(err)
 	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	for _, key := range []string{"hash", "hash_xs", "original"} {
+		if err = datastore.Put(key, []byte{1}); err != nil {
+			panic(err)
+		}
+	}
+	expectSharedBlob(0)
+	expectSharedBlob(0)
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
-	err = DeleteByHash("foo")
-	assert.NoError(t, err)
+	p := &Photo{Hash: "hash", OriginalHash: "original"}
+	if assert.NoError(t, p.Delete()) {
+		for _, key := range []string{"hash", "hash_xs", "original"} {
+			exists, _ := datastore.Exists(key)
+			assert.False(t, exists)
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
 
-	// OK
-	if err = datastore.InitMokedDatastore(nil, nil); err != nil {
+func TestPhoto_Delete_shared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
 		panic(err)
 	}
+	for _, key := range []string{"hash", "hash_xs", "original"} {
+		if err = datastore.Put(key, []byte{1}); err != nil {
+			panic(err)
+		}
+	}
+
+	// original is used by another photo, full size photo is the original
+	// of another one: both are kept
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WithArgs("original", "original", "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	db.Mock.ExpectQuery("^SELECT COUNT(.*) FROM photos").WithArgs("hash", "hash", "hash").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
 		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
-	err = DeleteByHash("foo")
+	p := &Photo{Hash: "hash", OriginalHash: "original"}
+	if assert.NoError(t, p.Delete()) {
+		for key, kept := range map[string]bool{"hash": true, "hash_xs": false, "original": true} {
+			exists, _ := datastore.Exists(key)
+			assert.Equal(t, kept, exists, key)
+		}
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPutDerivative(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("photo.derivatives.max", "2")
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	for _, spec := range []string{"full_max_0_default.jpg", "full_320,_0_default.jpg", "full_640,_0_default.jpg"} {
+		assert.NoError(t, PutDerivative("hash", spec, []byte{1}))
+	}
+	_, err = GetDerivative("hash", "full_320,_0_default.jpg")
 	assert.NoError(t, err)
+	// beyond max
+	_, err = GetDerivative("hash", "full_640,_0_default.jpg")
+	assert.Equal(t, datastore.ErrNotFound, err)
+
+	// derivatives are deleted with their photo
+	expectSharedBlob(0)
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, DeleteByHash("hash"))
+	it := datastore.List("hash")
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	for _, key := range []string{"live", "live_m", "live.png", "original", "gone", "gone_m", "recent"} {
+		if err = datastore.Put(key, []byte{1}); err != nil {
+			panic(err)
+		}
+	}
+	// keys of deleted photo are old
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{filepath.Join(dir, "gone"), filepath.Join(dir, "go", "ne", "gone_m")} {
+		if err = os.Chtimes(path, old, old); err != nil {
+			panic(err)
+		}
+	}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"hash", "original_hash"}).
+			AddRow("live", "original").
+			AddRow("dangling", "")
+	}
+
+	// dry run
+	db.Mock.ExpectQuery("^SELECT hash, original_hash FROM photos").WillReturnRows(rows())
+	report, err := GC(24*time.Hour, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 7, report.Keys)
+		assert.Equal(t, []string{"gone", "gone_m"}, report.Orphans)
+		assert.Equal(t, 1, report.Recent)
+		assert.Equal(t, []string{"dangling"}, report.Dangling)
+		assert.False(t, report.Repaired)
+	}
+	exists, _ := datastore.Exists("gone")
+	assert.True(t, exists)
 
+	// repair
+	db.Mock.ExpectQuery("^SELECT hash, original_hash FROM photos").WillReturnRows(rows())
+	expectSharedBlob(0)
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WithArgs("dangling").WillReturnResult(sqlmock.NewResult(1, 1))
+	report, err = GC(24*time.Hour, true)
+	if assert.NoError(t, err) {
+		assert.True(t, report.Repaired)
+		for _, key := range []string{"gone", "gone_m"} {
+			exists, _ := datastore.Exists(key)
+			assert.False(t, exists)
+		}
+		for _, key := range []string{"live", "live_m", "live.png", "original", "recent"} {
+			exists, _ := datastore.Exists(key)
+			assert.True(t, exists)
+		}
+	}
+
+	// DB error
+	db.Mock.ExpectQuery("^SELECT hash, original_hash FROM photos").WillReturnError(errors.New("mocked"))
+	_, err = GC(24*time.Hour, false)
+	assert.Error(t, err)
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestPhoto_OriginalIsPublic(t *testing.T) {
+	assert.False(t, (&Photo{}).OriginalIsPublic())
+	assert.False(t, (&Photo{LicenceType: LicenceCCBY, Privacy: true}).OriginalIsPublic())
+	assert.True(t, (&Photo{LicenceType: LicenceCCBY}).OriginalIsPublic())
 }
 
 func TestList(t *testing.T) {
 	row := sqlmock.NewRows([]string{"id", "hash"}).AddRow(1, "mocked").AddRow(2, "mocked2")
 	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
-	photos, err := List("foo", "bar")
+	photos, err := List()
 	if assert.NoError(t, err) {
 		assert.Equal(t, 2, len(photos))
 		assert.Equal(t, uint(1), photos[0].ID)
@@ -81,6 +267,26 @@ func TestList(t *testing.T) {
 		assert.Equal(t, uint(2), photos[1].ID)
 		assert.Equal(t, "mocked2", photos[1].Hash)
 	}
+
+	// with args
+	row = sqlmock.NewRows([]string{"id", "hash"}).AddRow(1, "mocked")
+	db.Mock.ExpectQuery("^SELECT \\* FROM photos WHERE user_id = \\? AND id < \\? AND privacy = \\? ORDER BY id DESC LIMIT \\?").
+		WithArgs(2, 10, false, 1).
+		WillReturnRows(row)
+	photos, err = List(ListArgs{UserID: 2, MaxID: 10, Limit: 1, PublicOnly: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(photos))
+	}
+}
+
+func TestCount(t *testing.T) {
+	db.Mock.ExpectQuery("^SELECT COUNT\\(\\*\\) FROM photos WHERE user_id = \\?$").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+	count, err := Count(ListArgs{UserID: 2, MaxID: 10})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 12, count)
+	}
 }
 
 func TestPhoto_Create(t *testing.T) {
@@ -186,3 +392,282 @@ func TestPhoto_Validate(t *testing.T) {
 	assert.Equal(t, uint8(8), photo.Validate())
 	photo.TakenAt = time.Now().Add(-10 * time.Hour)
 }
+
+func TestSize_Dimensions(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	p := &Photo{Width: 3000, Height: 2000}
+	s, ok := GetSize("m")
+	if assert.True(t, ok) {
+		width, height := s.Dimensions(p)
+		assert.Equal(t, uint32(1024), width)
+		assert.Equal(t, uint32(682), height)
+	}
+	// portrait
+	p = &Photo{Width: 2000, Height: 3000}
+	width, height := s.Dimensions(p)
+	assert.Equal(t, uint32(682), width)
+	assert.Equal(t, uint32(1024), height)
+
+	// no upscale
+	p = &Photo{Width: 800, Height: 600}
+	width, height = s.Dimensions(p)
+	assert.Equal(t, uint32(800), width)
+	assert.Equal(t, uint32(600), height)
+	assert.Equal(t, 3, len(p.AvailableSizes()))
+
+	_, ok = GetSize("xxl")
+	assert.False(t, ok)
+
+	// configured size
+	config.Set("photo.size.m", "400")
+	width, height = s.Dimensions(p)
+	assert.Equal(t, uint32(400), width)
+	assert.Equal(t, uint32(300), height)
+	assert.Equal(t, "hash_m", s.Key("hash"))
+	max, _ := GetSize(SizeMax)
+	assert.Equal(t, "hash", max.Key("hash"))
+}
+
+func TestLicence_URL(t *testing.T) {
+	assert.Equal(t, "", LicenceAllRightsReserved.URL())
+	assert.Equal(t, "https://creativecommons.org/licenses/by-sa/4.0/", LicenceCCBYSA.URL())
+}
+
+func TestGenerateSizes(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	original, err := ioutil.ReadFile("../../etc/samples/photos/robin.jpg")
+	if err != nil {
+		panic(err)
+	}
+	img, err := image.NewFromBytes(original)
+	if err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", original); err != nil {
+		panic(err)
+	}
+	metadata, err := exif.Metadata(original, exif.PolicyKeepAll, LicenceCCBY.URL())
+	if err != nil {
+		panic(err)
+	}
+	xs, _ := GetSize("xs")
+	if assert.NoError(t, GenerateSizes("hash", img, metadata)) {
+		exists, _ := datastore.Exists(xs.Key("hash"))
+		assert.True(t, exists)
+		// with metadata
+		b, _ := datastore.Get(xs.Key("hash"))
+		e, err := exif.Parse(b)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "SONY ILCE-7M2", e.Camera)
+		}
+		// original is not altered
+		assert.Equal(t, img.Width(), img.Copy().Width())
+	}
+
+	// lazy regeneration
+	assert.NoError(t, datastore.Delete(xs.Key("hash")))
+	b, err := GetSizeData("hash", xs)
+	if assert.NoError(t, err) {
+		variant, err := image.NewFromBytes(b)
+		if assert.NoError(t, err) {
+			assert.True(t, variant.Width() <= 320 && variant.Height() <= 320)
+		}
+		exists, _ := datastore.Exists(xs.Key("hash"))
+		assert.True(t, exists)
+	}
+
+	// unknown photo
+	_, err = GetSizeData("nohash", xs)
+	assert.Equal(t, datastore.ErrNotFound, err)
+}
+
+func TestFormatKey(t *testing.T) {
+	assert.Equal(t, "hash_xs", FormatKey("hash_xs", image.FormatJPEG))
+	assert.Equal(t, "hash.png", FormatKey("hash", image.FormatPNG))
+}
+
+func TestGetSizeFormatData(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	original, err := ioutil.ReadFile("../../etc/samples/photos/robin.jpg")
+	if err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", original); err != nil {
+		panic(err)
+	}
+	xs, _ := GetSize("xs")
+
+	// JPEG
+	b, err := GetSizeFormatData("hash", xs, image.FormatJPEG)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.FormatJPEG, image.DetectFormat(b))
+	}
+
+	// PNG is encoded then stored under its own key
+	b, err = GetSizeFormatData("hash", xs, image.FormatPNG)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.FormatPNG, image.DetectFormat(b))
+		stored, err := datastore.Get(xs.Key("hash") + ".png")
+		if assert.NoError(t, err) {
+			assert.Equal(t, b, stored)
+		}
+	}
+
+	// key
+	key, err := GetSizeFormatKey("hash", xs, image.FormatPNG)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hash_xs.png", key)
+	}
+
+	// deleted with the photo
+	expectSharedBlob(0)
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, DeleteByHash("hash")) {
+		exists, _ := datastore.Exists(xs.Key("hash") + ".png")
+		assert.False(t, exists)
+	}
+
+	// unknown photo
+	_, err = GetSizeFormatData("nohash", xs, image.FormatPNG)
+	assert.Equal(t, datastore.ErrNotFound, err)
+	_, err = GetSizeFormatKey("nohash", xs, image.FormatJPEG)
+	assert.Equal(t, datastore.ErrNotFound, err)
+}
+
+func TestPyramid(t *testing.T) {
+	p := NewPyramid(1000, 600)
+	assert.Equal(t, 10, p.MaxLevel())
+	w, h := p.LevelSize(10)
+	assert.Equal(t, []int{1000, 600}, []int{w, h})
+	w, h = p.LevelSize(9)
+	assert.Equal(t, []int{500, 300}, []int{w, h})
+	w, h = p.LevelSize(0)
+	assert.Equal(t, []int{1, 1}, []int{w, h})
+	cols, rows := p.LevelTiles(10)
+	assert.Equal(t, []int{4, 3}, []int{cols, rows})
+	assert.Equal(t, []int{1, 2, 4}, p.ScaleFactors())
+
+	// IIIF tiles
+	level, col, row, ok := p.TileAt(imageStd.Rect(768, 512, 1000, 600), 232, 88)
+	assert.True(t, ok)
+	assert.Equal(t, []int{10, 3, 2}, []int{level, col, row})
+	level, col, row, ok = p.TileAt(imageStd.Rect(512, 0, 1000, 512), 244, 256)
+	assert.True(t, ok)
+	assert.Equal(t, []int{9, 1, 0}, []int{level, col, row})
+	_, _, _, ok = p.TileAt(imageStd.Rect(0, 0, 1000, 600), 250, 150)
+	assert.True(t, ok)
+	_, _, _, ok = p.TileAt(imageStd.Rect(10, 0, 266, 256), 256, 256)
+	assert.False(t, ok)
+	_, _, _, ok = p.TileAt(imageStd.Rect(0, 0, 256, 256), 128, 128)
+	assert.False(t, ok)
+
+	b, err := p.DZI()
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(b), `<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="jpg" Overlap="0" TileSize="256"><Size Width="1000" Height="600"></Size></Image>`)
+	}
+}
+
+func TestGenerateTiles(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	assert.False(t, NeedsTiles(2048, 1024))
+	assert.True(t, NeedsTiles(1024, 2049))
+
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = datastore.InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	src := imageStd.NewRGBA(imageStd.Rect(0, 0, 600, 300))
+	buf := new(bytes.Buffer)
+	if err = jpeg.Encode(buf, src, nil); err != nil {
+		panic(err)
+	}
+	if err = datastore.Put("hash", buf.Bytes()); err != nil {
+		panic(err)
+	}
+
+	_, err = GetPyramid("hash")
+	assert.Equal(t, datastore.ErrNotFound, err)
+	if assert.NoError(t, GenerateTiles("hash")) {
+		p, err := GetPyramid("hash")
+		if assert.NoError(t, err) {
+			assert.Equal(t, NewPyramid(600, 300), p)
+		}
+		// last tile of full size level
+		b, err := GetTileData("hash", 10, 2, 1)
+		if assert.NoError(t, err) {
+			tile, err := image.NewFromBytes(b)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []int{88, 44}, []int{tile.Width(), tile.Height()})
+			}
+		}
+		// 1x1
+		exists, _ := datastore.Exists(TileKey("hash", 0, 0, 0))
+		assert.True(t, exists)
+	}
+
+	// deleted with the photo
+	expectSharedBlob(0)
+	db.Mock.ExpectPrepare("^DELETE FROM photos (.*)").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	if assert.NoError(t, DeleteByHash("hash")) {
+		for _, key := range []string{"hash_tiles", TileKey("hash", 10, 2, 1), TileKey("hash", 0, 0, 0)} {
+			exists, _ := datastore.Exists(key)
+			assert.False(t, exists, key)
+		}
+	}
+}
+
+func TestPhoto_SetExif(t *testing.T) {
+	e := &exif.Exif{
+		Camera:       "SONY ILCE-7M2",
+		Lens:         "FE 35mm F2.8 ZA",
+		Iso:          400,
+		ShutterSpeed: "1/250",
+		TakenAt:      time.Date(2018, 6, 15, 12, 30, 0, 0, time.UTC),
+		HasLocation:  true,
+		Latitude:     48.8567,
+		Longitude:    2.35,
+		Tags:         map[string]string{"Model": "ILCE-7M2"},
+	}
+	// properties set by client are kept
+	p := &Photo{Lens: "mine"}
+	if assert.NoError(t, p.SetExif(e)) {
+		assert.Equal(t, "SONY ILCE-7M2", p.Camera)
+		assert.Equal(t, "mine", p.Lens)
+		assert.Equal(t, uint16(400), p.Iso)
+		assert.Equal(t, e.TakenAt, p.TakenAt)
+		assert.Equal(t, float32(48.8567), p.Latitude)
+	}
+
+	tags, err := p.ExifTags()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ILCE-7M2", tags["Model"])
+	}
+
+	// no EXIF
+	tags, err = new(Photo).ExifTags()
+	if assert.NoError(t, err) {
+		assert.Len(t, tags, 0)
+	}
+}
@@ -0,0 +1,239 @@
+package photo
+
+import (
+	"fmt"
+
+	"github.com/peerpx/peerpx/pkg/exif"
+	"github.com/peerpx/peerpx/pkg/image"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/datastore"
+)
+
+// Size is a size variant of a photo
+type Size struct {
+	Name       string
+	DefaultMax uint32 // default max length of the longest side (0: original size)
+}
+
+// Sizes are the size variants of a photo, from smallest to largest
+var Sizes = []Size{
+	{Name: "xs", DefaultMax: 320},
+	{Name: "s", DefaultMax: 640},
+	{Name: "m", DefaultMax: 1024},
+	{Name: "l", DefaultMax: 2048},
+	{Name: "xl", DefaultMax: 4096},
+	{Name: SizeMax, DefaultMax: 0},
+}
+
+// SizeMax is the name of the full size variant
+const SizeMax = "max"
+
+// DeliveryFormats are the formats photos are delivered in,
+// by order of preference (size variants are stored as JPEG, other formats
+// are encoded on demand)
+var DeliveryFormats = []string{image.FormatJPEG, image.FormatPNG}
+
+// GetSize returns size variant name
+func GetSize(name string) (Size, bool) {
+	for _, s := range Sizes {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Size{}, false
+}
+
+// Max returns the max length of the longest side of size s (0: original size)
+// config: photo.size.{name} (eg photo.size.m: 1200)
+func (s Size) Max() uint32 {
+	if s.Name == SizeMax {
+		return 0
+	}
+	return uint32(config.GetIntDefault("photo.size."+s.Name, int(s.DefaultMax)))
+}
+
+// Dimensions returns width and height of photo p in size s
+// photos are never upscaled
+func (s Size) Dimensions(p *Photo) (width, height uint32) {
+	return s.fit(p.Width, p.Height)
+}
+
+// fit returns width and height fitted in size s
+func (s Size) fit(width, height uint32) (uint32, uint32) {
+	max := s.Max()
+	if max == 0 || (width <= max && height <= max) {
+		return width, height
+	}
+	if width >= height {
+		return max, uint32(uint64(height) * uint64(max) / uint64(width))
+	}
+	return uint32(uint64(width) * uint64(max) / uint64(height)), max
+}
+
+// Key returns the datastore key of size s of photo hash
+// (hash for max, hash_{name} otherwise)
+func (s Size) Key(hash string) string {
+	if s.Name == SizeMax {
+		return hash
+	}
+	return hash + "_" + s.Name
+}
+
+// FormatKey returns the datastore key of photo key in delivery format
+// (key for JPEG, key.{format} otherwise)
+func FormatKey(key, format string) string {
+	if format == image.FormatJPEG {
+		return key
+	}
+	return key + "." + format
+}
+
+// deliveryQuality returns the encoding quality of delivery format
+// config: photo.quality.{format} (eg photo.quality.jpeg: 85)
+func deliveryQuality(format string) int {
+	return config.GetIntDefault("photo.quality."+format, 85)
+}
+
+// Encode returns img encoded in delivery format
+// (quality is set by photo.quality.{format})
+func Encode(img *image.Image, format string) ([]byte, error) {
+	return img.Encode(format, deliveryQuality(format))
+}
+
+// AvailableSizes returns size variants of photo p
+// variants larger than the photo are skipped (max is always returned)
+func (p *Photo) AvailableSizes() []Size {
+	sizes := make([]Size, 0, len(Sizes))
+	for _, s := range Sizes {
+		if s.Max() == 0 || p.Width > s.Max() || p.Height > s.Max() {
+			sizes = append(sizes, s)
+		}
+	}
+	return sizes
+}
+
+// SizeURL returns URL of photo p in size variant name
+func (p *Photo) SizeURL(name string) string {
+	return fmt.Sprintf("%s/api/v1/photo/%s/%s", baseURL(), p.Hash, name)
+}
+
+// IIIFURL returns the IIIF Image API base URI of photo p
+func (p *Photo) IIIFURL() string {
+	return fmt.Sprintf("%s/iiif/3/%s", baseURL(), p.Hash)
+}
+
+// baseURL returns the base URL of the instance
+func baseURL() string {
+	scheme := "http"
+	if config.GetBool("http.tlsEnabled") {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, config.GetString("hostname"))
+}
+
+// GenerateSizes generates size variants of photo hash from its full size image
+// and stores them in datastore with JPEG metadata segments (see exif.Metadata)
+func GenerateSizes(hash string, img *image.Image, metadata []byte) error {
+	for _, s := range Sizes {
+		if s.Max() == 0 || (img.Width() <= int(s.Max()) && img.Height() <= int(s.Max())) {
+			continue
+		}
+		if _, err := generateSize(hash, img, s, metadata); err != nil {
+			return fmt.Errorf("generate size %s of %s failed: %v", s.Name, hash, err)
+		}
+	}
+	return nil
+}
+
+// generateSize generates size s of photo hash from its full size image,
+// stores it in datastore and returns it
+func generateSize(hash string, img *image.Image, s Size, metadata []byte) ([]byte, error) {
+	width, height := s.fit(uint32(img.Width()), uint32(img.Height()))
+	variant := img.Copy()
+	if err := variant.ResizeToFit(int(width), int(height)); err != nil {
+		return nil, err
+	}
+	b, err := variant.JPEG(config.GetIntDefault("photo.sizeQuality", 90))
+	if err != nil {
+		return nil, err
+	}
+	b = exif.Insert(b, metadata)
+	if err = datastore.Put(s.Key(hash), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetSizeData returns size s of photo hash from datastore
+// missing variants are regenerated from the full size photo
+// (which is returned if it is smaller than s) with its metadata
+func GetSizeData(hash string, s Size) ([]byte, error) {
+	b, err := datastore.Get(s.Key(hash))
+	if err != datastore.ErrNotFound || s.Name == SizeMax {
+		return b, err
+	}
+
+	original, err := datastore.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	img, err := image.NewFromBytes(original)
+	if err != nil {
+		return nil, err
+	}
+	if img.Width() <= int(s.Max()) && img.Height() <= int(s.Max()) {
+		return original, nil
+	}
+	return generateSize(hash, img, s, exif.Segments(original))
+}
+
+// GetSizeFormatData returns size s of photo hash in delivery format
+// missing variants are encoded from the JPEG one (without metadata)
+// and stored in datastore under their own key (see FormatKey)
+func GetSizeFormatData(hash string, s Size, format string) ([]byte, error) {
+	if format == image.FormatJPEG {
+		return GetSizeData(hash, s)
+	}
+	key := FormatKey(s.Key(hash), format)
+	b, err := datastore.Get(key)
+	if err != datastore.ErrNotFound {
+		return b, err
+	}
+
+	jpeg, err := GetSizeData(hash, s)
+	if err != nil {
+		return nil, err
+	}
+	img, err := image.NewFromBytes(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = Encode(img, format); err != nil {
+		return nil, err
+	}
+	if err = datastore.Put(key, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetSizeFormatKey returns the datastore key of size s of photo hash in
+// delivery format (the full size photo one if photo is smaller than s)
+// missing variants are generated first (see GetSizeFormatData): request
+// handlers must call it on the processing pool if the key is missing
+func GetSizeFormatKey(hash string, s Size, format string) (string, error) {
+	key := FormatKey(s.Key(hash), format)
+	_, err := datastore.Stat(key)
+	if err != datastore.ErrNotFound {
+		return key, err
+	}
+	if _, err = GetSizeFormatData(hash, s, format); err != nil {
+		return "", err
+	}
+	if format == image.FormatJPEG {
+		if _, err = datastore.Stat(key); err == datastore.ErrNotFound {
+			return hash, nil
+		}
+	}
+	return key, err
+}
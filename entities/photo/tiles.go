@@ -0,0 +1,241 @@
+package photo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	imageStd "image"
+
+	"github.com/peerpx/peerpx/pkg/image"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/datastore"
+)
+
+// TileSize is the size (in pixels) of pyramid tiles
+const TileSize = 256
+
+// Pyramid is the deep zoom tile pyramid of a photo
+// levels follow Deep Zoom (DZI): MaxLevel is the full size photo and each
+// level is half the size of the next one, down to 1x1 (level 0)
+// tiles of level l are also the IIIF tiles of scale factor 2^(MaxLevel-l)
+type Pyramid struct {
+	Width    int `json:"width"`
+	Height   int `json:"height"`
+	TileSize int `json:"tile_size"`
+}
+
+// NewPyramid returns the pyramid of a width x height photo
+func NewPyramid(width, height int) *Pyramid {
+	return &Pyramid{Width: width, Height: height, TileSize: TileSize}
+}
+
+// MaxLevel returns the level of the full size photo
+func (p *Pyramid) MaxLevel() int {
+	level := 0
+	for side := maxInt(p.Width, p.Height); side > 1; side = (side + 1) / 2 {
+		level++
+	}
+	return level
+}
+
+// LevelSize returns width and height of level
+func (p *Pyramid) LevelSize(level int) (int, int) {
+	scale := 1 << uint(p.MaxLevel()-level)
+	return (p.Width + scale - 1) / scale, (p.Height + scale - 1) / scale
+}
+
+// LevelTiles returns the number of columns and rows of tiles of level
+func (p *Pyramid) LevelTiles(level int) (int, int) {
+	width, height := p.LevelSize(level)
+	return (width + p.TileSize - 1) / p.TileSize, (height + p.TileSize - 1) / p.TileSize
+}
+
+// ScaleFactors returns the IIIF scale factors of the pyramid levels
+// (levels smaller than a tile are skipped: their tile is the whole photo)
+func (p *Pyramid) ScaleFactors() []int {
+	factors := []int{}
+	for level := p.MaxLevel(); level >= 0; level-- {
+		factors = append(factors, 1<<uint(p.MaxLevel()-level))
+		if cols, rows := p.LevelTiles(level); cols == 1 && rows == 1 {
+			break
+		}
+	}
+	return factors
+}
+
+// TileAt returns the tile whose region (in full size coordinates) is region
+// and whose size is width x height (height may be off by one as IIIF
+// clients may compute it from width)
+func (p *Pyramid) TileAt(region imageStd.Rectangle, width, height int) (level, col, row int, ok bool) {
+	for _, scale := range p.ScaleFactors() {
+		side := p.TileSize * scale
+		if region.Min.X%side != 0 || region.Min.Y%side != 0 {
+			continue
+		}
+		col, row = region.Min.X/side, region.Min.Y/side
+		expected := imageStd.Rect(col*side, row*side, (col+1)*side, (row+1)*side).Intersect(imageStd.Rect(0, 0, p.Width, p.Height))
+		if region != expected {
+			continue
+		}
+		tileWidth, tileHeight := (region.Dx()+scale-1)/scale, (region.Dy()+scale-1)/scale
+		if width == tileWidth && height >= tileHeight-1 && height <= tileHeight+1 {
+			level = p.MaxLevel()
+			for s := scale; s > 1; s /= 2 {
+				level--
+			}
+			return level, col, row, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// dzi is the Deep Zoom Image descriptor
+type dzi struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+	Format   string   `xml:"Format,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	TileSize int      `xml:"TileSize,attr"`
+	Size     struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	}
+}
+
+// DZI returns the Deep Zoom Image descriptor of the pyramid
+func (p *Pyramid) DZI() ([]byte, error) {
+	d := dzi{Format: "jpg", TileSize: p.TileSize}
+	d.Size.Width, d.Size.Height = p.Width, p.Height
+	b, err := xml.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// NeedsTiles returns true if a width x height photo is large enough
+// to be tiled
+// config: photo.tiles.minSize (longest side, default 2048, 0 disables tiling)
+func NeedsTiles(width, height uint32) bool {
+	minSize := config.GetIntDefault("photo.tiles.minSize", 2048)
+	return minSize > 0 && maxInt(int(width), int(height)) > minSize
+}
+
+// pyramidKey returns the datastore key of the pyramid of photo hash
+// it is stored once all tiles are
+func pyramidKey(hash string) string {
+	return hash + "_tiles"
+}
+
+// TileKey returns the datastore key of a tile of photo hash
+func TileKey(hash string, level, col, row int) string {
+	return fmt.Sprintf("%s_tile_%d_%d_%d", hash, level, col, row)
+}
+
+// GetPyramid returns the pyramid of photo hash
+// datastore.ErrNotFound is returned if tiles are not (yet) generated
+func GetPyramid(hash string) (*Pyramid, error) {
+	b, err := datastore.Get(pyramidKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return parsePyramid(hash, b)
+}
+
+// parsePyramid returns the pyramid of photo hash stored as b
+func parsePyramid(hash string, b []byte) (*Pyramid, error) {
+	p := new(Pyramid)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	if p.TileSize <= 0 {
+		return nil, fmt.Errorf("invalid pyramid of %s: tile size %d", hash, p.TileSize)
+	}
+	return p, nil
+}
+
+// GetTileData returns a tile of photo hash
+func GetTileData(hash string, level, col, row int) ([]byte, error) {
+	return datastore.Get(TileKey(hash, level, col, row))
+}
+
+// GenerateTiles generates the tile pyramid of photo hash from its full
+// size photo and stores it in datastore
+func GenerateTiles(hash string) error {
+	b, err := datastore.Get(hash)
+	if err != nil {
+		return err
+	}
+	img, err := image.NewFromBytes(b)
+	if err != nil {
+		return err
+	}
+	p := NewPyramid(img.Width(), img.Height())
+
+	for level := p.MaxLevel(); level >= 0; level-- {
+		width, height := p.LevelSize(level)
+		// each level is downscaled from the previous one
+		img.Scale(width, height)
+		cols, rows := p.LevelTiles(level)
+		for col := 0; col < cols; col++ {
+			for row := 0; row < rows; row++ {
+				tile := img.Copy()
+				if err = tile.Crop(col*p.TileSize, row*p.TileSize, p.TileSize, p.TileSize); err != nil {
+					return err
+				}
+				b, err = Encode(tile, image.FormatJPEG)
+				if err != nil {
+					return err
+				}
+				if err = datastore.Put(TileKey(hash, level, col, row), b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	b, err = json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return datastore.Put(pyramidKey(hash), b)
+}
+
+// deleteTiles deletes the tile pyramid of photo hash (if any)
+// tiles of an unreadable pyramid are not deleted
+func deleteTiles(hash string) error {
+	b, err := datastore.Get(pyramidKey(hash))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	// pyramid first: tiles are no longer served
+	if err = datastore.Delete(pyramidKey(hash)); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	p, err := parsePyramid(hash, b)
+	if err != nil {
+		return nil
+	}
+	for level := p.MaxLevel(); level >= 0; level-- {
+		cols, rows := p.LevelTiles(level)
+		for col := 0; col < cols; col++ {
+			for row := 0; row < rows; row++ {
+				err = datastore.Delete(TileKey(hash, level, col, row))
+				if err != nil && err != datastore.ErrNotFound {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maxInt returns the max of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
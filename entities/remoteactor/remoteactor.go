@@ -0,0 +1,89 @@
+package remoteactor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+)
+
+// RemoteActor is the cached version of a remote ActivityPub actor
+type RemoteActor struct {
+	ID                        uint      `json:"id"`
+	IRI                       string    `json:"iri"`
+	Acct                      string    `json:"acct"` // user@domain
+	Type                      string    `json:"type"`
+	PreferredUsername         string    `db:"preferred_username" json:"preferred_username"`
+	Name                      string    `json:"name"`
+	Inbox                     string    `json:"inbox"`
+	SharedInbox               string    `db:"shared_inbox" json:"shared_inbox"`
+	Outbox                    string    `json:"outbox"`
+	Followers                 string    `json:"followers"`
+	KeyID                     string    `db:"key_id" json:"key_id"`
+	PublicKeyPem              string    `db:"public_key_pem" json:"public_key_pem"`
+	MagicKey                  string    `db:"magic_key" json:"magic_key"` // OStatus fallback
+	ManuallyApprovesFollowers bool      `db:"manually_approves_followers" json:"manually_approves_followers"`
+	FetchedAt                 time.Time `db:"fetched_at" json:"fetched_at"`
+}
+
+// GetByIRI returns cached actor by its IRI
+func GetByIRI(iri string) (actor *RemoteActor, err error) {
+	actor = new(RemoteActor)
+	err = db.Get(actor, "SELECT * FROM remote_actors WHERE iri = ?", iri)
+	return
+}
+
+// GetByAcct returns cached actor by its acct (user@domain)
+func GetByAcct(acct string) (actor *RemoteActor, err error) {
+	actor = new(RemoteActor)
+	err = db.Get(actor, "SELECT * FROM remote_actors WHERE acct = ?", acct)
+	return
+}
+
+// GetByKeyID returns cached actor owning key keyID
+func GetByKeyID(keyID string) (actor *RemoteActor, err error) {
+	actor = new(RemoteActor)
+	err = db.Get(actor, "SELECT * FROM remote_actors WHERE key_id = ?", keyID)
+	return
+}
+
+// Create save new actor in DB
+func (a *RemoteActor) Create() error {
+	stmt, err := db.Preparex("INSERT INTO remote_actors (iri, acct, type, preferred_username, name, inbox, shared_inbox, outbox, followers, key_id, public_key_pem, magic_key, manually_approves_followers, fetched_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(a.IRI, a.Acct, a.Type, a.PreferredUsername, a.Name, a.Inbox, a.SharedInbox, a.Outbox, a.Followers, a.KeyID, a.PublicKeyPem, a.MagicKey, a.ManuallyApprovesFollowers, a.FetchedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = uint(id)
+	return nil
+}
+
+// Update update actor in DB
+func (a *RemoteActor) Update() error {
+	if a.ID == 0 {
+		return errors.New("remote actor is not recorded in DB yet, i can't update it")
+	}
+	stmt, err := db.Preparex("UPDATE remote_actors SET iri = ?, acct = ?, type = ?, preferred_username = ?, name = ?, inbox = ?, shared_inbox = ?, outbox = ?, followers = ?, key_id = ?, public_key_pem = ?, magic_key = ?, manually_approves_followers = ?, fetched_at = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(a.IRI, a.Acct, a.Type, a.PreferredUsername, a.Name, a.Inbox, a.SharedInbox, a.Outbox, a.Followers, a.KeyID, a.PublicKeyPem, a.MagicKey, a.ManuallyApprovesFollowers, a.FetchedAt, a.ID)
+	return err
+}
+
+// Delete removes actor from DB
+func (a *RemoteActor) Delete() error {
+	stmt, err := db.Preparex("DELETE FROM remote_actors WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(a.ID)
+	return err
+}
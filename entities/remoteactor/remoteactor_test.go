@@ -0,0 +1,52 @@
+package remoteactor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	db.InitMockedDatabase()
+}
+
+func TestGetByIRI(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"id", "iri", "acct"}).AddRow(1, "https://remote.social/users/john", "john@remote.social")
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("https://remote.social/users/john").WillReturnRows(rows)
+	actor, err := GetByIRI("https://remote.social/users/john")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(1), actor.ID)
+		assert.Equal(t, "john@remote.social", actor.Acct)
+	}
+}
+
+func TestRemoteActor_Create(t *testing.T) {
+	a := &RemoteActor{IRI: "https://remote.social/users/john", FetchedAt: time.Now()}
+
+	// prepare failed
+	db.Mock.ExpectPrepare("^INSERT INTO remote_actors (.*)").WillReturnError(errors.New("mocked"))
+	assert.EqualError(t, a.Create(), "mocked")
+
+	// ok
+	db.Mock.ExpectPrepare("^INSERT INTO remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	if assert.NoError(t, a.Create()) {
+		assert.Equal(t, uint(3), a.ID)
+	}
+}
+
+func TestRemoteActor_Update(t *testing.T) {
+	a := &RemoteActor{IRI: "https://remote.social/users/john"}
+	assert.Error(t, a.Update())
+
+	a.ID = 1
+	db.Mock.ExpectPrepare("^UPDATE remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, a.Update())
+}
@@ -0,0 +1,239 @@
+package remoteactor
+
+import (
+	"crypto/rsa"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/pkg/webfinger"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/log"
+)
+
+// remote lookups (vars for testing purpose)
+var (
+	fetchActor      = activitypub.FetchActor
+	fetchPublicKey  = activitypub.FetchPublicKey
+	webfingerLookup = webfinger.Lookup
+)
+
+// ttl returns how long a cached actor is considered fresh
+func ttl() time.Duration {
+	return config.GetDurationDefault("federation.actorCacheTTL", 24*time.Hour)
+}
+
+// Resolve returns actor iri from cache, fetching it if missing or expired
+// If refresh fails, the stale cached version is returned
+func Resolve(iri string) (*RemoteActor, error) {
+	iri = activitypub.StripFragment(iri)
+	actor, err := GetByIRI(iri)
+	switch err {
+	case nil:
+		if time.Since(actor.FetchedAt) < ttl() {
+			return actor, nil
+		}
+	case sql.ErrNoRows:
+		actor = nil
+	default:
+		return nil, err
+	}
+	fresh, err := Refresh(iri)
+	if err != nil {
+		if actor != nil {
+			log.Infof("remoteactor.Resolve - refresh %s failed, using cached version: %v", iri, err)
+			return actor, nil
+		}
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// ResolveAcct returns actor acct (acct:user@domain, user@domain or @user@domain)
+// Unknown or expired actors are resolved with WebFinger
+func ResolveAcct(acct string) (*RemoteActor, error) {
+	user, domain, err := webfinger.ParseAcct(acct)
+	if err != nil {
+		return nil, err
+	}
+	acct = user + "@" + domain
+	actor, err := GetByAcct(acct)
+	switch err {
+	case nil:
+		if time.Since(actor.FetchedAt) < ttl() {
+			return actor, nil
+		}
+		return Resolve(actor.IRI)
+	case sql.ErrNoRows:
+	default:
+		return nil, err
+	}
+
+	jrd, err := webfingerLookup(acct)
+	if err != nil {
+		return nil, err
+	}
+	self := jrd.Link(webfinger.RelSelf, activitypub.ContentType, "application/ld+json")
+	if self == nil || self.Href == "" {
+		return nil, fmt.Errorf("remoteactor: no ActivityPub actor found for %s", acct)
+	}
+	actor, err = refresh(self.Href, jrd)
+	if err != nil {
+		return nil, err
+	}
+	if actor.Acct != acct {
+		actor.Acct = acct
+		if err = actor.Update(); err != nil {
+			return nil, err
+		}
+	}
+	return actor, nil
+}
+
+// Refresh fetches actor iri and updates cache
+func Refresh(iri string) (*RemoteActor, error) {
+	return refresh(activitypub.StripFragment(iri), nil)
+}
+
+// refresh fetches actor iri and updates cache
+// jrd is the WebFinger document of the actor if already fetched
+func refresh(iri string, jrd *webfinger.JRD) (*RemoteActor, error) {
+	doc, err := fetchActor(iri)
+	if err != nil {
+		return nil, err
+	}
+	// a document can't claim to be another actor
+	if activitypub.StripFragment(doc.ID) != iri {
+		return nil, fmt.Errorf("remoteactor: %s is the document of %s", iri, doc.ID)
+	}
+	if doc.PublicKey != nil && !sameHost(doc.ID, doc.PublicKey.ID, doc.PublicKey.Owner) {
+		return nil, fmt.Errorf("remoteactor: key %s of %s is owned by %s", doc.PublicKey.ID, doc.ID, doc.PublicKey.Owner)
+	}
+
+	actor, err := GetByIRI(doc.ID)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		actor = &RemoteActor{IRI: doc.ID}
+	default:
+		return nil, err
+	}
+
+	actor.Type = doc.Type
+	actor.PreferredUsername = doc.PreferredUsername
+	actor.Name = doc.Name
+	actor.Inbox = doc.Inbox
+	actor.SharedInbox = ""
+	if doc.Endpoints != nil {
+		actor.SharedInbox = doc.Endpoints.SharedInbox
+	}
+	actor.Outbox = doc.Outbox
+	actor.Followers = doc.Followers
+	actor.ManuallyApprovesFollowers = doc.ManuallyApprovesFollowers
+	if actor.Acct == "" && doc.PreferredUsername != "" {
+		if u, err := url.Parse(doc.ID); err == nil {
+			actor.Acct = doc.PreferredUsername + "@" + strings.ToLower(u.Host)
+		}
+	}
+	if doc.PublicKey != nil {
+		actor.KeyID = doc.PublicKey.ID
+		actor.PublicKeyPem = doc.PublicKey.PublicKeyPem
+	} else {
+		// old OStatus servers: magic key published with WebFinger
+		actor.KeyID = doc.ID + "#main-key"
+		actor.PublicKeyPem = ""
+		if jrd == nil && actor.Acct != "" {
+			if jrd, err = webfingerLookup(actor.Acct); err != nil {
+				log.Infof("remoteactor.refresh - webfinger lookup of %s failed: %v", actor.Acct, err)
+			}
+		}
+		if jrd != nil {
+			actor.MagicKey = jrd.MagicKey()
+		}
+	}
+	actor.FetchedAt = time.Now()
+
+	if actor.ID == 0 {
+		err = actor.Create()
+	} else {
+		err = actor.Update()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// ResolvePublicKey returns public key keyID and the IRI of its owner
+// If forceRefresh is true the owner is refetched first (eg: a signature
+// failed with the cached key, the remote actor may have rotated it)
+// Forced refreshes are throttled by federation.actorMinRefreshInterval
+func ResolvePublicKey(keyID string, forceRefresh bool) (*rsa.PublicKey, string, error) {
+	actor, err := GetByKeyID(keyID)
+	switch err {
+	case nil:
+		age := time.Since(actor.FetchedAt)
+		if age >= ttl() || (forceRefresh && age >= config.GetDurationDefault("federation.actorMinRefreshInterval", time.Minute)) {
+			if fresh, err := Refresh(actor.IRI); err != nil {
+				log.Infof("remoteactor.ResolvePublicKey - refresh %s failed: %v", actor.IRI, err)
+			} else {
+				actor = fresh
+			}
+		}
+	case sql.ErrNoRows:
+		// key document gives us its owner
+		key, err := fetchPublicKey(keyID)
+		if err != nil {
+			return nil, "", err
+		}
+		if !sameHost(keyID, key.Owner) {
+			return nil, "", fmt.Errorf("remoteactor: key %s is not owned by %s", keyID, key.Owner)
+		}
+		if actor, err = Refresh(key.Owner); err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", err
+	}
+
+	if actor.KeyID != keyID {
+		return nil, "", fmt.Errorf("remoteactor: key %s is not owned by %s", keyID, actor.IRI)
+	}
+	pubKey, err := actor.PublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	return pubKey, actor.IRI, nil
+}
+
+// sameHost returns true if iris share the same host
+func sameHost(iris ...string) bool {
+	host := ""
+	for i, iri := range iris {
+		u, err := url.Parse(iri)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		if i == 0 {
+			host = strings.ToLower(u.Host)
+		} else if strings.ToLower(u.Host) != host {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicKey returns actor public key
+func (a *RemoteActor) PublicKey() (*rsa.PublicKey, error) {
+	if a.PublicKeyPem != "" {
+		return cryptobox.RSAParsePublicKeyPem(a.PublicKeyPem)
+	}
+	if a.MagicKey != "" {
+		return cryptobox.RSAParseMagicKey(a.MagicKey)
+	}
+	return nil, fmt.Errorf("remoteactor: no public key found for %s", a.IRI)
+}
@@ -0,0 +1,203 @@
+package remoteactor
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peerpx/peerpx/pkg/activitypub"
+	"github.com/peerpx/peerpx/pkg/webfinger"
+	"github.com/peerpx/peerpx/services/config"
+	"github.com/peerpx/peerpx/services/db"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const magicKey = "RSA.qVIsY_YRF_-Y3R5vHi8EsNr4fTxFQiYtDCHKj1Jd6eTV-LpxZesn-jspCUXEID0bowbUXly-QkBsA3ZBFOAE4vmd-XQ3ukt-aHHWJnJVpZjrMScDIYrJRENXAMyW4yZ1tnL66efm5_qsYypqOEICLr27A0-yIwlJ4vjlziy-rEwFihdJKorvRBCAiYBUgio7l9Y-Oo0kqd_ZL8DtBHYqsSyTcRcHL_s_O2Ktyxo7cUsvelmTClS2zjCJHAVwlnaPzFzVuG9WYTT9j1bU8JInAhxDSOylJKJoCtUrx1vJp-yF4N_JtXGZ-oP_W8u-1TQl1G54j0MFyalZjtEzEpe-RQ.AQAB"
+
+var actorColumns = []string{"id", "iri", "acct", "key_id", "magic_key", "fetched_at"}
+
+// mockRemote overrides remote lookups, fetched counts actor fetches
+func mockRemote(fetched *int) (restore func()) {
+	origFetchActor, origLookup := fetchActor, webfingerLookup
+	fetchActor = func(iri string) (*activitypub.Actor, error) {
+		*fetched++
+		if !strings.HasPrefix(iri, "https://remote.social/") {
+			return nil, errors.New("unreachable")
+		}
+		return &activitypub.Actor{
+			ID:                iri,
+			Type:              "Person",
+			PreferredUsername: "john",
+			Inbox:             iri + "/inbox",
+		}, nil
+	}
+	webfingerLookup = func(acct string) (*webfinger.JRD, error) {
+		return &webfinger.JRD{
+			Subject: "acct:" + acct,
+			Links: []webfinger.Link{
+				{Rel: webfinger.RelSelf, Type: activitypub.ContentType, Href: "https://remote.social/users/john"},
+				{Rel: webfinger.RelMagicPublicKey, Href: "data:application/magic-public-key," + magicKey},
+			},
+		}, nil
+	}
+	return func() {
+		fetchActor, webfingerLookup = origFetchActor, origLookup
+	}
+}
+
+func TestResolve(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	fetched := new(int)
+	defer mockRemote(fetched)()
+	iri := "https://remote.social/users/john"
+
+	// fresh cache
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(iri).
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(1, iri, "john@remote.social", iri+"#main-key", magicKey, time.Now()))
+	actor, err := Resolve(iri + "#main-key")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(1), actor.ID)
+		assert.Equal(t, 0, *fetched)
+	}
+
+	// unknown: fetched, magic key fallback, recorded
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(iri).WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(iri).WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	actor, err = Resolve(iri)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(2), actor.ID)
+		assert.Equal(t, 1, *fetched)
+		assert.Equal(t, "john@remote.social", actor.Acct)
+		assert.Equal(t, iri+"#main-key", actor.KeyID)
+		assert.Equal(t, magicKey, actor.MagicKey)
+		_, err = actor.PublicKey()
+		assert.NoError(t, err)
+	}
+
+	// expired and unreachable: stale version is returned
+	stale := "https://gone.social/users/john"
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(stale).
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(3, stale, "john@gone.social", "", "", time.Now().Add(-48*time.Hour)))
+	actor, err = Resolve(stale)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(3), actor.ID)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestResolveAcct(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	defer mockRemote(new(int))()
+	iri := "https://remote.social/users/john"
+
+	_, err := ResolveAcct("john")
+	assert.Equal(t, webfinger.ErrBadResource, err)
+
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("john@remote.social").WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(iri).WillReturnError(sql.ErrNoRows)
+	db.Mock.ExpectPrepare("^INSERT INTO remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	actor, err := ResolveAcct("@john@remote.social")
+	if assert.NoError(t, err) {
+		assert.Equal(t, iri, actor.IRI)
+		assert.Equal(t, "john@remote.social", actor.Acct)
+	}
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestResolvePublicKey(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	fetched := new(int)
+	defer mockRemote(fetched)()
+	iri := "https://remote.social/users/john"
+	keyID := iri + "#main-key"
+
+	// cached, fetched a few seconds ago: forced refresh is throttled
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(keyID).
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(1, iri, "john@remote.social", keyID, magicKey, time.Now()))
+	key, owner, err := ResolvePublicKey(keyID, true)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, key)
+		assert.Equal(t, iri, owner)
+		assert.Equal(t, 0, *fetched)
+	}
+
+	// forced refresh
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(keyID).
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(1, iri, "john@remote.social", keyID, "", time.Now().Add(-time.Hour)))
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(iri).
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(1, iri, "john@remote.social", keyID, "", time.Now().Add(-time.Hour)))
+	db.Mock.ExpectPrepare("^UPDATE remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	key, _, err = ResolvePublicKey(keyID, true)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, key)
+		assert.Equal(t, 1, *fetched)
+	}
+
+	// key owned by someone else
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(keyID).WillReturnError(sql.ErrNoRows)
+	origFetchPublicKey := fetchPublicKey
+	defer func() { fetchPublicKey = origFetchPublicKey }()
+	fetchPublicKey = func(keyID string) (*activitypub.PublicKey, error) {
+		return &activitypub.PublicKey{ID: keyID, Owner: "https://remote.social/users/jane"}, nil
+	}
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs("https://remote.social/users/jane").
+		WillReturnRows(sqlmock.NewRows(actorColumns).AddRow(2, "https://remote.social/users/jane", "jane@remote.social", "", "", time.Now()))
+	db.Mock.ExpectPrepare("^UPDATE remote_actors (.*)").
+		ExpectExec().
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	_, _, err = ResolvePublicKey(keyID, false)
+	assert.Error(t, err)
+
+	// key of another host: owner is not fetched
+	db.Mock.ExpectQuery("^SELECT(.*)").WithArgs(keyID).WillReturnError(sql.ErrNoRows)
+	fetchPublicKey = func(keyID string) (*activitypub.PublicKey, error) {
+		return &activitypub.PublicKey{ID: keyID, Owner: "https://evil.social/users/john"}, nil
+	}
+	*fetched = 0
+	_, _, err = ResolvePublicKey(keyID, false)
+	assert.Error(t, err)
+	assert.Equal(t, 0, *fetched)
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
+
+func TestRefresh_spoofed(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	origFetchActor := fetchActor
+	defer func() { fetchActor = origFetchActor }()
+	iri := "https://evil.social/users/john"
+
+	// document claiming to be another actor
+	fetchActor = func(iri string) (*activitypub.Actor, error) {
+		return &activitypub.Actor{
+			ID:        "https://remote.social/users/john",
+			Type:      "Person",
+			PublicKey: &activitypub.PublicKey{ID: iri + "#main-key", Owner: iri, PublicKeyPem: "pem"},
+		}, nil
+	}
+	_, err := Refresh(iri)
+	assert.Error(t, err)
+
+	// key of another host
+	fetchActor = func(iri string) (*activitypub.Actor, error) {
+		return &activitypub.Actor{
+			ID:        iri,
+			Type:      "Person",
+			PublicKey: &activitypub.PublicKey{ID: "https://remote.social/users/john#main-key", Owner: iri, PublicKeyPem: "pem"},
+		}, nil
+	}
+	_, err = Refresh(iri + "#main-key")
+	assert.Error(t, err)
+
+	// nothing was cached
+	assert.NoError(t, db.Mock.ExpectationsWereMet())
+}
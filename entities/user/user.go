@@ -10,7 +10,9 @@ import (
 
 	"github.com/gofrs/uuid"
 
+	"github.com/peerpx/peerpx/pkg/activitypub"
 	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/peerpx/peerpx/pkg/exif"
 
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/db"
@@ -40,6 +42,9 @@ type User struct {
 	PublicKey  sql.NullString `db:"public_key" json:"public_key"`
 	PrivateKey sql.NullString `db:"private_key" json:"-"`
 	AuthUUID   sql.NullString `db:"authuuid" json:"-"`
+
+	ManuallyApprovesFollowers bool        `db:"manually_approves_followers" json:"manually_approves_followers"`
+	MetadataPolicy            exif.Policy `db:"metadata_policy" json:"metadata_policy"` // metadata kept in published photos
 }
 
 // Gender is the user gender
@@ -144,6 +149,21 @@ func GetByEmail(email string) (user *User, err error) {
 	return
 }
 
+// GetByIRI returns local user from its ActivityPub ID
+func GetByIRI(iri string) (user *User, err error) {
+	prefix := fmt.Sprintf("https://%s/users/", config.GetString("hostname"))
+	if !strings.HasPrefix(iri, prefix) || strings.Contains(iri[len(prefix):], "/") {
+		return nil, ErrNoSuchUser
+	}
+	return GetByUsername(iri[len(prefix):])
+}
+
+// Count returns the number of users
+func Count() (count int, err error) {
+	err = db.Get(&count, "SELECT COUNT(*) FROM users")
+	return
+}
+
 // Login returns user if exists
 func Login(login, password string) (user *User, err error) {
 	isEmail := false
@@ -177,13 +197,31 @@ func Login(login, password string) (user *User, err error) {
 	return
 }
 
+// IRI returns user ActivityPub ID
+func (u *User) IRI() string {
+	return fmt.Sprintf("https://%s/users/%s", config.GetString("hostname"), u.Username)
+}
+
+// KeyID returns the ID of the user public key
+func (u *User) KeyID() string {
+	return u.IRI() + "#main-key"
+}
+
+// Client returns an HTTP client which signs requests with user private key
+func (u *User) Client() (*activitypub.Client, error) {
+	if u.PrivateKey.String == "" {
+		return nil, errors.New("user has no private key")
+	}
+	return activitypub.NewClient(u.KeyID(), u.PrivateKey.String)
+}
+
 // Create save new user in DB
 func (u *User) Create() error {
-	stmt, err := db.Preparex("INSERT INTO users (username, firstname, lastname, gender, email, address, city, state, zip, country, about, locale, show_nsfw, user_url, admin, avatar_url, password, public_key, private_key) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	stmt, err := db.Preparex("INSERT INTO users (username, firstname, lastname, gender, email, address, city, state, zip, country, about, locale, show_nsfw, user_url, admin, avatar_url, password, public_key, private_key, manually_approves_followers, metadata_policy) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
-	res, err := stmt.Exec(u.Username, u.Firstname, u.Lastname, u.Gender, u.Email, u.Address, u.City, u.State, u.Zip, u.Country, u.About, u.Locale, u.ShowNsfw, u.UserURL, u.Admin, u.AvatarURL, u.Password, u.PublicKey.String, u.PrivateKey.String)
+	res, err := stmt.Exec(u.Username, u.Firstname, u.Lastname, u.Gender, u.Email, u.Address, u.City, u.State, u.Zip, u.Country, u.About, u.Locale, u.ShowNsfw, u.UserURL, u.Admin, u.AvatarURL, u.Password, u.PublicKey.String, u.PrivateKey.String, u.ManuallyApprovesFollowers, u.MetadataPolicy)
 	if err != nil {
 		return err
 	}
@@ -197,11 +235,11 @@ func (u *User) Update() error {
 	if u.ID == 0 {
 		return errors.New("user unknown in database")
 	}
-	stmt, err := db.Preparex("UPDATE users SET username = ?, firstname = ?, lastname = ?, gender = ?, email = ?, address = ?, city = ?, state  = ?, zip = ?, country = ?, about = ?, locale = ?, show_nsfw = ?, user_url = ?, admin = ?, avatar_url = ?, password = ?, public_key = ?, private_key = ?, authuuid = ? WHERE id = ?")
+	stmt, err := db.Preparex("UPDATE users SET username = ?, firstname = ?, lastname = ?, gender = ?, email = ?, address = ?, city = ?, state  = ?, zip = ?, country = ?, about = ?, locale = ?, show_nsfw = ?, user_url = ?, admin = ?, avatar_url = ?, password = ?, public_key = ?, private_key = ?, authuuid = ?, manually_approves_followers = ?, metadata_policy = ? WHERE id = ?")
 	if err != nil {
 		return err
 	}
-	res, err := stmt.Exec(u.Username, u.Firstname, u.Lastname, u.Gender, u.Email, u.Address, u.City, u.State, u.Zip, u.Country, u.About, u.Locale, u.ShowNsfw, u.UserURL, u.Admin, u.AvatarURL, u.Password, u.PublicKey.String, u.PrivateKey.String, u.AuthUUID.String, u.ID)
+	res, err := stmt.Exec(u.Username, u.Firstname, u.Lastname, u.Gender, u.Email, u.Address, u.City, u.State, u.Zip, u.Country, u.About, u.Locale, u.ShowNsfw, u.UserURL, u.Admin, u.AvatarURL, u.Password, u.PublicKey.String, u.PrivateKey.String, u.AuthUUID.String, u.ManuallyApprovesFollowers, u.MetadataPolicy, u.ID)
 	if err != nil {
 		return err
 	}
@@ -9,6 +9,7 @@ import (
 
 	"database/sql"
 
+	"github.com/peerpx/peerpx/pkg/cryptobox"
 	"github.com/peerpx/peerpx/services/config"
 	"github.com/peerpx/peerpx/services/db"
 	"github.com/stretchr/testify/assert"
@@ -144,3 +145,54 @@ func TestUser_Update(t *testing.T) {
 		assert.Equal(t, user.ID, uint(1))
 	}
 }
+
+func TestUser_Client(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+	user := &User{Username: "john"}
+	assert.Equal(t, "https://peerpx.social/users/john", user.IRI())
+	assert.Equal(t, "https://peerpx.social/users/john#main-key", user.KeyID())
+
+	// no private key
+	_, err := user.Client()
+	assert.Error(t, err)
+
+	// ok
+	user.PrivateKey.String, user.PublicKey.String, err = cryptobox.RSAGenerateKeysAsPemStr()
+	if err != nil {
+		panic(err)
+	}
+	client, err := user.Client()
+	if assert.NoError(t, err) {
+		assert.Equal(t, user.KeyID(), client.KeyID)
+	}
+}
+
+func TestGetByIRI(t *testing.T) {
+	config.InitBasicConfig(strings.NewReader(""))
+	config.Set("hostname", "peerpx.social")
+
+	// not local
+	_, err := GetByIRI("https://remote.social/users/john")
+	assert.Equal(t, ErrNoSuchUser, err)
+
+	// not an user
+	_, err = GetByIRI("https://peerpx.social/users/john/outbox")
+	assert.Equal(t, ErrNoSuchUser, err)
+
+	// ok
+	row := sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "john")
+	db.Mock.ExpectQuery("^SELECT(.*)").WillReturnRows(row)
+	user, err := GetByIRI("https://peerpx.social/users/john")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "john", user.Username)
+	}
+}
+
+func TestCount(t *testing.T) {
+	db.Mock.ExpectQuery("^SELECT COUNT(.*)").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := Count()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+}
@@ -0,0 +1,231 @@
+// Package activitypub is a collection of ActivityStreams 2.0 types
+// and helpers used for federation
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Content types
+const (
+	// ContentType is the ActivityPub content type
+	ContentType = "application/activity+json"
+
+	// ContentTypeLD is the alternative JSON-LD content type
+	ContentTypeLD = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+	// Public is the special public collection
+	Public = "https://www.w3.org/ns/activitystreams#Public"
+
+	// Context is the ActivityStreams JSON-LD context
+	Context = "https://www.w3.org/ns/activitystreams"
+)
+
+// maxDocumentSize is the max size of a fetched document
+const maxDocumentSize = 1 << 20
+
+// errors
+var (
+	// ErrInvalidActivity is returned by ParseActivity if activity is not valid
+	ErrInvalidActivity = errors.New("activitypub: invalid activity")
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// IRI is an object identifier
+// When unmarshalled it accepts a string or an embedded object (only its id is kept)
+type IRI string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (i *IRI) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*i = IRI(s)
+		return nil
+	}
+	var o struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+	*i = IRI(o.ID)
+	return nil
+}
+
+// IRIs is a list of IRI
+// When unmarshalled it accepts a single value or an array
+type IRIs []IRI
+
+// UnmarshalJSON implements json.Unmarshaler
+func (l *IRIs) UnmarshalJSON(b []byte) error {
+	var list []IRI
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var one IRI
+	if err := json.Unmarshal(b, &one); err != nil {
+		return err
+	}
+	*l = IRIs{one}
+	return nil
+}
+
+// Contains returns true if iri is in the list
+func (l IRIs) Contains(iri string) bool {
+	for _, i := range l {
+		if string(i) == iri {
+			return true
+		}
+	}
+	return false
+}
+
+// Activity represents an ActivityStreams activity
+type Activity struct {
+	Context   interface{}     `json:"@context,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     IRI             `json:"actor"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	To        IRIs            `json:"to,omitempty"`
+	Cc        IRIs            `json:"cc,omitempty"`
+	Published *time.Time      `json:"published,omitempty"`
+}
+
+// NewActivity returns a new activity of type activityType
+// object can be an IRI or any marshallable object (embedded)
+func NewActivity(id, activityType, actor string, object interface{}) (*Activity, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	return &Activity{
+		Context: Context,
+		ID:      id,
+		Type:    activityType,
+		Actor:   IRI(actor),
+		Object:  raw,
+	}, nil
+}
+
+// ParseActivity unmarshals and validates an activity
+func ParseActivity(b []byte) (*Activity, error) {
+	a := new(Activity)
+	if err := json.Unmarshal(b, a); err != nil {
+		return nil, fmt.Errorf("activitypub: unmarshal activity failed: %v", err)
+	}
+	if a.Type == "" || a.Actor == "" {
+		return nil, ErrInvalidActivity
+	}
+	return a, nil
+}
+
+// ObjectIRI returns the id of the activity object, embedded or not
+func (a *Activity) ObjectIRI() string {
+	if len(a.Object) == 0 {
+		return ""
+	}
+	var iri IRI
+	if err := json.Unmarshal(a.Object, &iri); err != nil {
+		return ""
+	}
+	return string(iri)
+}
+
+// ObjectType returns the type of the embedded object
+// or an empty string if object is not embedded
+func (a *Activity) ObjectType() string {
+	var o struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(a.Object, &o); err != nil {
+		return ""
+	}
+	return o.Type
+}
+
+// ObjectActivity returns embedded object as an activity (eg: Undo(Follow))
+func (a *Activity) ObjectActivity() (*Activity, error) {
+	return ParseActivity(a.Object)
+}
+
+// PublicKey is the security vocabulary public key
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Endpoints represents actor endpoints
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+// Actor represents an ActivityStreams actor
+type Actor struct {
+	Context                   interface{} `json:"@context,omitempty"`
+	ID                        string      `json:"id"`
+	Type                      string      `json:"type"`
+	PreferredUsername         string      `json:"preferredUsername"`
+	Name                      string      `json:"name"`
+	Summary                   string      `json:"summary"`
+	URL                       IRI         `json:"url,omitempty"`
+	Inbox                     string      `json:"inbox"`
+	Outbox                    string      `json:"outbox"`
+	Followers                 string      `json:"followers,omitempty"`
+	Following                 string      `json:"following,omitempty"`
+	ManuallyApprovesFollowers bool        `json:"manuallyApprovesFollowers"`
+	Endpoints                 *Endpoints  `json:"endpoints,omitempty"`
+	PublicKey                 *PublicKey  `json:"publicKey,omitempty"`
+}
+
+// actorTypes are the ActivityStreams actor types
+var actorTypes = []string{"Application", "Group", "Organization", "Person", "Service"}
+
+// IsActorType returns true if objectType is an actor type
+func IsActorType(objectType string) bool {
+	for _, t := range actorTypes {
+		if t == objectType {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedInbox returns actor shared inbox if any, inbox otherwise
+func (a *Actor) SharedInbox() string {
+	if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+		return a.Endpoints.SharedInbox
+	}
+	return a.Inbox
+}
+
+// StripFragment removes the fragment part of an IRI
+func StripFragment(iri string) string {
+	if i := strings.Index(iri, "#"); i != -1 {
+		return iri[:i]
+	}
+	return iri
+}
+
+// Fetch dereferences iri (unsigned request) and returns the raw document
+func Fetch(iri string) ([]byte, error) {
+	return defaultClient.Get(iri)
+}
+
+// FetchActor dereferences an actor (unsigned request)
+func FetchActor(iri string) (*Actor, error) {
+	return defaultClient.FetchActor(iri)
+}
+
+// FetchPublicKey dereferences a keyId (unsigned request)
+func FetchPublicKey(keyID string) (*PublicKey, error) {
+	return defaultClient.FetchPublicKey(keyID)
+}
@@ -0,0 +1,91 @@
+package activitypub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseActivity(t *testing.T) {
+	// not json
+	_, err := ParseActivity([]byte("foo"))
+	assert.Error(t, err)
+
+	// no actor
+	_, err = ParseActivity([]byte(`{"type":"Follow"}`))
+	assert.Equal(t, ErrInvalidActivity, err)
+
+	// referenced object
+	a, err := ParseActivity([]byte(`{"id":"https://remote.social/1","type":"Follow","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop","to":"https://peerpx.social/users/toorop"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, IRI("https://remote.social/users/john"), a.Actor)
+		assert.Equal(t, "https://peerpx.social/users/toorop", a.ObjectIRI())
+		assert.Equal(t, "", a.ObjectType())
+		assert.True(t, a.To.Contains("https://peerpx.social/users/toorop"))
+	}
+
+	// embedded object
+	a, err = ParseActivity([]byte(`{"id":"https://remote.social/2","type":"Undo","actor":{"id":"https://remote.social/users/john"},"object":{"id":"https://remote.social/1","type":"Follow","actor":"https://remote.social/users/john","object":"https://peerpx.social/users/toorop"}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, IRI("https://remote.social/users/john"), a.Actor)
+		assert.Equal(t, "https://remote.social/1", a.ObjectIRI())
+		assert.Equal(t, "Follow", a.ObjectType())
+		follow, err := a.ObjectActivity()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "https://peerpx.social/users/toorop", follow.ObjectIRI())
+		}
+	}
+}
+
+func TestNewActivity(t *testing.T) {
+	follow, err := NewActivity("https://remote.social/1", "Follow", "https://remote.social/users/john", "https://peerpx.social/users/toorop")
+	if !assert.NoError(t, err) {
+		return
+	}
+	accept, err := NewActivity("https://peerpx.social/users/toorop#accepts/follows/1", "Accept", "https://peerpx.social/users/toorop", follow)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Context, accept.Context)
+	assert.Equal(t, "https://remote.social/1", accept.ObjectIRI())
+	assert.Equal(t, "Follow", accept.ObjectType())
+}
+
+func TestFetchPublicKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/john":
+			w.Write([]byte(`{"id":"https://remote.social/users/john","type":"Person","inbox":"https://remote.social/users/john/inbox","publicKey":{"id":"https://remote.social/users/john#main-key","owner":"https://remote.social/users/john","publicKeyPem":"PEM"}}`))
+		case "/keys/jane":
+			w.Write([]byte(`{"id":"https://remote.social/keys/jane","owner":"https://remote.social/users/jane","publicKeyPem":"PEM"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	// embedded in actor
+	key, err := FetchPublicKey(ts.URL + "/users/john#main-key")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://remote.social/users/john", key.Owner)
+		assert.Equal(t, "PEM", key.PublicKeyPem)
+	}
+
+	// standalone key
+	key, err = FetchPublicKey(ts.URL + "/keys/jane")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://remote.social/users/jane", key.Owner)
+	}
+
+	// not found
+	_, err = FetchPublicKey(ts.URL + "/users/foo#main-key")
+	assert.Error(t, err)
+
+	// actor
+	actor, err := FetchActor(ts.URL + "/users/john")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://remote.social/users/john/inbox", actor.SharedInbox())
+	}
+}
@@ -0,0 +1,152 @@
+package activitypub
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+)
+
+// headers signed on outgoing requests
+var (
+	getSignedHeaders  = []string{"(request-target)", "host", "date"}
+	postSignedHeaders = []string{"(request-target)", "host", "date", "digest"}
+)
+
+// UserAgent is the User-Agent header sent with outgoing requests
+var UserAgent = "PeerPx"
+
+// Client is an HTTP client which signs requests (HTTP Signatures)
+// on behalf of an actor. Unsigned if PrivateKey is nil
+type Client struct {
+	KeyID      string
+	PrivateKey *rsa.PrivateKey
+	HTTPClient *http.Client
+}
+
+// defaultClient is used for unsigned requests
+var defaultClient = &Client{HTTPClient: httpClient}
+
+// NewClient returns a client which signs request with privateKeyPem
+// keyID is the key IRI: https://host/users/{name}#main-key
+func NewClient(keyID, privateKeyPem string) (*Client, error) {
+	privKey, err := cryptobox.RSAParsePrivateKeyPem(privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		KeyID:      keyID,
+		PrivateKey: privKey,
+		HTTPClient: httpClient,
+	}, nil
+}
+
+// do signs (if possible) and sends the request
+func (c *Client) do(req *http.Request, signedHeaders []string) (*http.Response, error) {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("User-Agent", UserAgent)
+	if c.PrivateKey != nil {
+		if err := cryptobox.HTTPSign(req, c.KeyID, c.PrivateKey, signedHeaders); err != nil {
+			return nil, err
+		}
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// Get dereferences iri and returns the raw document
+func (c *Client) Get(iri string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, iri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+", "+ContentTypeLD)
+	resp, err := c.do(req, getSignedHeaders)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("activitypub: GET %s returned %s", iri, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+}
+
+// Post delivers body to inbox
+func (c *Client) Post(inbox string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypeLD)
+	req.Header.Set("Digest", cryptobox.DigestSHA256(body))
+	resp, err := c.do(req, postSignedHeaders)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain body to reuse connection
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("activitypub: POST %s returned %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// PostActivity marshals activity and delivers it to inbox
+func (c *Client) PostActivity(inbox string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return c.Post(inbox, body)
+}
+
+// FetchActor dereferences an actor
+func (c *Client) FetchActor(iri string) (*Actor, error) {
+	b, err := c.Get(iri)
+	if err != nil {
+		return nil, err
+	}
+	actor := new(Actor)
+	if err = json.Unmarshal(b, actor); err != nil {
+		return nil, fmt.Errorf("activitypub: unmarshal actor %s failed: %v", iri, err)
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return nil, fmt.Errorf("activitypub: %s is not a valid actor", iri)
+	}
+	return actor, nil
+}
+
+// FetchPublicKey dereferences a keyId
+// keyId can point to the actor document (https://host/users/john#main-key)
+// or to a standalone key document
+func (c *Client) FetchPublicKey(keyID string) (*PublicKey, error) {
+	b, err := c.Get(StripFragment(keyID))
+	if err != nil {
+		return nil, err
+	}
+	doc := struct {
+		PublicKey
+		Key *PublicKey `json:"publicKey"`
+	}{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("activitypub: unmarshal key %s failed: %v", keyID, err)
+	}
+	key := doc.Key
+	if key == nil {
+		key = &doc.PublicKey
+	}
+	if key.PublicKeyPem == "" {
+		return nil, fmt.Errorf("activitypub: no public key found at %s", keyID)
+	}
+	if key.Owner == "" {
+		key.Owner = StripFragment(keyID)
+	}
+	return key, nil
+}
@@ -0,0 +1,64 @@
+package activitypub
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peerpx/peerpx/pkg/cryptobox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	privKeyPem, _, err := cryptobox.RSAGenerateKeysAsPemStr()
+	if err != nil {
+		panic(err)
+	}
+
+	// bad key
+	_, err = NewClient("https://peerpx.social/users/john#main-key", "foo")
+	assert.Error(t, err)
+
+	client, err := NewClient("https://peerpx.social/users/john#main-key", privKeyPem)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, err := cryptobox.HTTPSignatureParse(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err = sig.Verify(r, &client.PrivateKey.PublicKey); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"id":"https://remote.social/users/jane","type":"Person","inbox":"https://remote.social/users/jane/inbox"}`))
+		case http.MethodPost:
+			body, _ := ioutil.ReadAll(r.Body)
+			if !sig.Covers("digest") || cryptobox.DigestVerify(r.Header.Get("Digest"), body) != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+
+	// signed GET
+	actor, err := client.FetchActor(ts.URL + "/users/jane")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://remote.social/users/jane/inbox", actor.Inbox)
+	}
+
+	// unsigned GET
+	_, err = FetchActor(ts.URL + "/users/jane")
+	assert.Error(t, err)
+
+	// signed POST
+	assert.NoError(t, client.PostActivity(ts.URL+"/inbox", Activity{ID: "https://peerpx.social/1", Type: "Follow", Actor: "https://peerpx.social/users/john"}))
+}
@@ -0,0 +1,46 @@
+package activitypub
+
+// OrderedCollection represents an ActivityStreams OrderedCollection
+// Items are not embedded, they are served by pages (see First)
+type OrderedCollection struct {
+	Context    interface{} `json:"@context,omitempty"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	TotalItems int         `json:"totalItems"`
+	First      string      `json:"first,omitempty"`
+}
+
+// NewOrderedCollection returns an ordered collection whose first page is first
+func NewOrderedCollection(id string, totalItems int, first string) *OrderedCollection {
+	return &OrderedCollection{
+		Context:    Context,
+		ID:         id,
+		Type:       "OrderedCollection",
+		TotalItems: totalItems,
+		First:      first,
+	}
+}
+
+// OrderedCollectionPage represents a page of an OrderedCollection
+type OrderedCollectionPage struct {
+	Context      interface{}   `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	PartOf       string        `json:"partOf"`
+	Next         string        `json:"next,omitempty"`
+	Prev         string        `json:"prev,omitempty"`
+	OrderedItems []interface{} `json:"orderedItems"`
+}
+
+// NewOrderedCollectionPage returns an empty page of collection partOf
+func NewOrderedCollectionPage(id, partOf string, totalItems int) *OrderedCollectionPage {
+	return &OrderedCollectionPage{
+		Context:      Context,
+		ID:           id,
+		Type:         "OrderedCollectionPage",
+		TotalItems:   totalItems,
+		PartOf:       partOf,
+		OrderedItems: []interface{}{},
+	}
+}
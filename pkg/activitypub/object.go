@@ -0,0 +1,87 @@
+package activitypub
+
+import "time"
+
+// Link represents an ActivityStreams Link
+type Link struct {
+	Type      string `json:"type"`
+	Href      string `json:"href"`
+	Name      string `json:"name,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	Width     uint32 `json:"width,omitempty"`
+	Height    uint32 `json:"height,omitempty"`
+}
+
+// Place represents an ActivityStreams Place
+type Place struct {
+	Type      string   `json:"type"`
+	Name      string   `json:"name,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// PropertyValue is a schema.org name/value pair, used as attachment
+type PropertyValue struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NewPropertyValue returns a new PropertyValue
+func NewPropertyValue(name, value string) PropertyValue {
+	return PropertyValue{Type: "PropertyValue", Name: name, Value: value}
+}
+
+// ImageContext is the JSON-LD context of Image objects
+// it extends ActivityStreams with the terms used by Image
+var ImageContext = []interface{}{
+	Context,
+	map[string]string{
+		"schema":        "http://schema.org#",
+		"PropertyValue": "schema:PropertyValue",
+		"value":         "schema:value",
+		"license":       "schema:license",
+		"sensitive":     "as:sensitive",
+	},
+}
+
+// Image represents an ActivityStreams Image object
+type Image struct {
+	Context      interface{}     `json:"@context,omitempty"`
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	AttributedTo string          `json:"attributedTo"`
+	Name         string          `json:"name,omitempty"`
+	Content      string          `json:"content,omitempty"`
+	MediaType    string          `json:"mediaType,omitempty"`
+	URL          []Link          `json:"url"`
+	Width        uint32          `json:"width,omitempty"`
+	Height       uint32          `json:"height,omitempty"`
+	Published    *time.Time      `json:"published,omitempty"`
+	Updated      *time.Time      `json:"updated,omitempty"`
+	Sensitive    bool            `json:"sensitive"`
+	License      string          `json:"license,omitempty"`
+	Location     *Place          `json:"location,omitempty"`
+	Attachment   []PropertyValue `json:"attachment,omitempty"`
+	To           IRIs            `json:"to,omitempty"`
+	Cc           IRIs            `json:"cc,omitempty"`
+}
+
+// Tombstone represents a deleted object
+type Tombstone struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	FormerType string     `json:"formerType,omitempty"`
+	Deleted    *time.Time `json:"deleted,omitempty"`
+}
+
+// NewTombstone returns the Tombstone of object id of type formerType
+func NewTombstone(id, formerType string) *Tombstone {
+	deleted := time.Now().UTC()
+	return &Tombstone{
+		ID:         id,
+		Type:       "Tombstone",
+		FormerType: formerType,
+		Deleted:    &deleted,
+	}
+}
@@ -0,0 +1,249 @@
+package cryptobox
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures (draft-cavage-http-signatures) helpers
+// https://tools.ietf.org/html/draft-cavage-http-signatures-10
+
+// errors
+var (
+	// ErrHTTPSignatureMissing is returned if request has no Signature header
+	ErrHTTPSignatureMissing = errors.New("http signature: signature header is missing")
+
+	// ErrHTTPSignatureBadSyntax is returned if Signature header can't be parsed
+	ErrHTTPSignatureBadSyntax = errors.New("http signature: bad syntax")
+
+	// ErrHTTPSignatureUnsupportedAlgorithm is returned for non RSA-SHA256 signatures
+	ErrHTTPSignatureUnsupportedAlgorithm = errors.New("http signature: unsupported algorithm")
+
+	// ErrHTTPSignatureInvalid is returned when signature doesn't match
+	ErrHTTPSignatureInvalid = errors.New("http signature: invalid signature")
+
+	// ErrDigestMismatch is returned when Digest header doesn't match body
+	ErrDigestMismatch = errors.New("digest: mismatch")
+)
+
+// HTTPSignature represents a parsed Signature header
+type HTTPSignature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+// HTTPSignatureParse parses the Signature header of r
+// (or Authorization: Signature ...)
+func HTTPSignatureParse(r *http.Request) (*HTTPSignature, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Signature ") {
+			return nil, ErrHTTPSignatureMissing
+		}
+		header = strings.TrimPrefix(auth, "Signature ")
+	}
+
+	sig := &HTTPSignature{
+		// default value regarding the draft
+		Headers: []string{"date"},
+	}
+	for _, param := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrHTTPSignatureBadSyntax
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch strings.ToLower(kv[0]) {
+		case "keyid":
+			sig.KeyID = value
+		case "algorithm":
+			sig.Algorithm = strings.ToLower(value)
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("http signature: signature is not valid base64: %v", err)
+			}
+			sig.Signature = b
+		}
+	}
+	if sig.KeyID == "" || len(sig.Signature) == 0 {
+		return nil, ErrHTTPSignatureBadSyntax
+	}
+	return sig, nil
+}
+
+// Covers returns true if header is part of the signed headers
+func (s *HTTPSignature) Covers(header string) bool {
+	header = strings.ToLower(header)
+	for _, h := range s.Headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks signature against r using pubKey
+func (s *HTTPSignature) Verify(r *http.Request, pubKey *rsa.PublicKey) error {
+	switch s.Algorithm {
+	// hs2019: algorithm must be derived from key, we only deal with RSA keys
+	case "", "rsa-sha256", "hs2019":
+	default:
+		return ErrHTTPSignatureUnsupportedAlgorithm
+	}
+	signingString, err := HTTPSignatureSigningString(r, s.Headers)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signingString))
+	if err = rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, hashed[:], s.Signature); err != nil {
+		return ErrHTTPSignatureInvalid
+	}
+	return nil
+}
+
+// HTTPSignatureSigningString returns the string to sign (or to verify) for r
+func HTTPSignatureSigningString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		h = strings.ToLower(h)
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			values := r.Header[http.CanonicalHeaderKey(h)]
+			if len(values) == 0 {
+				return "", fmt.Errorf("http signature: header %s is missing", h)
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines[i] = h + ": " + strings.TrimSpace(value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// HTTPSignatureCheckDate checks that the Date header of r is in [now-maxSkew, now+maxSkew]
+func HTTPSignatureCheckDate(r *http.Request, maxSkew time.Duration) error {
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("http signature: bad date header: %v", err)
+	}
+	if d := time.Since(date); d > maxSkew || d < -maxSkew {
+		return fmt.Errorf("http signature: date %s is out of the accepted window", date)
+	}
+	return nil
+}
+
+// DigestSHA256 returns Digest header value for body
+func DigestSHA256(body []byte) string {
+	h := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// DigestVerify checks that Digest header match body
+// supported algorithms: SHA-256, SHA-512
+func DigestVerify(header string, body []byte) error {
+	for _, d := range strings.Split(header, ",") {
+		algoValue := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(algoValue) != 2 {
+			continue
+		}
+		var sum []byte
+		switch strings.ToUpper(algoValue[0]) {
+		case "SHA-256":
+			h := sha256.Sum256(body)
+			sum = h[:]
+		case "SHA-512":
+			h := sha512.Sum512(body)
+			sum = h[:]
+		default:
+			continue
+		}
+		if base64.StdEncoding.EncodeToString(sum) != algoValue[1] {
+			return ErrDigestMismatch
+		}
+		return nil
+	}
+	return fmt.Errorf("digest: no supported algorithm found in %s", header)
+}
+
+// RSAParsePublicKeyPem parses a PEM encoded RSA public key
+// PKIX (most AP implementations) and PKCS1 (ours) are supported
+func RSAParsePublicKeyPem(pubKeyPem string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pubKeyPem))
+	if block == nil {
+		return nil, errors.New("no PEM block found in public key")
+	}
+	if pubKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pubKey, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKIXPublicKey(block) failed: %v", err)
+	}
+	pubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return pubKey, nil
+}
+
+// HTTPSign signs r with privKey, headers are the headers to sign
+// Date (and Digest if signed) headers must be set before calling HTTPSign
+func HTTPSign(r *http.Request, keyID string, privKey *rsa.PrivateKey, headers []string) error {
+	signingString, err := HTTPSignatureSigningString(r, headers)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, privKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return fmt.Errorf("rsa.SignPKCS1v15 failed: %v", err)
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.ToLower(strings.Join(headers, " ")), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// RSAParsePrivateKeyPem parses a PEM encoded RSA private key
+// PKCS1 (ours) and PKCS8 are supported
+func RSAParsePrivateKeyPem(privKeyPem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privKeyPem))
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	if privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privKey, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey(block) failed: %v", err)
+	}
+	privKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return privKey, nil
+}
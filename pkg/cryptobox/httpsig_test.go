@@ -0,0 +1,90 @@
+package cryptobox
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	body := `{"type":"Follow"}`
+	headers := []string{"(request-target)", "host", "date", "digest"}
+
+	// no signature
+	req := httptest.NewRequest(http.MethodPost, "/users/toorop/inbox", strings.NewReader(body))
+	_, err = HTTPSignatureParse(req)
+	assert.Equal(t, ErrHTTPSignatureMissing, err)
+
+	// bad syntax
+	req.Header.Set("Signature", `keyId="foo",signature`)
+	_, err = HTTPSignatureParse(req)
+	assert.Equal(t, ErrHTTPSignatureBadSyntax, err)
+
+	// ok
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", DigestSHA256([]byte(body)))
+	assert.NoError(t, HTTPSign(req, "https://remote.social/users/john#main-key", key, headers))
+	sig, err := HTTPSignatureParse(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://remote.social/users/john#main-key", sig.KeyID)
+		assert.True(t, sig.Covers("Digest"))
+		assert.False(t, sig.Covers("content-type"))
+		assert.NoError(t, sig.Verify(req, &key.PublicKey))
+		assert.NoError(t, HTTPSignatureCheckDate(req, time.Hour))
+	}
+
+	// tampered
+	req.Header.Set("Digest", DigestSHA256([]byte("tampered")))
+	assert.Equal(t, ErrHTTPSignatureInvalid, sig.Verify(req, &key.PublicKey))
+
+	// too old
+	req.Header.Set("Date", time.Now().Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+	assert.Error(t, HTTPSignatureCheckDate(req, time.Hour))
+
+	// unsupported algorithm
+	sig.Algorithm = "ecdsa-sha256"
+	assert.Equal(t, ErrHTTPSignatureUnsupportedAlgorithm, sig.Verify(req, &key.PublicKey))
+}
+
+func TestDigestVerify(t *testing.T) {
+	body := []byte("hello peerpx")
+	assert.NoError(t, DigestVerify(DigestSHA256(body), body))
+	assert.Equal(t, ErrDigestMismatch, DigestVerify(DigestSHA256(body), []byte("hello")))
+	assert.Error(t, DigestVerify("MD5=foo", body))
+}
+
+func TestRSAParsePublicKeyPem(t *testing.T) {
+	_, err := RSAParsePublicKeyPem("foo")
+	assert.Error(t, err)
+
+	// PKCS1
+	_, err = RSAParsePublicKeyPem(RSAPubKeyOK)
+	assert.NoError(t, err)
+}
+
+func TestRSAParsePrivateKeyPem(t *testing.T) {
+	_, err := RSAParsePrivateKeyPem("foo")
+	assert.Error(t, err)
+
+	privKeyPem, pubKeyPem, err := RSAGenerateKeysAsPemStr()
+	if err != nil {
+		panic(err)
+	}
+	privKey, err := RSAParsePrivateKeyPem(privKeyPem)
+	if assert.NoError(t, err) {
+		pubKey, err := RSAParsePublicKeyPem(pubKeyPem)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, privKey.PublicKey.N.Cmp(pubKey.N))
+		}
+	}
+}
@@ -1,7 +1,10 @@
 // Package cryptobox is a collection of crypto helpers
 package cryptobox
 
-import "encoding/base64"
+import (
+	"encoding/base64"
+	"fmt"
+)
 
 // KeyToString returns a base64 encoded string representation of nacl key
 func KeyToString(key *[32]byte) string {
@@ -11,3 +14,17 @@ func KeyToString(key *[32]byte) string {
 	}
 	return base64.StdEncoding.EncodeToString(t)
 }
+
+// KeyFromString returns nacl key represented by s (see KeyToString)
+func KeyFromString(s string) (*[32]byte, error) {
+	t, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(t) != 32 {
+		return nil, fmt.Errorf("cryptobox: bad key length %d", len(t))
+	}
+	key := new([32]byte)
+	copy(key[:], t)
+	return key, nil
+}
@@ -0,0 +1,245 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Exif is the EXIF metadata of a photo
+type Exif struct {
+	Camera       string
+	Lens         string
+	FocalLength  uint16
+	Iso          uint16
+	ShutterSpeed string  // "1/250", "2"
+	Aperture     float32 // 5.6, 32, 1.4
+	TakenAt      time.Time
+	HasLocation  bool
+	Latitude     float64
+	Longitude    float64
+	Tags         map[string]string // raw tags (name -> value)
+}
+
+// skippedTags are tags which are not kept in the raw tags map
+// (offsets to sub-IFDs and thumbnail, binary blobs)
+var skippedTags = map[goexif.FieldName]bool{
+	goexif.ExifIFDPointer:             true,
+	goexif.GPSInfoIFDPointer:          true,
+	goexif.InteroperabilityIFDPointer: true,
+	goexif.ThumbJPEGInterchangeFormat: true,
+	goexif.MakerNote:                  true,
+}
+
+// offsetTimeFields are the EXIF 2.31 time offset tags (not mapped by goexif)
+var offsetTimeFields = map[uint16]goexif.FieldName{
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+}
+
+func init() {
+	goexif.RegisterParsers(offsetTimeParser{})
+}
+
+// offsetTimeParser loads time offset tags from the EXIF sub-IFD
+type offsetTimeParser struct{}
+
+// Parse implements goexif.Parser
+// offsets are optional: errors are ignored
+func (offsetTimeParser) Parse(x *goexif.Exif) error {
+	tag, err := x.Get(goexif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err = r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, offsetTimeFields, false)
+	return nil
+}
+
+// decode returns EXIF of photo b (JPEG, TIFF or WebP)
+func decode(b []byte) (*goexif.Exif, error) {
+	if chunk := webpExif(b); chunk != nil {
+		b = chunk
+	}
+	return goexif.Decode(bytes.NewReader(b))
+}
+
+// webpExif returns the EXIF chunk of WebP b (nil if b is not a WebP
+// or has no EXIF)
+func webpExif(b []byte) []byte {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return nil
+	}
+	for i := 12; i+8 <= len(b); {
+		size := int(binary.LittleEndian.Uint32(b[i+4:]))
+		if size < 0 || i+8+size > len(b) {
+			return nil
+		}
+		if string(b[i:i+4]) == "EXIF" {
+			return b[i+8 : i+8+size]
+		}
+		// chunks are padded to even size
+		i += 8 + size + size%2
+	}
+	return nil
+}
+
+// Parse returns EXIF metadata of photo b (JPEG, TIFF or WebP)
+func Parse(b []byte) (*Exif, error) {
+	x, err := decode(b)
+	if err != nil && (x == nil || goexif.IsCriticalError(err)) {
+		return nil, err
+	}
+
+	e := &Exif{Tags: make(map[string]string)}
+	x.Walk(tagsWalker(e.Tags))
+
+	// camera: make + model (model often includes make)
+	maker := e.Tags[string(goexif.Make)]
+	model := e.Tags[string(goexif.Model)]
+	if strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		maker = ""
+	}
+	e.Camera = strings.TrimSpace(maker + " " + model)
+
+	// lens ("----" for manual lenses)
+	if lens := e.Tags[string(goexif.LensModel)]; strings.Trim(lens, "- ") != "" {
+		e.Lens = lens
+	}
+
+	if f, ok := ratFloat(x, goexif.FocalLength); ok {
+		e.FocalLength = uint16(math.Floor(f + 0.5))
+	}
+	if tag, err := x.Get(goexif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			e.Iso = uint16(iso)
+		}
+	}
+	if tag, err := x.Get(goexif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 && den > 0 {
+			e.ShutterSpeed = shutterSpeed(num, den)
+		}
+	}
+	if f, ok := ratFloat(x, goexif.FNumber); ok {
+		e.Aperture = float32(math.Floor(f*10+0.5) / 10)
+	}
+
+	e.TakenAt = takenAt(e.Tags)
+
+	if lat, long, err := x.LatLong(); err == nil {
+		e.HasLocation = true
+		e.Latitude, e.Longitude = lat, long
+	}
+	return e, nil
+}
+
+// Orientation returns EXIF orientation (1 to 8) of photo b (1 if unknown)
+func Orientation(b []byte) int {
+	x, err := decode(b)
+	if err != nil && (x == nil || goexif.IsCriticalError(err)) {
+		return 1
+	}
+	tag, err := x.Get(goexif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// tagsWalker fills tags with the string value of walked tags
+type tagsWalker map[string]string
+
+// Walk implements goexif.Walker
+func (w tagsWalker) Walk(name goexif.FieldName, tag *tiff.Tag) error {
+	if skippedTags[name] {
+		return nil
+	}
+	switch tag.Format() {
+	case tiff.StringVal:
+		s, _ := tag.StringVal()
+		w[string(name)] = strings.TrimSpace(s)
+	case tiff.UndefVal:
+		// binary blobs are not relevant
+		if tag.Count > 64 {
+			return nil
+		}
+		w[string(name)] = strings.Trim(tag.String(), `"`)
+	default:
+		w[string(name)] = strings.Trim(tag.String(), `"`)
+	}
+	return nil
+}
+
+// ratFloat returns rational tag name as float
+func ratFloat(x *goexif.Exif, name goexif.FieldName) (float64, bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0, false
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || num <= 0 || den <= 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// shutterSpeed formats exposure time num/den ("1/250", "0.5", "2")
+func shutterSpeed(num, den int64) string {
+	if num < den {
+		// 10/2500 -> 1/250
+		if den%num == 0 || den/num >= 10 {
+			return fmt.Sprintf("1/%d", int64(math.Floor(float64(den)/float64(num)+0.5)))
+		}
+	}
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.1f", float64(num)/float64(den)), "0"), ".")
+}
+
+// takenAt returns original date time using time offset tags when present
+// (UTC is assumed when offset is unknown)
+func takenAt(tags map[string]string) time.Time {
+	const layout = "2006:01:02 15:04:05"
+	dt := tags[string(goexif.DateTimeOriginal)]
+	offset := tags["OffsetTimeOriginal"]
+	if dt == "" {
+		dt = tags[string(goexif.DateTime)]
+		offset = tags["OffsetTime"]
+	}
+	if offset == "" {
+		offset = tags["OffsetTime"]
+	}
+	if dt == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if t, err := time.Parse(layout+"-07:00", dt+offset); err == nil {
+			return t
+		}
+	}
+	t, err := time.ParseInLocation(layout, dt, time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
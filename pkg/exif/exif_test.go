@@ -0,0 +1,160 @@
+package exif
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	// no EXIF
+	_, err := Parse([]byte("not a photo"))
+	assert.Error(t, err)
+
+	// camera, no GPS, no offset
+	photoBytes, err := ioutil.ReadFile("../../etc/samples/photos/robin.jpg")
+	if err != nil {
+		panic(err)
+	}
+	e, err := Parse(photoBytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SONY ILCE-7M2", e.Camera)
+		assert.Equal(t, "", e.Lens)
+		assert.Equal(t, uint16(50), e.Iso)
+		assert.Equal(t, "1/20", e.ShutterSpeed)
+		assert.Equal(t, time.Date(2018, 4, 22, 18, 29, 5, 0, time.UTC), e.TakenAt)
+		assert.False(t, e.HasLocation)
+		assert.Equal(t, "ILCE-7M2", e.Tags["Model"])
+		assert.Equal(t, "50", e.Tags["ISOSpeedRatings"])
+		_, found := e.Tags["ExifIFDPointer"]
+		assert.False(t, found)
+	}
+
+	// GPS and offset
+	photoBytes, err = ioutil.ReadFile("../../etc/samples/photos/exif-gps.jpg")
+	if err != nil {
+		panic(err)
+	}
+	e, err = Parse(photoBytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "FE 35mm F2.8 ZA", e.Lens)
+		assert.Equal(t, uint16(35), e.FocalLength)
+		assert.Equal(t, float32(2.8), e.Aperture)
+		assert.Equal(t, "1/250", e.ShutterSpeed)
+		assert.True(t, e.TakenAt.Equal(time.Date(2018, 6, 15, 12, 30, 0, 0, time.UTC)))
+		assert.Equal(t, "+02:00", e.Tags["OffsetTimeOriginal"])
+		assert.True(t, e.HasLocation)
+		assert.InDelta(t, 48.8567, e.Latitude, 0.0001)
+		assert.InDelta(t, 2.35, e.Longitude, 0.0001)
+	}
+}
+
+func TestShutterSpeed(t *testing.T) {
+	assert.Equal(t, "1/250", shutterSpeed(1, 250))
+	assert.Equal(t, "1/250", shutterSpeed(10, 2500))
+	assert.Equal(t, "0.3", shutterSpeed(3, 10))
+	assert.Equal(t, "2", shutterSpeed(2, 1))
+	assert.Equal(t, "1.3", shutterSpeed(13, 10))
+}
+
+func TestMetadata(t *testing.T) {
+	original, err := ioutil.ReadFile("../../etc/samples/photos/exif-gps.jpg")
+	if err != nil {
+		panic(err)
+	}
+	// published photo (re-encoded, without metadata)
+	img, err := jpeg.Decode(bytes.NewReader(original))
+	if err != nil {
+		panic(err)
+	}
+	buf := new(bytes.Buffer)
+	if err = jpeg.Encode(buf, img, nil); err != nil {
+		panic(err)
+	}
+	published := buf.Bytes()
+	assert.Len(t, Segments(published), 0)
+
+	// keep all
+	metadata, err := Metadata(original, PolicyKeepAll, "")
+	if assert.NoError(t, err) {
+		e, err := Parse(Insert(published, metadata))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "SONY ILCE-7M2", e.Camera)
+			assert.Equal(t, "+02:00", e.Tags["OffsetTimeOriginal"])
+			assert.True(t, e.HasLocation)
+		}
+	}
+
+	// strip GPS
+	metadata, err = Metadata(original, PolicyStripGPS, "")
+	if assert.NoError(t, err) {
+		withMetadata := Insert(published, metadata)
+		assert.Equal(t, metadata, Segments(withMetadata))
+		e, err := Parse(withMetadata)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "SONY ILCE-7M2", e.Camera)
+			assert.Equal(t, uint16(400), e.Iso)
+			assert.False(t, e.HasLocation)
+			_, found := e.Tags["GPSLatitude"]
+			assert.False(t, found)
+		}
+	}
+
+	// copyright only: no EXIF left but licence
+	metadata, err = Metadata(original, PolicyCopyrightOnly, "https://creativecommons.org/licenses/by/4.0/")
+	if assert.NoError(t, err) {
+		assert.False(t, bytes.Contains(metadata, []byte(exifHeader)))
+		assert.True(t, bytes.Contains(metadata, []byte(`cc:license="https://creativecommons.org/licenses/by/4.0/"`)))
+	}
+
+	// strip all
+	metadata, err = Metadata(original, PolicyStripAll, "https://creativecommons.org/licenses/by/4.0/")
+	if assert.NoError(t, err) {
+		assert.Len(t, metadata, 0)
+	}
+}
+
+func TestExif_Filter(t *testing.T) {
+	newExif := func() *Exif {
+		return &Exif{
+			Camera:      "SONY ILCE-7M2",
+			HasLocation: true,
+			Latitude:    48.8567,
+			Tags:        map[string]string{"Model": "ILCE-7M2", "Copyright": "me", "GPSLatitude": "48"},
+		}
+	}
+	e := newExif()
+	e.Filter(PolicyKeepAll)
+	assert.True(t, e.HasLocation)
+	assert.Len(t, e.Tags, 3)
+
+	e = newExif()
+	e.Filter(PolicyStripGPS)
+	assert.False(t, e.HasLocation)
+	assert.Equal(t, "SONY ILCE-7M2", e.Camera)
+	assert.Equal(t, map[string]string{"Model": "ILCE-7M2", "Copyright": "me"}, e.Tags)
+
+	e = newExif()
+	e.Filter(PolicyCopyrightOnly)
+	assert.Equal(t, "", e.Camera)
+	assert.Equal(t, map[string]string{"Copyright": "me"}, e.Tags)
+
+	e = newExif()
+	e.Filter(PolicyStripAll)
+	assert.Len(t, e.Tags, 0)
+}
+
+func TestPolicy_default(t *testing.T) {
+	// exact location is opt-in
+	var p Policy
+	assert.Equal(t, PolicyStripGPS, p)
+	assert.False(t, p.ExactLocation())
+	e := &Exif{HasLocation: true, Latitude: 48.8567, Tags: map[string]string{"GPSLatitude": "48"}}
+	e.Filter(p)
+	assert.False(t, e.HasLocation)
+	assert.Len(t, e.Tags, 0)
+}
@@ -0,0 +1,295 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"sort"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// ifdKind identifies the IFD of a tag
+type ifdKind int
+
+const (
+	ifd0 ifdKind = iota
+	ifdExif
+	ifdGPS
+)
+
+// pointer tags
+const (
+	exifPointer    = 0x8769
+	gpsPointer     = 0x8825
+	interopPointer = 0xA005
+)
+
+// droppedTags are never written: offsets which would be invalid once
+// rewritten, maker notes (proprietary, with absolute offsets),
+// orientation (published photos are normalised, see image.Normalize)
+// and image structure of TIFF originals
+var droppedTags = map[uint16]bool{
+	0x0100:         true, // ImageWidth
+	0x0101:         true, // ImageLength
+	0x0102:         true, // BitsPerSample
+	0x0103:         true, // Compression
+	0x0106:         true, // PhotometricInterpretation
+	0x0112:         true, // Orientation
+	0x0115:         true, // SamplesPerPixel
+	0x0116:         true, // RowsPerStrip
+	0x011C:         true, // PlanarConfiguration
+	0x013D:         true, // Predictor
+	0x0140:         true, // ColorMap
+	0x0142:         true, // TileWidth
+	0x0143:         true, // TileLength
+	0x0144:         true, // TileOffsets
+	0x0145:         true, // TileByteCounts
+	0x0152:         true, // ExtraSamples
+	0x0153:         true, // SampleFormat
+	0x8773:         true, // InterColorProfile (see image.ToSRGB)
+	exifPointer:    true,
+	gpsPointer:     true,
+	interopPointer: true,
+	0x0111:         true, // StripOffsets
+	0x0117:         true, // StripByteCounts
+	0x014A:         true, // SubIFDs
+	0x0201:         true, // JPEGInterchangeFormat
+	0x0202:         true, // JPEGInterchangeFormatLength
+	0x927C:         true, // MakerNote
+}
+
+const (
+	// exifHeader prefixes EXIF APP1 segments
+	exifHeader = "Exif\x00\x00"
+	// xmpHeader prefixes XMP APP1 segments
+	xmpHeader = "http://ns.adobe.com/xap/1.0/\x00"
+	// maxSegmentLength is the max length of a JPEG segment (length included)
+	maxSegmentLength = 0xFFFF
+)
+
+// xmpLicence is the XMP packet holding licence URL
+const xmpLicence = `<?xpacket begin="` + "\xef\xbb\xbf" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/" xmlns:cc="http://creativecommons.org/ns#" xmpRights:Marked="True" xmpRights:WebStatement="%[1]s" cc:license="%[1]s"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="r"?>`
+
+// Metadata returns the JPEG metadata segments (APP1 EXIF and XMP) to write
+// in photos published from original regarding policy p
+// licenceURL (if any) is written as XMP
+func Metadata(original []byte, p Policy, licenceURL string) ([]byte, error) {
+	if p == PolicyStripAll {
+		return nil, nil
+	}
+	segments := new(bytes.Buffer)
+
+	x, err := decode(original)
+	if err == nil || (x != nil && !goexif.IsCriticalError(err)) {
+		t, err := rebuildTiff(x, p)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			if err = writeSegment(segments, 0xE1, append([]byte(exifHeader), t...)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if licenceURL != "" {
+		xmp := fmt.Sprintf(xmpLicence, html.EscapeString(licenceURL))
+		if err = writeSegment(segments, 0xE1, append([]byte(xmpHeader), xmp...)); err != nil {
+			return nil, err
+		}
+	}
+	return segments.Bytes(), nil
+}
+
+// Segments returns metadata segments (APP1 EXIF and XMP, APP2 ICC profile)
+// of JPEG b
+func Segments(b []byte) []byte {
+	segments := new(bytes.Buffer)
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		// start of scan: no more metadata
+		if marker == 0xDA {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+		if end > len(b) {
+			break
+		}
+		if marker == 0xE1 || marker == 0xE2 {
+			segments.Write(b[i:end])
+		}
+		i = end
+	}
+	return segments.Bytes()
+}
+
+// Insert returns JPEG b with metadata segments inserted after SOI
+func Insert(b []byte, segments []byte) []byte {
+	if len(segments) == 0 || len(b) < 2 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(segments))
+	out = append(out, b[:2]...)
+	out = append(out, segments...)
+	return append(out, b[2:]...)
+}
+
+// writeSegment writes a JPEG segment with marker and data in w
+func writeSegment(w *bytes.Buffer, marker byte, data []byte) error {
+	if len(data)+2 > maxSegmentLength {
+		return fmt.Errorf("exif: segment too large (%d bytes)", len(data))
+	}
+	w.Write([]byte{0xFF, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(data)+2))
+	w.Write(data)
+	return nil
+}
+
+// rebuildTiff returns a TIFF holding tags of x kept by policy p
+// (nil if there is no tag to keep)
+// thumbnail (IFD1) and interoperability IFD are dropped
+func rebuildTiff(x *goexif.Exif, p Policy) ([]byte, error) {
+	if len(x.Tiff.Dirs) == 0 {
+		return nil, nil
+	}
+	dirs := map[ifdKind][]*tiff.Tag{
+		ifd0:    keptTags(x.Tiff.Dirs[0].Tags, ifd0, p),
+		ifdExif: keptTags(subDirTags(x, exifPointer), ifdExif, p),
+		ifdGPS:  keptTags(subDirTags(x, gpsPointer), ifdGPS, p),
+	}
+	if len(dirs[ifd0])+len(dirs[ifdExif])+len(dirs[ifdGPS]) == 0 {
+		return nil, nil
+	}
+
+	order := x.Tiff.Order
+	// sub-IFD pointers (offsets are set once IFD0 size is known)
+	var pointers []*tiff.Tag
+	for _, sub := range []struct {
+		kind ifdKind
+		id   uint16
+	}{{ifdExif, exifPointer}, {ifdGPS, gpsPointer}} {
+		if len(dirs[sub.kind]) != 0 {
+			pointer := &tiff.Tag{Id: sub.id, Type: tiff.DTLong, Count: 1, Val: make([]byte, 4)}
+			pointers = append(pointers, pointer)
+			dirs[ifd0] = append(dirs[ifd0], pointer)
+		}
+	}
+	sort.Slice(dirs[ifd0], func(i, j int) bool { return dirs[ifd0][i].Id < dirs[ifd0][j].Id })
+
+	// layout: header, IFD0, Exif IFD, GPS IFD
+	offset := 8
+	offsets := make(map[ifdKind]int)
+	for _, kind := range []ifdKind{ifd0, ifdExif, ifdGPS} {
+		if len(dirs[kind]) == 0 {
+			continue
+		}
+		offsets[kind] = offset
+		offset += ifdSize(dirs[kind])
+	}
+	for _, pointer := range pointers {
+		kind := ifdExif
+		if pointer.Id == gpsPointer {
+			kind = ifdGPS
+		}
+		order.PutUint32(pointer.Val, uint32(offsets[kind]))
+	}
+
+	buf := new(bytes.Buffer)
+	if order == binary.BigEndian {
+		buf.WriteString("MM")
+	} else {
+		buf.WriteString("II")
+	}
+	binary.Write(buf, order, uint16(42))
+	binary.Write(buf, order, uint32(8))
+	for _, kind := range []ifdKind{ifd0, ifdExif, ifdGPS} {
+		if len(dirs[kind]) != 0 {
+			writeIFD(buf, order, dirs[kind], offsets[kind])
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// subDirTags returns tags of the sub-IFD referenced by pointer
+func subDirTags(x *goexif.Exif, pointer uint16) []*tiff.Tag {
+	for _, tag := range x.Tiff.Dirs[0].Tags {
+		if tag.Id != pointer {
+			continue
+		}
+		offset, err := tag.Int64(0)
+		if err != nil {
+			return nil
+		}
+		r := bytes.NewReader(x.Raw)
+		if _, err = r.Seek(offset, 0); err != nil {
+			return nil
+		}
+		dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+		if err != nil {
+			return nil
+		}
+		return dir.Tags
+	}
+	return nil
+}
+
+// keptTags returns tags of IFD ifd kept by policy p
+func keptTags(tags []*tiff.Tag, ifd ifdKind, p Policy) []*tiff.Tag {
+	var kept []*tiff.Tag
+	for _, tag := range tags {
+		if !droppedTags[tag.Id] && p.keep(ifd, tag.Id) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// ifdSize returns the size of IFD holding tags (values included)
+func ifdSize(tags []*tiff.Tag) int {
+	size := 2 + 12*len(tags) + 4
+	for _, tag := range tags {
+		if len(tag.Val) > 4 {
+			size += len(tag.Val) + len(tag.Val)%2
+		}
+	}
+	return size
+}
+
+// writeIFD writes IFD holding tags at offset in buf
+// values larger than 4 bytes follow the IFD (word aligned)
+func writeIFD(buf *bytes.Buffer, order binary.ByteOrder, tags []*tiff.Tag, offset int) {
+	binary.Write(buf, order, uint16(len(tags)))
+	valueOffset := offset + 2 + 12*len(tags) + 4
+	values := new(bytes.Buffer)
+	for _, tag := range tags {
+		binary.Write(buf, order, tag.Id)
+		binary.Write(buf, order, uint16(tag.Type))
+		binary.Write(buf, order, tag.Count)
+		if len(tag.Val) > 4 {
+			binary.Write(buf, order, uint32(valueOffset+values.Len()))
+			values.Write(tag.Val)
+			if len(tag.Val)%2 != 0 {
+				values.WriteByte(0)
+			}
+			continue
+		}
+		val := make([]byte, 4)
+		copy(val, tag.Val)
+		buf.Write(val)
+	}
+	// no next IFD
+	binary.Write(buf, order, uint32(0))
+	buf.Write(values.Bytes())
+}
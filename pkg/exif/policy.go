@@ -0,0 +1,82 @@
+package exif
+
+import (
+	"strings"
+
+	goexif "github.com/rwcarlsen/goexif/exif"
+)
+
+// Policy defines which metadata are kept in published photos
+type Policy uint8
+
+// Policies
+// PolicyStripGPS is the zero value: exact location must be opted in
+const (
+	// PolicyStripGPS keeps all metadata but GPS ones
+	PolicyStripGPS Policy = iota
+	// PolicyKeepAll keeps all metadata (but maker notes and thumbnail)
+	PolicyKeepAll
+	// PolicyStripAll strips all metadata
+	PolicyStripAll
+	// PolicyCopyrightOnly keeps only artist, copyright and licence
+	PolicyCopyrightOnly
+)
+
+// copyrightTags are the tags kept by PolicyCopyrightOnly
+var copyrightTags = map[uint16]goexif.FieldName{
+	0x013B: goexif.Artist,
+	0x8298: goexif.Copyright,
+}
+
+// Valid returns true if p is a known policy
+func (p Policy) Valid() bool {
+	return p <= PolicyCopyrightOnly
+}
+
+// ExactLocation returns true if the exact location of photos
+// can be published (otherwise it must be rounded)
+func (p Policy) ExactLocation() bool {
+	return p == PolicyKeepAll
+}
+
+// keep returns true if tag id of IFD ifd is kept by policy p
+func (p Policy) keep(ifd ifdKind, id uint16) bool {
+	switch p {
+	case PolicyKeepAll:
+		return true
+	case PolicyStripGPS:
+		return ifd != ifdGPS
+	case PolicyCopyrightOnly:
+		_, found := copyrightTags[id]
+		return ifd == ifd0 && found
+	}
+	return false
+}
+
+// Filter removes metadata of e which are not kept by policy p
+func (e *Exif) Filter(p Policy) {
+	if p.ExactLocation() {
+		return
+	}
+	// location (coarse location should be set by hand)
+	e.HasLocation = false
+	e.Latitude, e.Longitude = 0, 0
+	for name := range e.Tags {
+		switch p {
+		case PolicyStripGPS:
+			if strings.HasPrefix(name, "GPS") {
+				delete(e.Tags, name)
+			}
+		case PolicyCopyrightOnly:
+			if name != string(goexif.Artist) && name != string(goexif.Copyright) {
+				delete(e.Tags, name)
+			}
+		default:
+			delete(e.Tags, name)
+		}
+	}
+	// camera, lens, settings and date
+	if p != PolicyStripGPS {
+		*e = Exif{Tags: e.Tags}
+	}
+}
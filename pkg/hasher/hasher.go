@@ -2,6 +2,7 @@ package hasher
 
 import (
 	"crypto/sha256"
+	"strings"
 
 	"github.com/shengdoushi/base58"
 )
@@ -15,3 +16,20 @@ func GetHash(data []byte) (string, error) {
 	}
 	return base58.Encode(h.Sum(nil), base58.IPFSAlphabet), nil
 }
+
+// alphabet is the base58 alphabet of hashes
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// IsHash returns true if s looks like a hash returned by GetHash
+func IsHash(s string) bool {
+	// 32 bytes are encoded in 44 chars at most
+	if len(s) < 32 || len(s) > 44 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(alphabet, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -17,3 +17,12 @@ func TestHasher(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J", hashed)
 }
+
+func TestIsHash(t *testing.T) {
+	assert.True(t, IsHash("2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J"))
+	assert.False(t, IsHash("2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J_m"))
+	assert.False(t, IsHash("2DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J.webp"))
+	// 0 is not in base58 alphabet
+	assert.False(t, IsHash("0DJLYuo9ky9CfThuGK2DU82dvENtJr8BzX7kmGkoad4J"))
+	assert.False(t, IsHash("peerpxKey"))
+}
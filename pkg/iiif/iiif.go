@@ -0,0 +1,349 @@
+// Package iiif implements the IIIF Image API 3.0
+// https://iiif.io/api/image/3.0/
+package iiif
+
+import (
+	"fmt"
+	imageStd "image"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/peerpx/peerpx/pkg/image"
+)
+
+// Qualities
+const (
+	QualityDefault = "default"
+	QualityColor   = "color"
+	QualityGray    = "gray"
+	QualityBitonal = "bitonal"
+)
+
+// qualities are the supported qualities
+var qualities = map[string]bool{
+	QualityDefault: true,
+	QualityColor:   true,
+	QualityGray:    true,
+	QualityBitonal: true,
+}
+
+// Formats are the supported formats by IIIF extension
+var Formats = map[string]string{
+	"jpg": image.FormatJPEG,
+	"png": image.FormatPNG,
+}
+
+// knownFormats are the formats defined by the specification
+// (those which are not supported are not implemented)
+var knownFormats = map[string]bool{
+	"jpg":  true,
+	"tif":  true,
+	"png":  true,
+	"gif":  true,
+	"jp2":  true,
+	"pdf":  true,
+	"webp": true,
+}
+
+// Error is an IIIF request error
+type Error struct {
+	Status  int // HTTP status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// badRequest returns a 400 Bad Request error
+func badRequest(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// Region is the region parameter of an image request
+type Region struct {
+	Full   bool
+	Square bool
+	Pct    bool    // X, Y, W, H are percents
+	X, Y   float64 // top left corner
+	W, H   float64
+}
+
+// Size is the size parameter of an image request
+type Size struct {
+	Max      bool
+	Upscale  bool    // ^
+	Confined bool    // !w,h
+	Pct      float64 // pct:n (0 if not set)
+	W, H     int     // 0 if not set
+}
+
+// Request is an IIIF image request
+// {region}/{size}/{rotation}/{quality}.{format}
+type Request struct {
+	Region   Region
+	Size     Size
+	Mirror   bool
+	Rotation float64 // clockwise, in degrees
+	Quality  string
+	Format   string // image format (see Formats)
+}
+
+// ParseRequest parses the parameters of an image request
+func ParseRequest(region, size, rotation, file string) (*Request, error) {
+	r := new(Request)
+	var err error
+	if r.Region, err = ParseRegion(region); err != nil {
+		return nil, err
+	}
+	if r.Size, err = ParseSize(size); err != nil {
+		return nil, err
+	}
+	if r.Mirror, r.Rotation, err = ParseRotation(rotation); err != nil {
+		return nil, err
+	}
+
+	dot := strings.LastIndex(file, ".")
+	if dot == -1 {
+		return nil, badRequest("invalid quality and format %q", file)
+	}
+	r.Quality = file[:dot]
+	if !qualities[r.Quality] {
+		return nil, badRequest("invalid quality %q", r.Quality)
+	}
+	extension := file[dot+1:]
+	var found bool
+	if r.Format, found = Formats[extension]; !found {
+		if knownFormats[extension] {
+			return nil, &Error{Status: http.StatusNotImplemented, Message: fmt.Sprintf("format %s is not supported", extension)}
+		}
+		return nil, badRequest("invalid format %q", extension)
+	}
+	return r, nil
+}
+
+// ParseRegion parses region parameter
+// full, square, x,y,w,h or pct:x,y,w,h
+func ParseRegion(s string) (Region, error) {
+	switch s {
+	case "full":
+		return Region{Full: true}, nil
+	case "square":
+		return Region{Square: true}, nil
+	}
+	r := Region{}
+	if strings.HasPrefix(s, "pct:") {
+		r.Pct = true
+		s = s[4:]
+	}
+	values, err := parseFloats(s, 4)
+	if err != nil {
+		return r, badRequest("invalid region %q", s)
+	}
+	r.X, r.Y, r.W, r.H = values[0], values[1], values[2], values[3]
+	if !r.Pct && (r.X != math.Trunc(r.X) || r.Y != math.Trunc(r.Y) || r.W != math.Trunc(r.W) || r.H != math.Trunc(r.H)) {
+		return r, badRequest("invalid region %q", s)
+	}
+	if r.W == 0 || r.H == 0 {
+		return r, badRequest("region %q is empty", s)
+	}
+	return r, nil
+}
+
+// ParseSize parses size parameter
+// [^]max, [^]w,, [^],h, [^]pct:n, [^]w,h or [^]!w,h
+func ParseSize(s string) (Size, error) {
+	size := Size{}
+	param := s
+	if strings.HasPrefix(s, "^") {
+		size.Upscale = true
+		s = s[1:]
+	}
+	switch {
+	case s == "max":
+		size.Max = true
+		return size, nil
+	case strings.HasPrefix(s, "pct:"):
+		pct, err := strconv.ParseFloat(s[4:], 64)
+		if err != nil || pct <= 0 || math.IsInf(pct, 0) || (pct > 100 && !size.Upscale) {
+			return size, badRequest("invalid size %q", param)
+		}
+		size.Pct = pct
+		return size, nil
+	case strings.HasPrefix(s, "!"):
+		size.Confined = true
+		s = s[1:]
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 || (parts[0] == "" && parts[1] == "") || (size.Confined && (parts[0] == "" || parts[1] == "")) {
+		return size, badRequest("invalid size %q", param)
+	}
+	var err error
+	if parts[0] != "" {
+		if size.W, err = strconv.Atoi(parts[0]); err != nil || size.W <= 0 {
+			return size, badRequest("invalid size %q", param)
+		}
+	}
+	if parts[1] != "" {
+		if size.H, err = strconv.Atoi(parts[1]); err != nil || size.H <= 0 {
+			return size, badRequest("invalid size %q", param)
+		}
+	}
+	return size, nil
+}
+
+// ParseRotation parses rotation parameter
+// n or !n (mirrored), n in [0, 360]
+func ParseRotation(s string) (mirror bool, degrees float64, err error) {
+	if strings.HasPrefix(s, "!") {
+		mirror = true
+		s = s[1:]
+	}
+	degrees, err = strconv.ParseFloat(s, 64)
+	if err != nil || strings.HasPrefix(s, "+") || !(degrees >= 0 && degrees <= 360) {
+		return false, 0, badRequest("invalid rotation %q", s)
+	}
+	return mirror, degrees, nil
+}
+
+// parseFloats parses n comma separated positive floats
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%d values expected", n)
+	}
+	values := make([]float64, n)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || strings.HasPrefix(part, "+") || !(v >= 0) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid value %q", part)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Transform is an image request resolved for an image
+type Transform struct {
+	Region        imageStd.Rectangle // in image coordinates
+	Width, Height int                // output size (before rotation)
+	Mirror        bool
+	Rotation      float64
+	Quality       string
+}
+
+// Transform resolves r for a width x height image
+// output size is limited to maxArea pixels (0: no limit)
+func (r *Request) Transform(width, height, maxArea int) (*Transform, error) {
+	t := &Transform{Mirror: r.Mirror, Rotation: r.Rotation, Quality: r.Quality}
+	var err error
+	if t.Region, err = r.Region.rect(width, height); err != nil {
+		return nil, err
+	}
+	if t.Width, t.Height, err = r.Size.dimensions(t.Region.Dx(), t.Region.Dy(), maxArea); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// String returns the canonical form of t
+// x_y_w_h_width_height_rotation_quality (rotation is prefixed by m if mirrored)
+// it only contains chars usable in file names
+func (t *Transform) String() string {
+	rotation := strconv.FormatFloat(t.Rotation, 'f', -1, 64)
+	if t.Mirror {
+		rotation = "m" + rotation
+	}
+	return fmt.Sprintf("%d_%d_%d_%d_%d_%d_%s_%s", t.Region.Min.X, t.Region.Min.Y, t.Region.Dx(), t.Region.Dy(), t.Width, t.Height, rotation, t.Quality)
+}
+
+// FullRegion returns true if t keeps the whole width x height image
+func (t *Transform) FullRegion(width, height int) bool {
+	return t.Region == imageStd.Rect(0, 0, width, height)
+}
+
+// rect returns region of a width x height image
+func (r Region) rect(width, height int) (imageStd.Rectangle, error) {
+	bounds := imageStd.Rect(0, 0, width, height)
+	switch {
+	case r.Full:
+		return bounds, nil
+	case r.Square:
+		side := width
+		if height < side {
+			side = height
+		}
+		x, y := (width-side)/2, (height-side)/2
+		return imageStd.Rect(x, y, x+side, y+side), nil
+	}
+	x, y, w, h := r.X, r.Y, r.W, r.H
+	if r.Pct {
+		x, y = x*float64(width)/100, y*float64(height)/100
+		w, h = w*float64(width)/100, h*float64(height)/100
+	}
+	rect := imageStd.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h))).Intersect(bounds)
+	if rect.Empty() {
+		return rect, badRequest("region is outside of image")
+	}
+	return rect, nil
+}
+
+// dimensions returns output size of a width x height region
+func (s Size) dimensions(width, height, maxArea int) (int, int, error) {
+	w, h := float64(width), float64(height)
+	switch {
+	case s.Max:
+		scale := 1.0
+		if maxArea > 0 && (s.Upscale || width*height > maxArea) {
+			scale = math.Sqrt(float64(maxArea) / (w * h))
+		}
+		// never exceed maxArea because of rounding
+		w, h = math.Floor(w*scale), math.Floor(h*scale)
+	case s.Pct != 0:
+		w, h = math.Round(w*s.Pct/100), math.Round(h*s.Pct/100)
+	case s.Confined:
+		scale := math.Min(float64(s.W)/w, float64(s.H)/h)
+		w, h = math.Min(math.Round(w*scale), float64(s.W)), math.Min(math.Round(h*scale), float64(s.H))
+	case s.H == 0:
+		w, h = float64(s.W), math.Round(h*float64(s.W)/w)
+	case s.W == 0:
+		w, h = math.Round(w*float64(s.H)/h), float64(s.H)
+	default:
+		w, h = float64(s.W), float64(s.H)
+	}
+
+	if w < 1 || h < 1 {
+		return 0, 0, badRequest("size is empty")
+	}
+	if !s.Upscale && (w > float64(width) || h > float64(height)) {
+		return 0, 0, badRequest("size %.0fx%.0f is larger than region %dx%d (use ^ to upscale)", w, h, width, height)
+	}
+	if maxArea > 0 && w*h > float64(maxArea) {
+		return 0, 0, badRequest("size %.0fx%.0f is larger than max area %d", w, h, maxArea)
+	}
+	return int(w), int(h), nil
+}
+
+// Apply applies t to img
+// if t keeps the whole image (see FullRegion), img may be a copy of the
+// image t was resolved for already scaled to t.Width x t.Height
+func (t *Transform) Apply(img *image.Image) error {
+	if err := img.Crop(t.Region.Min.X, t.Region.Min.Y, t.Region.Dx(), t.Region.Dy()); err != nil {
+		return badRequest(err.Error())
+	}
+	img.Scale(t.Width, t.Height)
+	if t.Mirror {
+		img.Mirror()
+	}
+	img.Rotate(t.Rotation)
+	switch t.Quality {
+	case QualityGray:
+		img.Grayscale()
+	case QualityBitonal:
+		img.Bitonal()
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package iiif
+
+import (
+	"bytes"
+	imageStd "image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/peerpx/peerpx/pkg/image"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequest(t *testing.T) {
+	r, err := ParseRequest("pct:10,20.5,30,40", "^!200,100", "!90", "gray.png")
+	if assert.NoError(t, err) {
+		assert.Equal(t, Region{Pct: true, X: 10, Y: 20.5, W: 30, H: 40}, r.Region)
+		assert.Equal(t, Size{Upscale: true, Confined: true, W: 200, H: 100}, r.Size)
+		assert.True(t, r.Mirror)
+		assert.Equal(t, 90.0, r.Rotation)
+		assert.Equal(t, QualityGray, r.Quality)
+		assert.Equal(t, image.FormatPNG, r.Format)
+	}
+
+	valid := [][4]string{
+		{"full", "max", "0", "default.jpg"},
+		{"square", "^max", "360", "color.png"},
+		{"0,0,10,10", "10,", "22.5", "bitonal.jpg"},
+		{"full", ",10", "0", "default.jpg"},
+		{"full", "pct:50", "0", "default.jpg"},
+		{"full", "^pct:150", "0", "default.jpg"},
+		{"full", "10,20", "0", "default.jpg"},
+	}
+	for _, params := range valid {
+		_, err = ParseRequest(params[0], params[1], params[2], params[3])
+		assert.NoError(t, err, params)
+	}
+
+	invalid := [][4]string{
+		{"nope", "max", "0", "default.jpg"},
+		{"0,0,10", "max", "0", "default.jpg"},
+		{"0,0,0,10", "max", "0", "default.jpg"},
+		{"0,0,10.5,10", "max", "0", "default.jpg"},
+		{"-1,0,10,10", "max", "0", "default.jpg"},
+		{"full", "pct:150", "0", "default.jpg"},
+		{"full", ",", "0", "default.jpg"},
+		{"full", "!10,", "0", "default.jpg"},
+		{"full", "0,", "0", "default.jpg"},
+		{"full", "max", "361", "default.jpg"},
+		{"full", "max", "-90", "default.jpg"},
+		{"full", "max", "0", "best.jpg"},
+		{"full", "max", "0", "default"},
+		{"full", "max", "0", "default.exe"},
+	}
+	for _, params := range invalid {
+		_, err = ParseRequest(params[0], params[1], params[2], params[3])
+		if assert.Error(t, err, params) {
+			assert.Equal(t, http.StatusBadRequest, err.(*Error).Status, params)
+		}
+	}
+
+	// known but unsupported format
+	for _, format := range []string{"jp2", "webp"} {
+		_, err = ParseRequest("full", "max", "0", "default."+format)
+		if assert.Error(t, err) {
+			assert.Equal(t, http.StatusNotImplemented, err.(*Error).Status)
+		}
+	}
+}
+
+func TestRequest_Transform(t *testing.T) {
+	tests := []struct {
+		region, size string
+		rect         imageStd.Rectangle
+		w, h         int
+	}{
+		{"full", "max", imageStd.Rect(0, 0, 400, 200), 400, 200},
+		{"square", "max", imageStd.Rect(100, 0, 300, 200), 200, 200},
+		{"300,100,200,200", "max", imageStd.Rect(300, 100, 400, 200), 100, 100},
+		{"pct:50,50,50,50", "max", imageStd.Rect(200, 100, 400, 200), 200, 100},
+		{"full", "100,", imageStd.Rect(0, 0, 400, 200), 100, 50},
+		{"full", ",50", imageStd.Rect(0, 0, 400, 200), 100, 50},
+		{"full", "pct:25", imageStd.Rect(0, 0, 400, 200), 100, 50},
+		{"full", "!100,100", imageStd.Rect(0, 0, 400, 200), 100, 50},
+		{"full", "100,100", imageStd.Rect(0, 0, 400, 200), 100, 100},
+		{"full", "^800,", imageStd.Rect(0, 0, 400, 200), 800, 400},
+		// limited to max area
+		{"full", "^max", imageStd.Rect(0, 0, 400, 200), 1414, 707},
+	}
+	for _, test := range tests {
+		r, err := ParseRequest(test.region, test.size, "0", "default.jpg")
+		if err != nil {
+			panic(err)
+		}
+		tr, err := r.Transform(400, 200, 1000000)
+		if assert.NoError(t, err, test.region+" "+test.size) {
+			assert.Equal(t, test.rect, tr.Region, test.region+" "+test.size)
+			assert.Equal(t, test.w, tr.Width, test.region+" "+test.size)
+			assert.Equal(t, test.h, tr.Height, test.region+" "+test.size)
+		}
+	}
+
+	for _, params := range [][2]string{
+		// outside of image
+		{"400,0,10,10", "max"},
+		// upscaling
+		{"full", "800,"},
+		{"full", "!800,800"},
+		// larger than max area
+		{"full", "^2000,"},
+	} {
+		r, err := ParseRequest(params[0], params[1], "0", "default.jpg")
+		if err != nil {
+			panic(err)
+		}
+		_, err = r.Transform(400, 200, 1000000)
+		assert.Error(t, err, params)
+	}
+}
+
+func TestTransform_Apply(t *testing.T) {
+	src := imageStd.NewRGBA(imageStd.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			// left half red, right half blue
+			if x < 20 {
+				src.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				src.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, src); err != nil {
+		panic(err)
+	}
+
+	r, err := ParseRequest("full", "20,", "!90", "default.png")
+	if err != nil {
+		panic(err)
+	}
+	tr, err := r.Transform(40, 20, 0)
+	if err != nil {
+		panic(err)
+	}
+	img, err := image.NewFromBytes(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	if assert.NoError(t, tr.Apply(img)) {
+		assert.Equal(t, 10, img.Width())
+		assert.Equal(t, 20, img.Height())
+		b, err := img.PNG()
+		if err != nil {
+			panic(err)
+		}
+		out, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			panic(err)
+		}
+		// mirrored (blue on the left) then rotated clockwise: blue on top
+		top := color.RGBAModel.Convert(out.At(5, 2)).(color.RGBA)
+		bottom := color.RGBAModel.Convert(out.At(5, 17)).(color.RGBA)
+		assert.True(t, top.B > 200 && top.R < 50)
+		assert.True(t, bottom.R > 200 && bottom.B < 50)
+	}
+
+	// full region of a smaller copy
+	assert.True(t, tr.FullRegion(40, 20))
+}
+
+func TestNewInfo(t *testing.T) {
+	info := NewInfo("https://peerpx.com/iiif/3/hash", 400, 200, 0)
+	info.AddSize(200, 100)
+	assert.Equal(t, Context, info.Context)
+	assert.Equal(t, "level2", info.Profile)
+	assert.Equal(t, []InfoSize{{Type: "Size", Width: 200, Height: 100}}, info.Sizes)
+}
@@ -0,0 +1,66 @@
+package iiif
+
+// Context is the JSON-LD context of IIIF Image API 3.0
+const Context = "http://iiif.io/api/image/3/context.json"
+
+// InfoContentType is the content type of info.json requested as JSON-LD
+const InfoContentType = `application/ld+json;profile="` + Context + `"`
+
+// Info is the image information document (info.json)
+type Info struct {
+	Context        string     `json:"@context"`
+	ID             string     `json:"id"`
+	Type           string     `json:"type"`
+	Protocol       string     `json:"protocol"`
+	Profile        string     `json:"profile"`
+	Width          int        `json:"width"`
+	Height         int        `json:"height"`
+	MaxArea        int        `json:"maxArea,omitempty"`
+	Sizes          []InfoSize `json:"sizes,omitempty"`
+	Tiles          []InfoTile `json:"tiles,omitempty"`
+	ExtraQualities []string   `json:"extraQualities"`
+	ExtraFormats   []string   `json:"extraFormats,omitempty"`
+	ExtraFeatures  []string   `json:"extraFeatures"`
+}
+
+// InfoSize is a preferred size of an image
+type InfoSize struct {
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// InfoTile is a set of tiles available for scale factors
+type InfoTile struct {
+	Type         string `json:"type"`
+	Width        int    `json:"width"`
+	ScaleFactors []int  `json:"scaleFactors"`
+}
+
+// NewInfo returns information of image id (its base URI)
+// implemented features are those of level 2 plus mirroring,
+// arbitrary rotation and upscaling
+func NewInfo(id string, width, height, maxArea int) *Info {
+	return &Info{
+		Context:        Context,
+		ID:             id,
+		Type:           "ImageService3",
+		Protocol:       "http://iiif.io/api/image",
+		Profile:        "level2",
+		Width:          width,
+		Height:         height,
+		MaxArea:        maxArea,
+		ExtraQualities: []string{QualityColor, QualityGray, QualityBitonal},
+		ExtraFeatures:  []string{"mirroring", "rotationArbitrary", "sizeUpscaling"},
+	}
+}
+
+// AddSize adds a preferred size to info
+func (i *Info) AddSize(width, height int) {
+	i.Sizes = append(i.Sizes, InfoSize{Type: "Size", Width: width, Height: height})
+}
+
+// AddTiles adds square tiles of width available for scaleFactors to info
+func (i *Info) AddTiles(width int, scaleFactors []int) {
+	i.Tiles = append(i.Tiles, InfoTile{Type: "Tile", Width: width, ScaleFactors: scaleFactors})
+}
@@ -0,0 +1,131 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+	FormatHEIF = "heif"
+	FormatAVIF = "avif"
+	FormatJXL  = "jxl"
+)
+
+// contentTypes are the content types of known formats
+var contentTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatGIF:  "image/gif",
+	FormatBMP:  "image/bmp",
+	FormatTIFF: "image/tiff",
+	FormatWebP: "image/webp",
+	FormatHEIF: "image/heif",
+	FormatAVIF: "image/avif",
+	FormatJXL:  "image/jxl",
+}
+
+// supportedFormats are the formats which can be decoded
+// (a decoder is registered for each of them)
+var supportedFormats = map[string]bool{
+	FormatJPEG: true,
+	FormatPNG:  true,
+	FormatGIF:  true,
+	FormatBMP:  true,
+	FormatTIFF: true,
+	FormatWebP: true,
+}
+
+// heifBrands are the ftyp brands of HEIF (HEIC) and AVIF containers
+var heifBrands = map[string]string{
+	"heic": FormatHEIF,
+	"heix": FormatHEIF,
+	"heim": FormatHEIF,
+	"heis": FormatHEIF,
+	"hevc": FormatHEIF,
+	"hevx": FormatHEIF,
+	"mif1": FormatHEIF,
+	"msf1": FormatHEIF,
+	"avif": FormatAVIF,
+	"avis": FormatAVIF,
+}
+
+// ErrUnsupportedFormat is returned when a photo format can't be decoded
+type ErrUnsupportedFormat struct {
+	Format string // detected format ("" if unknown)
+}
+
+func (e ErrUnsupportedFormat) Error() string {
+	if e.Format == "" {
+		return "unknown photo format"
+	}
+	return fmt.Sprintf("unsupported photo format %s", e.Format)
+}
+
+// DetectFormat returns the format of b sniffed from its magic bytes
+// ("" if unknown)
+func DetectFormat(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return FormatTIFF
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return FormatWebP
+	case bytes.HasPrefix(b, []byte{0xFF, 0x0A}), bytes.HasPrefix(b, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return FormatJXL
+	case len(b) >= 14 && string(b[:2]) == "BM" && binary.LittleEndian.Uint32(b[6:]) == 0:
+		// reserved fields are always 0
+		return FormatBMP
+	}
+	return detectISOBMFF(b)
+}
+
+// detectISOBMFF returns the format of ISO base media file b (HEIF, AVIF)
+// regarding the brands of its ftyp box ("" if unknown)
+func detectISOBMFF(b []byte) string {
+	if len(b) < 16 || string(b[4:8]) != "ftyp" {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(b))
+	if size < 16 || size > len(b) {
+		size = len(b)
+	}
+	// major brand, minor version, compatible brands
+	brands := []string{string(b[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(b[i:i+4]))
+	}
+	// AVIF files are also mif1
+	format := ""
+	for _, brand := range brands {
+		switch heifBrands[brand] {
+		case FormatAVIF:
+			return FormatAVIF
+		case FormatHEIF:
+			format = FormatHEIF
+		}
+	}
+	return format
+}
+
+// IsSupported returns true if photos of format can be decoded
+func IsSupported(format string) bool {
+	return supportedFormats[format]
+}
+
+// ContentType returns the content type of format ("" if unknown)
+func ContentType(format string) string {
+	return contentTypes[format]
+}
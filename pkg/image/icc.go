@@ -0,0 +1,423 @@
+package image
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// ErrUnsupportedProfile is returned for ICC profiles which are not RGB
+// matrix/TRC profiles (LUT based, CMYK, ...)
+var ErrUnsupportedProfile = errors.New("unsupported ICC profile")
+
+// iccHeader prefixes ICC profile chunks in JPEG APP2 segments
+const iccHeader = "ICC_PROFILE\x00"
+
+// srgbToXYZ is the sRGB to XYZ (D50, Bradford adapted) matrix
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// iccProfile is a RGB matrix/TRC ICC profile
+type iccProfile struct {
+	toXYZ [3][3]float64            // linear RGB to XYZ (D50)
+	trc   [3]func(float64) float64 // tone response curves (encoded -> linear)
+}
+
+// parseICC parses ICC profile b
+func parseICC(b []byte) (*iccProfile, error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" {
+		return nil, errors.New("invalid ICC profile")
+	}
+	if string(b[16:20]) != "RGB " {
+		return nil, ErrUnsupportedProfile
+	}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(b[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(b); i++ {
+		entry := b[132+12*i:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset+size > len(b) || size < 8 {
+			return nil, errors.New("invalid ICC profile tag table")
+		}
+		tags[string(entry[:4])] = b[offset : offset+size]
+	}
+
+	p := new(iccProfile)
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, found := tags[sig]
+		if !found || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, ErrUnsupportedProfile
+		}
+		for r := 0; r < 3; r++ {
+			p.toXYZ[r][c] = s15Fixed16(xyz[8+4*r:])
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		trc, err := parseCurve(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		p.trc[c] = trc
+	}
+	return p, nil
+}
+
+// s15Fixed16 decodes a s15Fixed16Number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseCurve parses a curv or para tag
+func parseCurve(b []byte) (func(float64) float64, error) {
+	if len(b) < 12 {
+		return nil, ErrUnsupportedProfile
+	}
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if len(b) < 12+2*n {
+			return nil, ErrUnsupportedProfile
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+
+	case "para":
+		nParams := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		kind := binary.BigEndian.Uint16(b[8:])
+		n, found := nParams[kind]
+		if !found || len(b) < 12+4*n {
+			return nil, ErrUnsupportedProfile
+		}
+		var params [7]float64
+		for i := 0; i < n; i++ {
+			params[i] = s15Fixed16(b[12+4*i:])
+		}
+		g, a, bb, c, d, e, f := params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+		return func(v float64) float64 {
+			switch kind {
+			case 1:
+				if v >= -bb/a {
+					return math.Pow(a*v+bb, g)
+				}
+				return 0
+			case 2:
+				if v >= -bb/a {
+					return math.Pow(a*v+bb, g) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+bb, g)
+				}
+				return c * v
+			case 4:
+				if v >= d {
+					return math.Pow(a*v+bb, g) + e
+				}
+				return c*v + f
+			}
+			return math.Pow(v, g)
+		}, nil
+	}
+	return nil, ErrUnsupportedProfile
+}
+
+// srgbToLinear is the sRGB tone response curve (encoded -> linear)
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB is the inverse sRGB tone response curve (linear -> encoded)
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// toSRGB returns the matrix converting linear RGB of profile p to linear sRGB
+func (p *iccProfile) toSRGB() [3][3]float64 {
+	return mul(invert(srgbToXYZ), p.toXYZ)
+}
+
+// isSRGB returns true if profile p is (close to) sRGB
+func (p *iccProfile) isSRGB() bool {
+	m := p.toSRGB()
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			expected := 0.0
+			if r == c {
+				expected = 1
+			}
+			if math.Abs(m[r][c]-expected) > 0.01 {
+				return false
+			}
+		}
+	}
+	for _, v := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+		for c := 0; c < 3; c++ {
+			if math.Abs(p.trc[c](v)-srgbToLinear(v)) > 0.01 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mul returns a*b
+func mul(a, b [3][3]float64) (m [3][3]float64) {
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				m[r][c] += a[r][k] * b[k][c]
+			}
+		}
+	}
+	return
+}
+
+// invert returns the inverse of m
+func invert(m [3][3]float64) (inv [3][3]float64) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return
+}
+
+// iccFromJPEG returns the ICC profile embedded in JPEG b (APP2 chunks)
+func iccFromJPEG(b []byte) []byte {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil
+	}
+	chunks := make(map[byte][]byte)
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		if marker == 0xDA {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+		if end > len(b) {
+			break
+		}
+		data := b[i+4 : end]
+		if marker == 0xE2 && len(data) > len(iccHeader)+2 && string(data[:len(iccHeader)]) == iccHeader {
+			// sequence number (from 1), chunks count
+			chunks[data[len(iccHeader)]] = data[len(iccHeader)+2:]
+		}
+		i = end
+	}
+	var profile []byte
+	for seq := byte(1); int(seq) <= len(chunks); seq++ {
+		chunk, found := chunks[seq]
+		if !found {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+// iccFromPNG returns the ICC profile embedded in PNG b (iCCP chunk)
+func iccFromPNG(b []byte) []byte {
+	if len(b) < 8 || string(b[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil
+	}
+	for i := 8; i+12 <= len(b); {
+		length := int(binary.BigEndian.Uint32(b[i:]))
+		kind := string(b[i+4 : i+8])
+		if i+12+length > len(b) || kind == "IDAT" {
+			return nil
+		}
+		if kind == "iCCP" {
+			data := b[i+8 : i+8+length]
+			// name, null separator, compression method
+			name := bytes.IndexByte(data, 0)
+			if name < 0 || name+2 > len(data) {
+				return nil
+			}
+			r, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+			if err != nil {
+				return nil
+			}
+			defer r.Close()
+			profile, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// iccFromTIFF returns the ICC profile embedded in TIFF b
+// (InterColorProfile tag of the first IFD)
+func iccFromTIFF(b []byte) []byte {
+	t, err := tiff.Decode(bytes.NewReader(b))
+	if err != nil || len(t.Dirs) == 0 {
+		return nil
+	}
+	for _, tag := range t.Dirs[0].Tags {
+		if tag.Id == 0x8773 {
+			return tag.Val
+		}
+	}
+	return nil
+}
+
+// iccFromWebP returns the ICC profile embedded in WebP b (ICCP chunk)
+func iccFromWebP(b []byte) []byte {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return nil
+	}
+	for i := 12; i+8 <= len(b); {
+		size := int(binary.LittleEndian.Uint32(b[i+4:]))
+		if size < 0 || i+8+size > len(b) {
+			return nil
+		}
+		if string(b[i:i+4]) == "ICCP" {
+			return b[i+8 : i+8+size]
+		}
+		// chunks are padded to even size
+		i += 8 + size + size%2
+	}
+	return nil
+}
+
+// sRGB profile (built once)
+var (
+	srgbProfile     []byte
+	srgbProfileOnce sync.Once
+)
+
+// SRGBProfile returns a sRGB ICC profile (v2, matrix/TRC)
+func SRGBProfile() []byte {
+	srgbProfileOnce.Do(func() {
+		srgbProfile = buildProfile("sRGB", srgbToXYZ)
+	})
+	return srgbProfile
+}
+
+// SRGBProfileSegment returns the JPEG APP2 segment holding the sRGB ICC profile
+func SRGBProfileSegment() []byte {
+	profile := SRGBProfile()
+	data := append([]byte(iccHeader), 1, 1)
+	data = append(data, profile...)
+	segment := []byte{0xFF, 0xE2, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+// buildProfile returns a RGB display ICC profile (v2) with primaries toXYZ
+// and sRGB tone response curves
+func buildProfile(description string, toXYZ [3][3]float64) []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := make([]byte, 20)
+		copy(b, "XYZ ")
+		for i, v := range []float64{x, y, z} {
+			binary.BigEndian.PutUint32(b[8+4*i:], uint32(int32(math.Floor(v*65536+0.5))))
+		}
+		return b
+	}
+	// desc: ascii, (empty) unicode and scriptcode descriptions
+	desc := make([]byte, 12, 12+len(description)+1+8+3+67)
+	copy(desc, "desc")
+	binary.BigEndian.PutUint32(desc[8:], uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, make([]byte, 1+8+3+67)...)
+	cprt := append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)
+	curve := make([]byte, 12+2*1024)
+	copy(curve, "curv")
+	binary.BigEndian.PutUint32(curve[8:], 1024)
+	for i := 0; i < 1024; i++ {
+		binary.BigEndian.PutUint16(curve[12+2*i:], uint16(math.Floor(srgbToLinear(float64(i)/1023)*65535+0.5)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(toXYZ[0][0], toXYZ[1][0], toXYZ[2][0])},
+		{"gXYZ", xyz(toXYZ[0][1], toXYZ[1][1], toXYZ[2][1])},
+		{"bXYZ", xyz(toXYZ[0][2], toXYZ[1][2], toXYZ[2][2])},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// header, tag table, tag data (4 bytes aligned, TRC is shared)
+	table := new(bytes.Buffer)
+	data := new(bytes.Buffer)
+	dataOffset := 128 + 4 + 12*len(tags)
+	offsets := make(map[*byte]int)
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		offset, found := offsets[&tag.data[0]]
+		if !found {
+			offset = dataOffset + data.Len()
+			offsets[&tag.data[0]] = offset
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.sig)
+		binary.Write(table, binary.BigEndian, uint32(offset))
+		binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(dataOffset+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:])
+
+	profile := append(header, table.Bytes()...)
+	return append(profile, data.Bytes()...)
+}
@@ -4,30 +4,98 @@ import (
 	"bytes"
 	"errors"
 	imageStd "image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
 	"image/jpeg"
 	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
 	"io/ioutil"
+	"math"
 
 	"io"
 
 	"github.com/disintegration/gift"
+	"github.com/peerpx/peerpx/pkg/exif"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 // Image is used for image manipulation
 type Image struct {
-	image  imageStd.Image
-	format string
+	image       imageStd.Image
+	format      string
+	orientation int    // EXIF orientation (1: normal)
+	icc         []byte // embedded ICC profile
 }
 
 var (
 	ErrUpscaleNotAllowed = errors.New("upscaling is not allowed")
+	ErrEmptyRegion       = errors.New("region is outside of image")
+	ErrTooLarge          = errors.New("image has too many pixels")
 )
 
+// MaxPixels is the max number of pixels (width x height) of images decoded
+// by New (0: no limit)
+// images are decoded in memory: a small file may declare huge dimensions
+var MaxPixels = 100000000
+
+// orientationFilters are the transformations to apply regarding
+// EXIF orientation
+var orientationFilters = map[int]gift.Filter{
+	2: gift.FlipHorizontal(),
+	3: gift.Rotate180(),
+	4: gift.FlipVertical(),
+	5: gift.Transpose(),
+	6: gift.Rotate270(),
+	7: gift.Transverse(),
+	8: gift.Rotate90(),
+}
+
 // New returns image from io.Reader
+// format is sniffed from magic bytes (see DetectFormat), ErrUnsupportedFormat
+// is returned for formats which can't be decoded
+// only the first frame of animated GIF is kept
+// ErrTooLarge is returned for images of more than MaxPixels pixels
+// (checked on their header before decoding)
+// EXIF orientation and embedded ICC profile are not applied (see Normalize)
 func New(r io.Reader) (image *Image, err error) {
-	image = new(Image)
-	image.image, image.format, err = imageStd.Decode(r)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	format := DetectFormat(b)
+	if !IsSupported(format) {
+		return nil, ErrUnsupportedFormat{Format: format}
+	}
+	if MaxPixels > 0 {
+		cfg, _, err := imageStd.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if int64(cfg.Width)*int64(cfg.Height) > int64(MaxPixels) {
+			return nil, ErrTooLarge
+		}
+	}
+	image = &Image{orientation: 1}
+	image.image, image.format, err = imageStd.Decode(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	switch image.format {
+	case FormatJPEG:
+		image.orientation = exif.Orientation(b)
+		image.icc = iccFromJPEG(b)
+	case FormatPNG:
+		image.icc = iccFromPNG(b)
+	case FormatTIFF:
+		image.orientation = exif.Orientation(b)
+		image.icc = iccFromTIFF(b)
+	case FormatWebP:
+		image.orientation = exif.Orientation(b)
+		image.icc = iccFromWebP(b)
+	}
 	return
 }
 
@@ -36,6 +104,90 @@ func NewFromBytes(b []byte) (image *Image, err error) {
 	return New(bytes.NewBuffer(b))
 }
 
+// Format returns the format of the decoded image (see DetectFormat)
+func (i *Image) Format() string {
+	return i.format
+}
+
+// Copy returns a copy of image which can be transformed
+// without altering the original
+func (i *Image) Copy() *Image {
+	return &Image{image: i.image, format: i.format, orientation: i.orientation, icc: i.icc}
+}
+
+// Orientation returns EXIF orientation of image (1: normal)
+func (i *Image) Orientation() int {
+	return i.orientation
+}
+
+// Normalize rotates image regarding its EXIF orientation
+// and converts it to sRGB (see AutoOrient and ToSRGB)
+func (i *Image) Normalize() error {
+	i.AutoOrient()
+	return i.ToSRGB()
+}
+
+// AutoOrient rotates and flips image regarding its EXIF orientation
+func (i *Image) AutoOrient() {
+	filter, found := orientationFilters[i.orientation]
+	if !found {
+		return
+	}
+	g := gift.New(filter)
+	oriented := imageStd.NewRGBA(g.Bounds(i.image.Bounds()))
+	g.Draw(oriented, i.image)
+	i.image = oriented
+	i.orientation = 1
+}
+
+// ToSRGB converts image from its embedded ICC profile to sRGB
+// only RGB matrix/TRC profiles are supported (ErrUnsupportedProfile
+// is returned otherwise and image is left unchanged)
+func (i *Image) ToSRGB() error {
+	if len(i.icc) == 0 {
+		return nil
+	}
+	profile, err := parseICC(i.icc)
+	if err != nil {
+		return err
+	}
+	i.icc = nil
+	if profile.isSRGB() {
+		return nil
+	}
+
+	// LUTs: 8 bits encoded -> linear, linear -> 8 bits sRGB
+	const steps = 4096
+	var toLinear [3][256]float64
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			toLinear[c][v] = profile.trc[c](float64(v) / 255)
+		}
+	}
+	var toSRGB [steps + 1]uint8
+	for v := range toSRGB {
+		toSRGB[v] = uint8(math.Floor(linearToSRGB(float64(v)/steps)*255 + 0.5))
+	}
+
+	m := profile.toSRGB()
+	bounds := i.image.Bounds()
+	converted := imageStd.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px := color.NRGBAModel.Convert(i.image.At(x, y)).(color.NRGBA)
+			in := [3]float64{toLinear[0][px.R], toLinear[1][px.G], toLinear[2][px.B]}
+			var out [3]uint8
+			for c := 0; c < 3; c++ {
+				v := m[c][0]*in[0] + m[c][1]*in[1] + m[c][2]*in[2]
+				out[c] = toSRGB[int(math.Max(0, math.Min(1, v))*steps+0.5)]
+			}
+			converted.SetNRGBA(x, y, color.NRGBA{R: out[0], G: out[1], B: out[2], A: px.A})
+		}
+	}
+	i.image = converted
+	return nil
+}
+
 // Width returns image width
 func (i *Image) Width() int {
 	return i.image.Bounds().Max.X
@@ -57,6 +209,38 @@ func (i *Image) JPEG(quality int) ([]byte, error) {
 	return ioutil.ReadAll(buf)
 }
 
+// PNG returns image as PNG
+func (i *Image) PNG() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, i.image); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode returns image in format (JPEG or PNG)
+// quality is ignored for PNG
+func (i *Image) Encode(format string, quality int) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		return i.JPEG(quality)
+	case FormatPNG:
+		return i.PNG()
+	}
+	return nil, ErrUnsupportedFormat{Format: format}
+}
+
+// toRGBA returns img as RGBA with origin at (0, 0)
+func toRGBA(img imageStd.Image) *imageStd.RGBA {
+	if rgba, ok := img.(*imageStd.RGBA); ok && rgba.Rect.Min == (imageStd.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := imageStd.NewRGBA(imageStd.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
+
 // Resize resize image
 // Warning: upscaling not allowed
 func (i *Image) Resize(width, height int) error {
@@ -86,3 +270,69 @@ func (i *Image) ResizeToFit(width, height int) error {
 	i.image = resized
 	return nil
 }
+
+// Crop crops image to rectangle (x, y, width, height)
+// rectangle is clipped to image bounds
+func (i *Image) Crop(x, y, width, height int) error {
+	rect := imageStd.Rect(x, y, x+width, y+height).Intersect(imageStd.Rect(0, 0, i.Width(), i.Height()))
+	if rect.Empty() {
+		return ErrEmptyRegion
+	}
+	b := i.image.Bounds()
+	i.apply(gift.Crop(rect.Add(b.Min)))
+	return nil
+}
+
+// Scale resizes image to width x height (0 keeps aspect ratio)
+// unlike Resize, upscaling is allowed
+func (i *Image) Scale(width, height int) {
+	if width == i.Width() && height == i.Height() {
+		return
+	}
+	i.apply(gift.Resize(width, height, gift.LanczosResampling))
+}
+
+// Mirror flips image horizontally
+func (i *Image) Mirror() {
+	i.apply(gift.FlipHorizontal())
+}
+
+// Rotate rotates image clockwise by degrees
+// arbitrary angles enlarge image to the bounding box of the rotated image
+// (background is transparent)
+func (i *Image) Rotate(degrees float64) {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	switch degrees {
+	case 0:
+	case 90:
+		i.apply(gift.Rotate270())
+	case 180:
+		i.apply(gift.Rotate180())
+	case 270:
+		i.apply(gift.Rotate90())
+	default:
+		// gift rotates counter-clockwise
+		i.apply(gift.Rotate(float32(360-degrees), color.Transparent, gift.CubicInterpolation))
+	}
+}
+
+// Grayscale converts image to grayscale
+func (i *Image) Grayscale() {
+	i.apply(gift.Grayscale())
+}
+
+// Bitonal converts image to black and white
+func (i *Image) Bitonal() {
+	i.apply(gift.Grayscale(), gift.Threshold(50))
+}
+
+// apply applies gift filters to image
+func (i *Image) apply(filters ...gift.Filter) {
+	g := gift.New(filters...)
+	dst := imageStd.NewRGBA(g.Bounds(i.image.Bounds()))
+	g.Draw(dst, i.image)
+	i.image = dst
+}
@@ -2,10 +2,21 @@ package image
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	imageStd "image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func getImage() []byte {
@@ -43,3 +54,271 @@ func TestImage(t *testing.T) {
 		assert.Equal(t, ErrUpscaleNotAllowed, err)
 	}
 }
+
+// jpegWith returns a 4x2 JPEG (left half red, right half blue)
+// with segments inserted after SOI
+func jpegWith(segments ...[]byte) []byte {
+	img := imageStd.NewRGBA(imageStd.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x < 2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		panic(err)
+	}
+	b := buf.Bytes()
+	out := append([]byte{}, b[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, b[2:]...)
+}
+
+// orientationSegment returns an EXIF APP1 segment holding orientation
+func orientationSegment(orientation byte) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08" + // header
+		"\x00\x01" + // 1 tag
+		"\x01\x12\x00\x03\x00\x00\x00\x01\x00" + string(orientation) + "\x00\x00" + // orientation (short)
+		"\x00\x00\x00\x00") // no next IFD
+	data := append([]byte("Exif\x00\x00"), tiff...)
+	return append([]byte{0xFF, 0xE1, 0, byte(len(data) + 2)}, data...)
+}
+
+// iccSegment returns an APP2 segment holding ICC profile
+func iccSegment(profile []byte) []byte {
+	data := append([]byte(iccHeader), 1, 1)
+	data = append(data, profile...)
+	return append([]byte{0xFF, 0xE2, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+}
+
+func TestImage_AutoOrient(t *testing.T) {
+	// no orientation
+	img, err := NewFromBytes(jpegWith())
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, img.Orientation())
+	}
+
+	// 6: rotate 90 CW -> red on top
+	img, err = NewFromBytes(jpegWith(orientationSegment(6)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 6, img.Orientation())
+		img.AutoOrient()
+		assert.Equal(t, 1, img.Orientation())
+		assert.Equal(t, 2, img.Width())
+		assert.Equal(t, 4, img.Height())
+		r, _, b, _ := img.image.At(0, 0).RGBA()
+		assert.True(t, r > b)
+		r, _, b, _ = img.image.At(0, 3).RGBA()
+		assert.True(t, b > r)
+	}
+
+	// 3: rotate 180 -> blue on the left
+	img, err = NewFromBytes(jpegWith(orientationSegment(3)))
+	if assert.NoError(t, err) {
+		img.AutoOrient()
+		assert.Equal(t, 4, img.Width())
+		r, _, b, _ := img.image.At(0, 0).RGBA()
+		assert.True(t, b > r)
+	}
+}
+
+func TestImage_ToSRGB(t *testing.T) {
+	// sRGB profile round trip
+	profile, err := parseICC(SRGBProfile())
+	if assert.NoError(t, err) {
+		assert.True(t, profile.isSRGB())
+	}
+
+	// Display P3 (D50) red is more saturated than sRGB red
+	displayP3 := buildProfile("Display P3", [3][3]float64{
+		{0.515102, 0.291965, 0.157153},
+		{0.241182, 0.692236, 0.066582},
+		{-0.001049, 0.041882, 0.784378},
+	})
+	profile, err = parseICC(displayP3)
+	if assert.NoError(t, err) {
+		assert.False(t, profile.isSRGB())
+	}
+	img, err := NewFromBytes(jpegWith(iccSegment(displayP3)))
+	if assert.NoError(t, err) {
+		before := color.NRGBAModel.Convert(img.image.At(0, 0)).(color.NRGBA)
+		assert.NoError(t, img.ToSRGB())
+		after := color.NRGBAModel.Convert(img.image.At(0, 0)).(color.NRGBA)
+		assert.Equal(t, uint8(255), after.R)
+		assert.True(t, after.G <= before.G)
+		// profile is applied once
+		assert.Len(t, img.icc, 0)
+	}
+
+	// unsupported profile
+	img, err = NewFromBytes(jpegWith(iccSegment([]byte("not a profile"))))
+	if assert.NoError(t, err) {
+		assert.Error(t, img.ToSRGB())
+		assert.Equal(t, 4, img.Width())
+	}
+
+	// segment
+	assert.Equal(t, SRGBProfile(), iccFromJPEG(jpegWith(SRGBProfileSegment())))
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatJPEG, DetectFormat(getImage()))
+	assert.Equal(t, FormatPNG, DetectFormat([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")))
+	assert.Equal(t, FormatGIF, DetectFormat([]byte("GIF89a\x01\x00\x01\x00")))
+	assert.Equal(t, FormatTIFF, DetectFormat([]byte("II*\x00\x08\x00\x00\x00")))
+	assert.Equal(t, FormatTIFF, DetectFormat([]byte("MM\x00*\x00\x00\x00\x08")))
+	assert.Equal(t, FormatWebP, DetectFormat([]byte("RIFF\x1a\x00\x00\x00WEBPVP8L")))
+	assert.Equal(t, FormatBMP, DetectFormat([]byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00")))
+	assert.Equal(t, FormatHEIF, DetectFormat([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")))
+	assert.Equal(t, FormatAVIF, DetectFormat([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf")))
+	assert.Equal(t, FormatJXL, DetectFormat([]byte{0xFF, 0x0A, 0xFA}))
+	assert.Equal(t, "", DetectFormat([]byte("BMP is a format")))
+	assert.Equal(t, "", DetectFormat([]byte("not a photo")))
+
+	assert.True(t, IsSupported(FormatTIFF))
+	assert.False(t, IsSupported(FormatHEIF))
+	assert.False(t, IsSupported(""))
+	assert.Equal(t, "image/webp", ContentType(FormatWebP))
+}
+
+func TestNew_formats(t *testing.T) {
+	src := imageStd.NewRGBA(imageStd.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	wide := imageStd.NewRGBA64(src.Bounds())
+	draw.Draw(wide, wide.Bounds(), src, imageStd.Point{}, draw.Src)
+
+	encoders := map[string]func(w io.Writer) error{
+		FormatBMP:  func(w io.Writer) error { return bmp.Encode(w, src) },
+		FormatGIF:  func(w io.Writer) error { return gif.Encode(w, src, nil) },
+		FormatTIFF: func(w io.Writer) error { return tiff.Encode(w, src, nil) },
+		// 16 bits per sample
+		"tiff16": func(w io.Writer) error { return tiff.Encode(w, wide, nil) },
+	}
+	for name, encode := range encoders {
+		buf := new(bytes.Buffer)
+		if err := encode(buf); err != nil {
+			panic(err)
+		}
+		img, err := NewFromBytes(buf.Bytes())
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, 4, img.Width(), name)
+			assert.Equal(t, 2, img.Height(), name)
+			assert.NoError(t, img.Normalize(), name)
+			px := color.NRGBAModel.Convert(img.image.At(3, 1)).(color.NRGBA)
+			assert.Equal(t, uint8(255), px.R, name)
+			_, err = img.JPEG(90)
+			assert.NoError(t, err, name)
+		}
+	}
+
+	// 1x1 lossless WebP
+	img, err := NewFromBytes([]byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, FormatWebP, img.Format())
+		assert.Equal(t, 1, img.Width())
+	}
+
+	// detected but unsupported
+	_, err = NewFromBytes([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+	assert.Equal(t, ErrUnsupportedFormat{Format: FormatHEIF}, err)
+	assert.EqualError(t, err, "unsupported photo format heif")
+	_, err = NewFromBytes([]byte("not a photo"))
+	assert.EqualError(t, err, "unknown photo format")
+}
+
+func TestNew_maxPixels(t *testing.T) {
+	defer func(max int) { MaxPixels = max }(MaxPixels)
+	MaxPixels = 4000
+
+	// PNG header declaring 100000x100000 pixels (decompression bomb)
+	bomb := new(bytes.Buffer)
+	bomb.WriteString("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	binary.Write(bomb, binary.BigEndian, []uint32{100000, 100000})
+	bomb.Write([]byte{8, 2, 0, 0, 0})
+	binary.Write(bomb, binary.BigEndian, crc32.ChecksumIEEE(bomb.Bytes()[12:]))
+	_, err := NewFromBytes(bomb.Bytes())
+	assert.Equal(t, ErrTooLarge, err)
+
+	// 100x50
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, imageStd.NewRGBA(imageStd.Rect(0, 0, 100, 50))); err != nil {
+		panic(err)
+	}
+	_, err = NewFromBytes(buf.Bytes())
+	assert.Equal(t, ErrTooLarge, err)
+	MaxPixels = 5000
+	_, err = NewFromBytes(buf.Bytes())
+	assert.NoError(t, err)
+}
+
+func TestICC_TIFFAndWebP(t *testing.T) {
+	// TIFF with an InterColorProfile tag in IFD0
+	profile := SRGBProfile()
+	b := new(bytes.Buffer)
+	b.WriteString("II*\x00")
+	binary.Write(b, binary.LittleEndian, uint32(8))
+	binary.Write(b, binary.LittleEndian, uint16(1))
+	binary.Write(b, binary.LittleEndian, uint16(0x8773))
+	binary.Write(b, binary.LittleEndian, uint16(7)) // undefined
+	binary.Write(b, binary.LittleEndian, uint32(len(profile)))
+	binary.Write(b, binary.LittleEndian, uint32(8+2+12+4))
+	binary.Write(b, binary.LittleEndian, uint32(0))
+	b.Write(profile)
+	assert.Equal(t, profile, iccFromTIFF(b.Bytes()))
+
+	// WebP with an ICCP chunk
+	b = new(bytes.Buffer)
+	b.WriteString("RIFF\x00\x00\x00\x00WEBPVP8X")
+	binary.Write(b, binary.LittleEndian, uint32(10))
+	b.Write(make([]byte, 10))
+	b.WriteString("ICCP")
+	binary.Write(b, binary.LittleEndian, uint32(len(profile)))
+	b.Write(profile)
+	assert.Equal(t, profile, iccFromWebP(b.Bytes()))
+	assert.Nil(t, iccFromWebP(getImage()))
+}
+
+func TestImage_Encode(t *testing.T) {
+	img, err := NewFromBytes(getImage())
+	if err != nil {
+		panic(err)
+	}
+	if err = img.Resize(160, 0); err != nil {
+		panic(err)
+	}
+
+	// JPEG
+	b, err := img.Encode(FormatJPEG, 85)
+	if assert.NoError(t, err) {
+		decoded, err := jpeg.Decode(bytes.NewReader(b))
+		if assert.NoError(t, err) {
+			assert.Equal(t, img.Width(), decoded.Bounds().Dx())
+			assert.Equal(t, img.Height(), decoded.Bounds().Dy())
+		}
+	}
+
+	// PNG
+	b, err = img.Encode(FormatPNG, 0)
+	if assert.NoError(t, err) {
+		decoded, err := png.Decode(bytes.NewReader(b))
+		if assert.NoError(t, err) {
+			assert.Equal(t, img.Width(), decoded.Bounds().Dx())
+		}
+	}
+
+	// decoded only
+	for _, format := range []string{FormatGIF, FormatWebP} {
+		_, err = img.Encode(format, 0)
+		assert.Equal(t, ErrUnsupportedFormat{Format: format}, err)
+	}
+}
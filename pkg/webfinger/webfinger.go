@@ -0,0 +1,143 @@
+// Package webfinger implements WebFinger (RFC 7033) JRD documents
+// and a client to resolve acct: resources
+package webfinger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContentType is the JRD content type
+const ContentType = "application/jrd+json"
+
+// Link relations
+const (
+	RelSelf           = "self"
+	RelProfilePage    = "http://webfinger.net/rel/profile-page"
+	RelAvatar         = "http://webfinger.net/rel/avatar"
+	RelSubscribe      = "http://ostatus.org/schema/1.0/subscribe"
+	RelMagicPublicKey = "magic-public-key"
+)
+
+// maxDocumentSize is the max size of a JRD document
+const maxDocumentSize = 1 << 20
+
+// magicKeyPrefix is the prefix of magic-public-key links href
+const magicKeyPrefix = "data:application/magic-public-key,"
+
+// ErrBadResource is returned if resource is not a valid acct: resource
+var ErrBadResource = errors.New("webfinger: bad resource")
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// scheme used to query remote servers (for testing purpose)
+var scheme = "https"
+
+// Link is a JRD link
+type Link struct {
+	Rel      string `json:"rel"`
+	Type     string `json:"type,omitempty"`
+	Href     string `json:"href,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// JRD is a JSON Resource Descriptor
+type JRD struct {
+	Subject string   `json:"subject"`
+	Aliases []string `json:"aliases,omitempty"`
+	Links   []Link   `json:"links"`
+}
+
+// Link returns the first link with relation rel
+// if types are given, link type must be one of them
+func (j *JRD) Link(rel string, types ...string) *Link {
+	for i, l := range j.Links {
+		if l.Rel != rel {
+			continue
+		}
+		if len(types) == 0 {
+			return &j.Links[i]
+		}
+		for _, t := range types {
+			if strings.HasPrefix(l.Type, t) {
+				return &j.Links[i]
+			}
+		}
+	}
+	return nil
+}
+
+// MagicKey returns the magic-public-key of the subject if any
+func (j *JRD) MagicKey() string {
+	if l := j.Link(RelMagicPublicKey); l != nil && strings.HasPrefix(l.Href, magicKeyPrefix) {
+		return l.Href[len(magicKeyPrefix):]
+	}
+	return ""
+}
+
+// NewMagicKeyLink returns the magic-public-key link of magicKey
+func NewMagicKeyLink(magicKey string) Link {
+	return Link{Rel: RelMagicPublicKey, Href: magicKeyPrefix + magicKey}
+}
+
+// Filter keeps only links whose relation is one of rels
+// (RFC 7033 4.3, no rels: all links are kept)
+func (j *JRD) Filter(rels ...string) {
+	if len(rels) == 0 {
+		return
+	}
+	links := make([]Link, 0, len(j.Links))
+	for _, l := range j.Links {
+		for _, rel := range rels {
+			if l.Rel == rel {
+				links = append(links, l)
+				break
+			}
+		}
+	}
+	j.Links = links
+}
+
+// ParseAcct splits an acct resource (acct:user@domain, user@domain or @user@domain)
+func ParseAcct(resource string) (user, domain string, err error) {
+	resource = strings.TrimPrefix(strings.TrimSpace(resource), "acct:")
+	resource = strings.TrimPrefix(resource, "@")
+	p := strings.Split(resource, "@")
+	if len(p) != 2 || p[0] == "" || p[1] == "" {
+		return "", "", ErrBadResource
+	}
+	return p[0], strings.ToLower(p[1]), nil
+}
+
+// Lookup queries the WebFinger endpoint of the acct domain
+func Lookup(acct string) (*JRD, error) {
+	user, domain, err := ParseAcct(acct)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("%s://%s/.well-known/webfinger?resource=%s", scheme, domain, url.QueryEscape("acct:"+user+"@"+domain))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+", application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webfinger: GET %s returned %s", u, resp.Status)
+	}
+	jrd := new(JRD)
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(jrd); err != nil {
+		return nil, fmt.Errorf("webfinger: unmarshal %s failed: %v", u, err)
+	}
+	return jrd, nil
+}
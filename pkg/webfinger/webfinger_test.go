@@ -0,0 +1,68 @@
+package webfinger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcct(t *testing.T) {
+	for _, r := range []string{"acct:john@Remote.social", "john@remote.social", "@john@remote.social"} {
+		user, domain, err := ParseAcct(r)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "john", user)
+			assert.Equal(t, "remote.social", domain)
+		}
+	}
+	_, _, err := ParseAcct("acct:john")
+	assert.Equal(t, ErrBadResource, err)
+}
+
+func TestLookup(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/webfinger" || r.URL.Query().Get("resource") != "acct:john@"+r.Host {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Write([]byte(`{"subject":"acct:john@` + r.Host + `","links":[
+			{"rel":"http://webfinger.net/rel/profile-page","type":"text/html","href":"https://remote.social/@john"},
+			{"rel":"self","type":"application/activity+json","href":"https://remote.social/users/john"},
+			{"rel":"magic-public-key","href":"data:application/magic-public-key,RSA.mVgY.AQAB"}]}`))
+	}))
+	defer ts.Close()
+	scheme = "http"
+	defer func() { scheme = "https" }()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	jrd, err := Lookup("acct:john@" + host)
+	if assert.NoError(t, err) {
+		if self := jrd.Link(RelSelf, "application/activity+json"); assert.NotNil(t, self) {
+			assert.Equal(t, "https://remote.social/users/john", self.Href)
+		}
+		assert.Nil(t, jrd.Link(RelSelf, "text/html"))
+		assert.Equal(t, "RSA.mVgY.AQAB", jrd.MagicKey())
+	}
+
+	_, err = Lookup("jane@" + host)
+	assert.Error(t, err)
+}
+
+func TestJRD_Filter(t *testing.T) {
+	jrd := &JRD{Links: []Link{
+		{Rel: RelSelf, Href: "https://peerpx.social/users/toorop"},
+		{Rel: RelProfilePage, Href: "https://peerpx.social/@toorop"},
+		NewMagicKeyLink("RSA.abc.AQAB"),
+	}}
+	assert.Equal(t, "RSA.abc.AQAB", jrd.MagicKey())
+
+	jrd.Filter()
+	assert.Equal(t, 3, len(jrd.Links))
+	jrd.Filter(RelSelf, RelAvatar)
+	if assert.Equal(t, 1, len(jrd.Links)) {
+		assert.Equal(t, RelSelf, jrd.Links[0].Rel)
+	}
+}
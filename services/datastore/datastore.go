@@ -1,6 +1,11 @@
 package datastore
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
 
 // DS global datastore
 var ds Provider
@@ -10,21 +15,105 @@ var (
 	// ErrNotInitialized (ds == nil)
 	ErrNotInitialized = errors.New("datastore: service not initialized")
 
-	// ErrNotFound is returned by Get, Delete if key is not found
+	// ErrNotFound is returned by Get, GetReader, Stat, Delete if key is not found
 	ErrNotFound = errors.New("datastore: key not found")
+
+	// ErrNotSupported is returned by PresignedURL if datastore can't presign URLs
+	ErrNotSupported = errors.New("datastore: not supported")
 )
 
+// Info describes a stored value
+type Info struct {
+	Size    int64     // length in bytes
+	ModTime time.Time // last modification
+}
+
+// Entry is a key returned by List
+type Entry struct {
+	Key string
+	Info
+}
+
+// Iterator iterates over keys returned by List (in no particular order)
+//
+//	it := datastore.List(prefix)
+//	for it.Next() {
+//		entry := it.Entry()
+//	}
+//	if err := it.Err(); err != nil {
+type Iterator struct {
+	fetch func() ([]Entry, bool, error) // next batch of keys, false once done
+	batch []Entry
+	entry Entry
+	done  bool
+	err   error
+}
+
+// Next advances to the next key
+// it returns false when there is no more key or on error (see Err)
+func (it *Iterator) Next() bool {
+	for len(it.batch) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		batch, more, err := it.fetch()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.batch, it.done = batch, !more
+	}
+	it.entry, it.batch = it.batch[0], it.batch[1:]
+	return true
+}
+
+// Entry returns the current key
+func (it *Iterator) Entry() Entry {
+	return it.entry
+}
+
+// Err returns the error which stopped iteration (if any)
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// bytesReader is a seekable reader of a value in memory
+type bytesReader struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (bytesReader) Close() error {
+	return nil
+}
+
 // Provider represents the storage interface interafce
 type Provider interface {
 	put(key string, value []byte) error
 
+	putReader(key string, r io.Reader) error
+
 	exists(key string) (bool, error)
 
 	get(key string) (value []byte, err error)
 
+	getReader(key string) (io.ReadCloser, error)
+
+	stat(key string) (Info, error)
+
+	// list returns a func fetching keys starting with prefix by batch
+	// (see Iterator)
+	list(prefix string) func() ([]Entry, bool, error)
+
 	delete(key string) error
 }
 
+// presigner is implemented by providers able to give temporary direct
+// access to values
+type presigner interface {
+	presign(key string) (string, error)
+}
+
 // Put store value identified by key
 func Put(key string, value []byte) error {
 	if ds == nil {
@@ -33,6 +122,15 @@ func Put(key string, value []byte) error {
 	return ds.put(key, value)
 }
 
+// PutReader stores value read from r identified by key
+func PutReader(key string, r io.Reader) error {
+	if ds == nil {
+		return ErrNotInitialized
+	}
+	return ds.putReader(key, r)
+}
+
+// Exists returns true if key is stored
 func Exists(key string) (bool, error) {
 	if ds == nil {
 		return false, ErrNotInitialized
@@ -48,6 +146,31 @@ func Get(key string) (value []byte, err error) {
 	return ds.get(key)
 }
 
+// GetReader returns a reader of value associated with key
+// reader must be closed by caller
+func GetReader(key string) (io.ReadCloser, error) {
+	if ds == nil {
+		return nil, ErrNotInitialized
+	}
+	return ds.getReader(key)
+}
+
+// Stat returns info about value associated with key
+func Stat(key string) (Info, error) {
+	if ds == nil {
+		return Info{}, ErrNotInitialized
+	}
+	return ds.stat(key)
+}
+
+// List returns an iterator over keys starting with prefix
+func List(prefix string) *Iterator {
+	if ds == nil {
+		return &Iterator{err: ErrNotInitialized}
+	}
+	return &Iterator{fetch: ds.list(prefix)}
+}
+
 // Delete deletes value for a given key
 func Delete(key string) error {
 	if ds == nil {
@@ -55,3 +178,17 @@ func Delete(key string) error {
 	}
 	return ds.delete(key)
 }
+
+// PresignedURL returns a temporary URL to GET value of key directly
+// from storage (ErrNotSupported is returned if datastore can't)
+// key existence is not checked
+func PresignedURL(key string) (string, error) {
+	if ds == nil {
+		return "", ErrNotInitialized
+	}
+	p, ok := ds.(presigner)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	return p.presign(key)
+}
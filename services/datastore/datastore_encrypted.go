@@ -0,0 +1,476 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+/*
+	Sealed values
+
+	values are sealed in chunks with NaCl secretbox (XSalsa20-Poly1305)
+	and stored as:
+		- magic "PPXE" (4 bytes)
+		- ID of the key (4 first bytes of its SHA256)
+		- random nonce prefix (16 bytes)
+		- chunks: secretboxes of 64KiB of value (+ 16 bytes of authenticator)
+
+	the nonce of a chunk is the nonce prefix followed by the chunk number
+	(8 bytes big endian, high bit set for the last chunk): chunks can't be
+	reordered, truncation is detected
+	the last chunk is shorter than others (it is empty if value size is a
+	multiple of chunk size): value size is known from sealed size
+
+	keys (photo hashes) are not changed: they remain hashes of plaintexts
+*/
+
+// encryptedMagic starts sealed values
+var encryptedMagic = []byte("PPXE")
+
+const (
+	encryptedHeaderSize      = 4 + 4 + 16
+	encryptedChunkSize       = 64 << 10
+	encryptedSealedChunkSize = encryptedChunkSize + secretbox.Overhead
+)
+
+// ErrDecryption is returned if a value can't be opened
+var ErrDecryption = errors.New("datastore: unable to decrypt value")
+
+// Encrypted is a datastore wrapper sealing values of a provider
+// values are sealed and opened in chunks: they are streamed, readers
+// can seek if readers of provider can
+type Encrypted struct {
+	provider       Provider
+	key            *[32]byte             // sealing key
+	keys           map[[4]byte]*[32]byte // opening keys (sealing and old keys) by ID
+	allowPlaintext bool
+}
+
+// InitEncryptedDatastore wraps initialized datastore: values are sealed
+// with key
+// values sealed with oldKeys can still be read until they are sealed again
+// with key (see RotateKeys)
+// if allowPlaintext is true, values stored before encryption are read as is
+// (to be enabled until RotateKeys has sealed them)
+func InitEncryptedDatastore(key *[32]byte, oldKeys []*[32]byte, allowPlaintext bool) error {
+	if ds == nil {
+		return ErrNotInitialized
+	}
+	if key == nil {
+		return errors.New("datastore: encryption key is not defined")
+	}
+	e := &Encrypted{
+		provider:       ds,
+		key:            key,
+		keys:           make(map[[4]byte]*[32]byte),
+		allowPlaintext: allowPlaintext,
+	}
+	for _, k := range append(oldKeys, key) {
+		e.keys[keyID(k)] = k
+	}
+	ds = e
+	return nil
+}
+
+// keyID returns the ID of key
+func keyID(key *[32]byte) (id [4]byte) {
+	sum := sha256.Sum256(key[:])
+	copy(id[:], sum[:4])
+	return
+}
+
+// chunkNonce returns the nonce of chunk number n
+func chunkNonce(prefix *[16]byte, n uint64, last bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], prefix[:])
+	if last {
+		n |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(nonce[16:], n)
+	return &nonce
+}
+
+// sealedSize returns the size of a sealed value of size bytes
+func sealedSize(size int64) int64 {
+	return encryptedHeaderSize + size + secretbox.Overhead*(size/encryptedChunkSize+1)
+}
+
+// valueSize returns the size of the value of sealed size bytes
+// (false if size is not the size of a sealed value)
+func valueSize(size int64) (int64, bool) {
+	size -= encryptedHeaderSize
+	if size < secretbox.Overhead {
+		return 0, false
+	}
+	last := size % encryptedSealedChunkSize
+	if last < secretbox.Overhead {
+		return 0, false
+	}
+	return size/encryptedSealedChunkSize*encryptedChunkSize + last - secretbox.Overhead, true
+}
+
+// sealer is a reader of value of r sealed with key
+type sealer struct {
+	r      io.Reader
+	key    *[32]byte
+	prefix [16]byte
+	n      uint64 // number of next chunk
+	last   bool   // last chunk is sealed
+	chunk  []byte
+	buf    []byte // sealed chunk
+	out    []byte // sealed bytes not read yet
+}
+
+// newSealer returns a reader of value of r sealed with e key
+func (e *Encrypted) newSealer(r io.Reader) (*sealer, error) {
+	s := &sealer{
+		r:     r,
+		key:   e.key,
+		chunk: make([]byte, encryptedChunkSize),
+		buf:   make([]byte, 0, encryptedSealedChunkSize),
+	}
+	if _, err := io.ReadFull(rand.Reader, s.prefix[:]); err != nil {
+		return nil, err
+	}
+	id := keyID(e.key)
+	s.out = make([]byte, 0, encryptedHeaderSize)
+	s.out = append(s.out, encryptedMagic...)
+	s.out = append(s.out, id[:]...)
+	s.out = append(s.out, s.prefix[:]...)
+	return s, nil
+}
+
+// Read implements io.Reader
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.last {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.r, s.chunk[:encryptedChunkSize])
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			s.last = true
+		default:
+			return 0, err
+		}
+		s.out = secretbox.Seal(s.buf[:0], s.chunk[:n], chunkNonce(&s.prefix, s.n, s.last), s.key)
+		s.n++
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// opener is a reader of value of sealed reader r
+type opener struct {
+	r      io.ReadCloser
+	key    *[32]byte
+	prefix [16]byte
+	n      uint64 // number of next chunk
+	last   bool   // last chunk is opened
+	skip   int64  // bytes to skip in next chunk (see Seek)
+	pos    int64  // position in value
+	chunk  []byte // sealed chunk
+	buf    []byte
+	out    []byte // opened bytes not read yet
+}
+
+// open returns a reader of value of r (sealed or plaintext)
+// r is closed on error
+func (e *Encrypted) open(r io.ReadCloser) (io.ReadCloser, error) {
+	header := make([]byte, encryptedHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.Close()
+		return nil, err
+	}
+	if !bytes.HasPrefix(header[:n], encryptedMagic) {
+		if e.allowPlaintext {
+			return rewind(r, header[:n])
+		}
+		r.Close()
+		return nil, ErrDecryption
+	}
+	var id [4]byte
+	copy(id[:], header[4:8])
+	key, found := e.keys[id]
+	if !found || n < encryptedHeaderSize {
+		r.Close()
+		return nil, ErrDecryption
+	}
+	o := &opener{
+		r:     r,
+		key:   key,
+		chunk: make([]byte, encryptedSealedChunkSize),
+		buf:   make([]byte, 0, encryptedChunkSize),
+	}
+	copy(o.prefix[:], header[8:])
+	if _, ok := r.(io.Seeker); ok {
+		return seekableOpener{o}, nil
+	}
+	return o, nil
+}
+
+// Read implements io.Reader
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.last {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(o.r, o.chunk)
+		switch err {
+		case nil:
+		case io.ErrUnexpectedEOF:
+			o.last = true
+		case io.EOF:
+			// truncated
+			return 0, ErrDecryption
+		default:
+			return 0, err
+		}
+		value, ok := secretbox.Open(o.buf[:0], o.chunk[:n], chunkNonce(&o.prefix, o.n, o.last), o.key)
+		if !ok {
+			return 0, ErrDecryption
+		}
+		o.n++
+		if o.skip > int64(len(value)) {
+			o.skip = int64(len(value))
+		}
+		o.out, o.skip = value[o.skip:], 0
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	o.pos += int64(n)
+	return n, nil
+}
+
+// Close implements io.Closer
+func (o *opener) Close() error {
+	return o.r.Close()
+}
+
+// seekableOpener is an opener of a seekable sealed reader
+type seekableOpener struct {
+	*opener
+}
+
+// Seek implements io.Seeker (io.SeekEnd is not supported)
+func (o seekableOpener) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	default:
+		return o.pos, errors.New("datastore: unsupported seek whence")
+	}
+	if offset < 0 {
+		return o.pos, errors.New("datastore: negative position")
+	}
+	n := offset / encryptedChunkSize
+	if _, err := o.r.(io.Seeker).Seek(encryptedHeaderSize+n*encryptedSealedChunkSize, io.SeekStart); err != nil {
+		return o.pos, err
+	}
+	o.n, o.last, o.out = uint64(n), false, nil
+	o.skip = offset - n*encryptedChunkSize
+	o.pos = offset
+	return offset, nil
+}
+
+// rewind returns a reader of r from its start, head being already read
+// from r
+func rewind(r io.ReadCloser, head []byte) (io.ReadCloser, error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r), r}, nil
+}
+
+// openBytes returns the value of sealed
+func (e *Encrypted) openBytes(sealed []byte) ([]byte, error) {
+	r, err := e.open(bytesReader{bytes.NewReader(sealed)})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// put implements datastore.put
+func (e *Encrypted) put(key string, value []byte) error {
+	return e.putReader(key, bytes.NewReader(value))
+}
+
+// putReader implements datastore.putReader
+func (e *Encrypted) putReader(key string, r io.Reader) error {
+	s, err := e.newSealer(r)
+	if err != nil {
+		return err
+	}
+	return e.provider.putReader(key, s)
+}
+
+// exists implements datastore.exists
+func (e *Encrypted) exists(key string) (bool, error) {
+	return e.provider.exists(key)
+}
+
+// get implements datastore.get
+func (e *Encrypted) get(key string) ([]byte, error) {
+	r, err := e.getReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// getReader implements datastore.getReader
+func (e *Encrypted) getReader(key string) (io.ReadCloser, error) {
+	r, err := e.provider.getReader(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(r)
+}
+
+// sealed returns true if value of key is sealed and the ID of its key
+func (e *Encrypted) sealed(key string) (bool, [4]byte, error) {
+	var id [4]byte
+	r, err := e.provider.getReader(key)
+	if err != nil {
+		return false, id, err
+	}
+	defer r.Close()
+	header := make([]byte, len(encryptedMagic)+len(id))
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, id, err
+	}
+	copy(id[:], header[len(encryptedMagic):n])
+	return bytes.HasPrefix(header[:n], encryptedMagic), id, nil
+}
+
+// stat implements datastore.stat
+// (Size is the size of the value, not the sealed one)
+func (e *Encrypted) stat(key string) (Info, error) {
+	info, err := e.provider.stat(key)
+	if err != nil {
+		return info, err
+	}
+	if e.allowPlaintext {
+		sealed, _, err := e.sealed(key)
+		if err != nil || !sealed {
+			return info, err
+		}
+	}
+	size, ok := valueSize(info.Size)
+	if !ok {
+		return info, ErrDecryption
+	}
+	info.Size = size
+	return info, nil
+}
+
+// list implements datastore.list
+// (sizes are the sizes of values, but if plaintext is allowed: values are
+// not read, sizes of sealed values are then sealed sizes)
+func (e *Encrypted) list(prefix string) func() ([]Entry, bool, error) {
+	fetch := e.provider.list(prefix)
+	return func() ([]Entry, bool, error) {
+		entries, more, err := fetch()
+		if e.allowPlaintext {
+			return entries, more, err
+		}
+		for i := range entries {
+			if size, ok := valueSize(entries[i].Size); ok {
+				entries[i].Size = size
+			}
+		}
+		return entries, more, err
+	}
+}
+
+// delete implements datastore.delete
+func (e *Encrypted) delete(key string) error {
+	return e.provider.delete(key)
+}
+
+// scrub implements scrubber: values are opened before being hashed
+func (e *Encrypted) scrub(quarantine bool, decode func([]byte) ([]byte, error)) (*ScrubReport, error) {
+	s, ok := e.provider.(scrubber)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return s.scrub(quarantine, func(b []byte) ([]byte, error) {
+		b, err := e.openBytes(b)
+		if err != nil || decode == nil {
+			return b, err
+		}
+		return decode(b)
+	})
+}
+
+// rotate seals again with sealing key values sealed with an old key
+// (and plaintext values) and returns their number
+// values are content addressed (or rendered from them): a value rewritten
+// while it is rotated has the same content, a value deleted while it is
+// rotated may be stored again (see photo.GC)
+func (e *Encrypted) rotate() (int, error) {
+	current := keyID(e.key)
+	rotated := 0
+	it := &Iterator{fetch: e.provider.list("")}
+	for it.Next() {
+		key := it.Entry().Key
+		sealed, id, err := e.sealed(key)
+		if err == nil && sealed && id == current {
+			continue
+		}
+		var r io.ReadCloser
+		if err == nil {
+			r, err = e.getReader(key)
+		}
+		if err != nil {
+			// deleted meanwhile
+			if err == ErrNotFound {
+				continue
+			}
+			return rotated, err
+		}
+		err = e.putReader(key, r)
+		r.Close()
+		if err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, it.Err()
+}
+
+// RotateKeys seals again values of an encrypted datastore which are not
+// sealed with its current key and returns their number
+// (ErrNotSupported is returned if datastore is not encrypted)
+func RotateKeys() (int, error) {
+	if ds == nil {
+		return 0, ErrNotInitialized
+	}
+	e, ok := ds.(*Encrypted)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return e.rotate()
+}
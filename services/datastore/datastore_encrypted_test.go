@@ -0,0 +1,223 @@
+package datastore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peerpx/peerpx/pkg/hasher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitEncryptedDatastore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { ds = nil }()
+
+	oldKey, key := &[32]byte{1}, &[32]byte{2}
+	key2 := "peerpxKey2" // not a hash
+	assert.Equal(t, ErrNotInitialized, InitEncryptedDatastore(key, nil, false))
+	if err = InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	fs := ds
+	assert.Error(t, InitEncryptedDatastore(nil, nil, false))
+
+	// sealed with old key
+	if err = InitEncryptedDatastore(oldKey, nil, false); err != nil {
+		panic(err)
+	}
+	hash, _ := hasher.GetHash(value)
+	assert.NoError(t, Put(hash, value))
+	sealed, _ := fs.get(hash)
+	assert.False(t, bytes.Contains(sealed, value))
+	assert.Equal(t, sealedSize(int64(len(value))), int64(len(sealed)))
+
+	// a nonce per value
+	assert.NoError(t, Put(key2, value))
+	sealed2, _ := fs.get(key2)
+	assert.NotEqual(t, sealed, sealed2)
+
+	// plaintext
+	if err = fs.put("plain", value); err != nil {
+		panic(err)
+	}
+
+	// rotation
+	ds = fs
+	if err = InitEncryptedDatastore(key, []*[32]byte{oldKey}, true); err != nil {
+		panic(err)
+	}
+	rData, err := Get(hash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, value, rData)
+	}
+	info, err := Stat(hash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(value)), info.Size)
+	}
+	info, err = Stat("plain")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(value)), info.Size)
+	}
+	rotated, err := RotateKeys()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, rotated)
+	}
+	rotated, err = RotateKeys()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, rotated)
+	}
+
+	// old key and plaintext values are no longer needed
+	ds = fs
+	if err = InitEncryptedDatastore(key, nil, false); err != nil {
+		panic(err)
+	}
+	for _, k := range []string{hash, key2, "plain"} {
+		rData, err = Get(k)
+		if assert.NoError(t, err) {
+			assert.Equal(t, value, rData)
+		}
+	}
+	r, err := GetReader(hash)
+	if assert.NoError(t, err) {
+		rData, _ = ioutil.ReadAll(r)
+		assert.Equal(t, value, rData)
+	}
+	info, err = Stat(hash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(value)), info.Size)
+	}
+	it := List(hash)
+	if assert.True(t, it.Next()) {
+		assert.Equal(t, int64(len(value)), it.Entry().Size)
+	}
+
+	// list sizes are sealed sizes if plaintext is allowed
+	ds = fs
+	if err = InitEncryptedDatastore(key, nil, true); err != nil {
+		panic(err)
+	}
+	it = List(hash)
+	if assert.True(t, it.Next()) {
+		assert.Equal(t, sealedSize(int64(len(value))), it.Entry().Size)
+	}
+	ds = fs
+	if err = InitEncryptedDatastore(key, nil, false); err != nil {
+		panic(err)
+	}
+
+	// tampered
+	sealed, _ = fs.get(hash)
+	sealed[len(sealed)-1] ^= 1
+	if err = fs.put(hash, sealed); err != nil {
+		panic(err)
+	}
+	_, err = Get(hash)
+	assert.Equal(t, ErrDecryption, err)
+
+	// scrub hashes plaintexts
+	report, err := Scrub(true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Checked)
+		assert.Equal(t, []string{hash}, report.Quarantined)
+	}
+	quarantined, _ := filepath.Glob(filepath.Join(dir, fsQuarantine, hash+".*"))
+	assert.Len(t, quarantined, 1)
+
+	// unknown key
+	ds = fs
+	if err = InitEncryptedDatastore(oldKey, nil, false); err != nil {
+		panic(err)
+	}
+	_, err = Get(key2)
+	assert.Equal(t, ErrDecryption, err)
+}
+
+func TestEncryptedSizes(t *testing.T) {
+	for _, size := range []int64{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3 * encryptedChunkSize} {
+		got, ok := valueSize(sealedSize(size))
+		if assert.True(t, ok, size) {
+			assert.Equal(t, size, got)
+		}
+	}
+	_, ok := valueSize(encryptedHeaderSize + 2)
+	assert.False(t, ok)
+	_, ok = valueSize(encryptedHeaderSize + encryptedSealedChunkSize)
+	assert.False(t, ok)
+}
+
+func TestEncryptedStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { ds = nil }()
+	if err = InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	fs := ds
+	if err = InitEncryptedDatastore(&[32]byte{1}, nil, false); err != nil {
+		panic(err)
+	}
+
+	// 2 chunks and a half
+	large := make([]byte, 5*encryptedChunkSize/2)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	assert.NoError(t, PutReader("large", bytes.NewReader(large)))
+	info, err := Stat("large")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(large)), info.Size)
+	}
+
+	// seek in second chunk
+	r, err := GetReader("large")
+	if assert.NoError(t, err) {
+		offset := int64(encryptedChunkSize + 10)
+		pos, err := r.(io.Seeker).Seek(offset, io.SeekStart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, offset, pos)
+		}
+		b := make([]byte, 100)
+		_, err = io.ReadFull(r, b)
+		assert.NoError(t, err)
+		assert.Equal(t, large[offset:offset+100], b)
+		pos, err = r.(io.Seeker).Seek(-offset, io.SeekCurrent)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(100), pos)
+		}
+		b, err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(large[100:], b))
+		assert.NoError(t, r.Close())
+	}
+
+	// truncated after first chunk
+	sealed, _ := fs.get("large")
+	if err = fs.put("large", sealed[:encryptedHeaderSize+encryptedSealedChunkSize]); err != nil {
+		panic(err)
+	}
+	_, err = Get("large")
+	assert.Equal(t, ErrDecryption, err)
+
+	// chunks swapped
+	swapped := append([]byte{}, sealed[:encryptedHeaderSize]...)
+	swapped = append(swapped, sealed[encryptedHeaderSize+encryptedSealedChunkSize:encryptedHeaderSize+2*encryptedSealedChunkSize]...)
+	swapped = append(swapped, sealed[encryptedHeaderSize:encryptedHeaderSize+encryptedSealedChunkSize]...)
+	swapped = append(swapped, sealed[encryptedHeaderSize+2*encryptedSealedChunkSize:]...)
+	if err = fs.put("large", swapped); err != nil {
+		panic(err)
+	}
+	_, err = Get("large")
+	assert.Equal(t, ErrDecryption, err)
+}
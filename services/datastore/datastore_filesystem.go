@@ -1,10 +1,15 @@
 package datastore
 
 import (
+	"bytes"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 /*
@@ -22,9 +27,14 @@ import (
 
 */
 
+// fsTempPrefix is the name prefix of files being written
+// (values are written to a temp file then renamed)
+const fsTempPrefix = ".tmp-"
+
 // Fs is a file system datastore
 type Fs struct {
 	basePath string
+	locks    [64]sync.Mutex // renames and removals of a key are serialized (see lock)
 }
 
 // InitFilesystemDatastore initialize datastore as file system datastore
@@ -42,20 +52,42 @@ func InitFilesystemDatastore(basePath string) error {
 
 // Put implements datastore.put
 func (d *Fs) put(key string, value []byte) error {
+	return d.putReader(key, bytes.NewReader(value))
+}
+
+// putReader implements datastore.PutReader
+// value is written to a temp file, synced then renamed: a crash never
+// leaves a truncated value under key
+func (d *Fs) putReader(key string, r io.Reader) error {
 	basePath := d.getPath(key)
-	// path exists ? no -> create it
-	_, err := os.Stat(basePath)
+	if err := os.MkdirAll(basePath, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(basePath, fsTempPrefix+key+"-")
 	if err != nil {
-		if os.IsNotExist(err) {
-			if err = os.MkdirAll(basePath, os.ModePerm); err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
+		return err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	unlock := d.lock(key)
+	defer unlock()
+	if err = os.Rename(f.Name(), filepath.Join(basePath, key)); err != nil {
+		os.Remove(f.Name())
+		return err
 	}
-	// write file
-	return ioutil.WriteFile(filepath.Join(basePath, key), value, 0644)
+	return syncDir(basePath)
 }
 
 // get implements datastore.Get
@@ -67,6 +99,31 @@ func (d *Fs) get(key string) ([]byte, error) {
 	return data, err
 }
 
+// getReader implements datastore.GetReader
+// (returned reader is an *os.File: it can seek)
+func (d *Fs) getReader(key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(d.getPath(key), key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// stat implements datastore.Stat
+func (d *Fs) stat(key string) (Info, error) {
+	finfo, err := os.Stat(filepath.Join(d.getPath(key), key))
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: finfo.Size(), ModTime: finfo.ModTime()}, nil
+}
+
 // exists
 func (d *Fs) exists(key string) (bool, error) {
 	_, err := os.Open(filepath.Join(d.getPath(key), key))
@@ -79,8 +136,60 @@ func (d *Fs) exists(key string) (bool, error) {
 	return false, err
 }
 
+// list implements datastore.list
+// a batch is the keys of a directory
+func (d *Fs) list(prefix string) func() ([]Entry, bool, error) {
+	type dir struct {
+		path  string
+		depth int
+	}
+	dirs := []dir{{d.basePath, 0}}
+	return func() ([]Entry, bool, error) {
+		current := dirs[0]
+		dirs = dirs[1:]
+		infos, err := ioutil.ReadDir(current.path)
+		if err != nil {
+			// removed since listed
+			if os.IsNotExist(err) && current.depth > 0 {
+				return nil, len(dirs) > 0, nil
+			}
+			return nil, false, err
+		}
+		entries := []Entry{}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() {
+				// key dirs are the 2 first pairs of chars of keys (see getPath)
+				if current.depth < 2 && !strings.HasPrefix(name, ".") && strings.HasPrefix(name, prefixPart(prefix, current.depth)) {
+					dirs = append(dirs, dir{filepath.Join(current.path, name), current.depth + 1})
+				}
+				continue
+			}
+			if isTemp(name) || !strings.HasPrefix(name, prefix) || d.getPath(name) != current.path {
+				continue
+			}
+			entries = append(entries, Entry{Key: name, Info: Info{Size: info.Size(), ModTime: info.ModTime()}})
+		}
+		return entries, len(dirs) > 0, nil
+	}
+}
+
+// prefixPart returns the part of prefix matching key dirs at depth
+func prefixPart(prefix string, depth int) string {
+	start := 2 * depth
+	if len(prefix) <= start {
+		return ""
+	}
+	if len(prefix) > start+2 {
+		return prefix[start : start+2]
+	}
+	return prefix[start:]
+}
+
 // delete implements datastore.Delete
 func (d *Fs) delete(key string) error {
+	unlock := d.lock(key)
+	defer unlock()
 	err := os.Remove(filepath.Join(d.getPath(key), key))
 	if os.IsNotExist(err) {
 		return ErrNotFound
@@ -97,3 +206,30 @@ func (d *Fs) getPath(key string) (fPath string) {
 	}
 	return
 }
+
+// lock locks writes of key and returns its unlock func
+func (d *Fs) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	l := &d.locks[h.Sum32()%uint32(len(d.locks))]
+	l.Lock()
+	return l.Unlock
+}
+
+// isTemp returns true if file name is a temp file
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, fsTempPrefix)
+}
+
+// syncDir commits directory dir (entries) to disk
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
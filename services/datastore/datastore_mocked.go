@@ -1,5 +1,11 @@
 package datastore
 
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
 // Mocked is a mocked datastore for testing
 type Mocked struct {
 	responseErr  error
@@ -22,6 +28,14 @@ func (d *Mocked) put(key string, value []byte) error {
 	return d.responseErr
 }
 
+// putReader implements datastore.putReader
+func (d *Mocked) putReader(key string, r io.Reader) error {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	return d.responseErr
+}
+
 // exists implements datastore.exists
 func (d *Mocked) exists(key string) (bool, error) {
 	return d.responseData[0] == 1, d.responseErr
@@ -32,6 +46,31 @@ func (d *Mocked) get(key string) (data []byte, err error) {
 	return d.responseData, d.responseErr
 }
 
+// getReader implements datastore.getReader
+func (d *Mocked) getReader(key string) (io.ReadCloser, error) {
+	if d.responseErr != nil {
+		return nil, d.responseErr
+	}
+	return bytesReader{bytes.NewReader(d.responseData)}, nil
+}
+
+// stat implements datastore.stat
+// (ModTime is zero)
+func (d *Mocked) stat(key string) (Info, error) {
+	return Info{Size: int64(len(d.responseData))}, d.responseErr
+}
+
+// list implements datastore.list
+// (mocked datastore has no keys, ErrNotFound is not an error)
+func (d *Mocked) list(prefix string) func() ([]Entry, bool, error) {
+	return func() ([]Entry, bool, error) {
+		if d.responseErr == ErrNotFound {
+			return nil, false, nil
+		}
+		return nil, false, d.responseErr
+	}
+}
+
 // Delete implements datastore.delete
 func (d *Mocked) delete(key string) error {
 	return d.responseErr
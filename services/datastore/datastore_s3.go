@@ -0,0 +1,543 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 requests are signed with AWS Signature Version 4
+const (
+	s3Algorithm   = "AWS4-HMAC-SHA256"
+	s3TimeFormat  = "20060102T150405Z"
+	s3DateFormat  = "20060102"
+	s3EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3Unsigned    = "UNSIGNED-PAYLOAD"
+
+	// s3MinPartSize is the min size of multipart upload parts (but the last one)
+	s3MinPartSize = 5 << 20
+)
+
+// S3Config is the configuration of a S3 datastore
+type S3Config struct {
+	Endpoint  string        // eg https://s3.eu-west-3.amazonaws.com, http://127.0.0.1:9000 (default https://s3.amazonaws.com)
+	Region    string        // default us-east-1
+	Bucket    string        // mandatory
+	Prefix    string        // prepended to keys (eg peerpx/)
+	PathStyle bool          // {endpoint}/{bucket}/{key} instead of {bucket}.{endpoint}/{key} (MinIO...)
+	AccessKey string        // credentials
+	SecretKey string        //
+	PartSize  int64         // values larger than PartSize are sent by multipart upload (default and min 5MiB)
+	Presign   time.Duration // lifetime of presigned GET URLs (0: disabled, see PresignedURL)
+}
+
+// S3 is a S3 compatible object storage datastore
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// InitS3Datastore initialize datastore as S3 datastore
+func InitS3Datastore(config S3Config) error {
+	d, err := newS3(config)
+	if err != nil {
+		return err
+	}
+	ds = d
+	return nil
+}
+
+// newS3 returns a S3 datastore
+func newS3(config S3Config) (*S3, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("datastore: S3 bucket is not defined")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3.amazonaws.com"
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize < s3MinPartSize {
+		config.PartSize = s3MinPartSize
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("datastore: bad S3 endpoint %s", config.Endpoint)
+	}
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of key with query
+func (d *S3) objectURL(key string, query url.Values) *url.URL {
+	return d.url(d.config.Prefix+key, query)
+}
+
+// url returns the URL of object name in bucket with query
+func (d *S3) url(name string, query url.Values) *url.URL {
+	u := *d.endpoint
+	p := "/" + name
+	if d.config.PathStyle {
+		p = "/" + d.config.Bucket + p
+	} else {
+		u.Host = d.config.Bucket + "." + u.Host
+	}
+	u.Path = u.Path + p
+	u.RawPath = s3Encode(u.Path, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// request sends a signed request on key (see do)
+func (d *S3) request(method, key string, query url.Values, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	return d.do(method, d.objectURL(key, query), header, body, expected...)
+}
+
+// do sends a signed request to u
+// body is sent as is, response status must be one of expected
+// (ErrNotFound is returned on 404)
+// returned response body must be closed
+func (d *S3) do(method string, u *url.URL, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := s3EmptySHA256
+	if len(body) != 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	d.sign(req, payloadHash, d.now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, s3Error(method, u.Path, resp)
+}
+
+// s3Error returns the error of S3 response resp to request on path
+func s3Error(method, path string, resp *http.Response) error {
+	e := struct {
+		Code    string
+		Message string
+	}{}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if xml.Unmarshal(b, &e) != nil || e.Code == "" {
+		e.Code = resp.Status
+	}
+	return fmt.Errorf("datastore: S3 %s %s failed: %s %s", method, path, e.Code, e.Message)
+}
+
+// put implements datastore.put
+func (d *S3) put(key string, value []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := d.request(http.MethodPut, key, nil, header, value, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// putReader implements datastore.putReader
+// values larger than PartSize are sent by multipart upload
+func (d *S3) putReader(key string, r io.Reader) error {
+	part := make([]byte, d.config.PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return d.put(key, part[:n])
+	}
+	if err != nil {
+		return err
+	}
+	return d.multipartUpload(key, part, r)
+}
+
+// multipart upload messages
+type (
+	s3InitiateMultipartUploadResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+	s3CompleteMultipartUpload struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}
+	s3Part struct {
+		PartNumber int
+		ETag       string
+	}
+)
+
+// multipartUpload uploads first (a full part) then r to key
+// upload is aborted on failure
+func (d *S3) multipartUpload(key string, first []byte, r io.Reader) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := d.request(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	initiated := s3InitiateMultipartUploadResult{}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if initiated.UploadID == "" {
+		return fmt.Errorf("datastore: S3 multipart upload of %s failed: no upload ID", key)
+	}
+
+	if err = d.uploadParts(key, initiated.UploadID, first, r); err != nil {
+		if resp, abortErr := d.request(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil, http.StatusNoContent); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts uploads parts of multipart upload uploadID then completes it
+func (d *S3) uploadParts(key, uploadID string, part []byte, r io.Reader) error {
+	complete := s3CompleteMultipartUpload{}
+	n := len(part)
+	for number := 1; n > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := d.request(http.MethodPut, key, query, nil, part[:n], http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		n, err = io.ReadFull(r, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := d.request(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// errors may be reported with a 200 status
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(b, []byte("<Error>")) {
+		return fmt.Errorf("datastore: S3 multipart upload of %s failed: %s", key, b)
+	}
+	return nil
+}
+
+// exists implements datastore.exists
+func (d *S3) exists(key string) (bool, error) {
+	_, err := d.stat(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// get implements datastore.get
+func (d *S3) get(key string) ([]byte, error) {
+	r, err := d.getReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// getReader implements datastore.getReader
+// returned reader can seek (the object is requested again from offset)
+func (d *S3) getReader(key string) (io.ReadCloser, error) {
+	r := &s3Reader{d: d, key: key}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// stat implements datastore.stat
+func (d *S3) stat(key string) (Info, error) {
+	resp, err := d.request(http.MethodHead, key, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	info := Info{Size: resp.ContentLength}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// s3ListBucketResult is a page of ListObjectsV2
+type s3ListBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+	}
+}
+
+// list implements datastore.list
+// a batch is a page of ListObjectsV2 (up to 1000 keys)
+func (d *S3) list(prefix string) func() ([]Entry, bool, error) {
+	token := ""
+	return func() ([]Entry, bool, error) {
+		query := url.Values{"list-type": {"2"}, "prefix": {d.config.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := d.do(http.MethodGet, d.url("", query), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, false, err
+		}
+		defer resp.Body.Close()
+		page := s3ListBucketResult{}
+		if err = xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+			return nil, false, err
+		}
+		entries := make([]Entry, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			entries = append(entries, Entry{
+				Key:  strings.TrimPrefix(object.Key, d.config.Prefix),
+				Info: Info{Size: object.Size, ModTime: object.LastModified},
+			})
+		}
+		token = page.NextContinuationToken
+		return entries, page.IsTruncated && token != "", nil
+	}
+}
+
+// delete implements datastore.delete
+// (S3 does not report missing keys on delete)
+func (d *S3) delete(key string) error {
+	if _, err := d.stat(key); err != nil {
+		return err
+	}
+	resp, err := d.request(http.MethodDelete, key, nil, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// presign implements presigner
+func (d *S3) presign(key string) (string, error) {
+	if d.config.Presign <= 0 {
+		return "", ErrNotSupported
+	}
+	t := d.now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {d.config.AccessKey + "/" + d.scope(t)},
+		"X-Amz-Date":          {t.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(d.config.Presign / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u := d.objectURL(key, query)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{}}
+	query.Set("X-Amz-Signature", d.signature(req, []string{"host"}, s3Unsigned, t))
+	u.RawQuery = s3Query(query)
+	return u.String(), nil
+}
+
+// s3Reader is a seekable reader of an object
+type s3Reader struct {
+	d      *S3
+	key    string
+	offset int64
+	body   io.ReadCloser
+}
+
+// open requests the object from offset
+func (r *s3Reader) open() error {
+	header := http.Header{}
+	if r.offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	resp, err := r.d.request(http.MethodGet, r.key, nil, header, nil, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return err
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// Read implements io.Reader
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.body == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker (io.SeekEnd is not supported)
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	default:
+		return r.offset, errors.New("datastore: S3 reader can't seek from end")
+	}
+	if offset < 0 {
+		return r.offset, errors.New("datastore: negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return r.offset, nil
+}
+
+// Close implements io.Closer
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// sign signs req (at t) with authorization header
+func (d *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// host, range and x-amz-* headers are signed
+	signed := []string{"host"}
+	for k := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			signed = append(signed, k)
+		}
+	}
+	sort.Strings(signed)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, d.config.AccessKey, d.scope(t), strings.Join(signed, ";"), d.signature(req, signed, payloadHash, t)))
+}
+
+// scope returns the credential scope at t
+func (d *S3) scope(t time.Time) string {
+	return t.Format(s3DateFormat) + "/" + d.config.Region + "/s3/aws4_request"
+}
+
+// signature returns the signature of req (at t) on headers signed
+func (d *S3) signature(req *http.Request, signed []string, payloadHash string, t time.Time) string {
+	headers := ""
+	for _, k := range signed {
+		v := req.Host
+		if k != "host" {
+			v = strings.Join(req.Header[http.CanonicalHeaderKey(k)], ",")
+		}
+		headers += k + ":" + strings.TrimSpace(v) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		headers,
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3Algorithm, t.Format(s3TimeFormat), d.scope(t), hex.EncodeToString(sum[:])}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+d.config.SecretKey), t.Format(s3DateFormat))
+	key = s3HMAC(key, d.config.Region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	return hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+// s3HMAC returns HMAC-SHA256 of data with key
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query returns the canonical query string of query
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Encode(k, true)+"="+s3Encode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Encode URI encodes s as S3 does (all but unreserved characters,
+// and / if encodeSlash is false)
+func s3Encode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
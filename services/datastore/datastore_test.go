@@ -1,7 +1,13 @@
 package datastore
 
 import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -40,6 +46,73 @@ func TestInitFilesystemDatastore(t *testing.T) {
 	}
 }
 
+func TestStreaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+
+	// PutReader
+	err = PutReader(key, strings.NewReader(string(value)))
+	assert.NoError(t, err)
+
+	// Stat
+	info, err := Stat(key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(value)), info.Size)
+		assert.WithinDuration(t, time.Now(), info.ModTime, time.Minute)
+	}
+
+	// GetReader
+	r, err := GetReader(key)
+	if assert.NoError(t, err) {
+		// Fs readers can seek
+		_, err = r.(io.Seeker).Seek(6, io.SeekStart)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "peerpx", string(b))
+		assert.NoError(t, r.Close())
+	}
+
+	// overwrite with a shorter value
+	err = PutReader(key, strings.NewReader("hello"))
+	assert.NoError(t, err)
+	rData, err := Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(rData))
+
+	// List
+	for _, k := range []string{"hash", "hash_m", "hash_s", "hat", "other"} {
+		assert.NoError(t, Put(k, value))
+	}
+	list := func(prefix string) []string {
+		keys := []string{}
+		it := List(prefix)
+		for it.Next() {
+			keys = append(keys, it.Entry().Key)
+		}
+		assert.NoError(t, it.Err())
+		sort.Strings(keys)
+		return keys
+	}
+	assert.Equal(t, []string{"hash", "hash_m", "hash_s"}, list("hash"))
+	assert.Equal(t, []string{"hash", "hash_m", "hash_s", "hat"}, list("ha"))
+	assert.Equal(t, []string{"hash", "hash_m", "hash_s", "hat", "other", key}, list(""))
+	assert.Empty(t, list("none"))
+
+	// not found
+	assert.NoError(t, Delete(key))
+	_, err = GetReader(key)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = Stat(key)
+	assert.Equal(t, ErrNotFound, err)
+}
+
 func TestInitMokedDatastore(t *testing.T) {
 	// it useless to test err in this case
 	InitMokedDatastore(value, nil)
@@ -54,4 +127,15 @@ func TestInitMokedDatastore(t *testing.T) {
 	assert.Error(t, err)
 	assert.EqualError(t, ErrNotFound, err.Error())
 
+	// readers
+	InitMokedDatastore(value, nil)
+	r, err := GetReader(key)
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(r)
+		assert.Equal(t, value, b)
+	}
+	info, err := Stat(key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(value)), info.Size)
+	}
 }
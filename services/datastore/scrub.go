@@ -0,0 +1,138 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/peerpx/peerpx/pkg/hasher"
+)
+
+// fsQuarantine is the directory (in base path) corrupted values are moved to
+const fsQuarantine = ".quarantine"
+
+// fsTempMaxAge is the age from which temp files are leftovers of a crash
+const fsTempMaxAge = 24 * time.Hour
+
+// ScrubReport is the report of a datastore scrub
+type ScrubReport struct {
+	Checked     int      `json:"checked"`     // number of hash keyed values checked
+	Corrupted   []string `json:"corrupted"`   // keys of values not matching their hash
+	Quarantined []string `json:"quarantined"` // keys of corrupted values moved to quarantine
+	TempRemoved int      `json:"tempRemoved"` // number of stale temp files removed
+}
+
+// scrubber is implemented by providers able to scrub their values
+// stored values are decoded by decode (if not nil) before being hashed,
+// values decode fails on are corrupted
+type scrubber interface {
+	scrub(quarantine bool, decode func([]byte) ([]byte, error)) (*ScrubReport, error)
+}
+
+// Scrub verifies values keyed by a hash (photos, originals): their hash
+// (see hasher.GetHash) must be their key
+// corrupted values are reported and, if quarantine is true, moved out of
+// datastore (ErrNotSupported is returned if datastore can't scrub)
+func Scrub(quarantine bool) (*ScrubReport, error) {
+	if ds == nil {
+		return nil, ErrNotInitialized
+	}
+	s, ok := ds.(scrubber)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return s.scrub(quarantine, nil)
+}
+
+// scrub implements scrubber
+// with quarantine, temp files older than fsTempMaxAge are removed too
+func (d *Fs) scrub(quarantine bool, decode func([]byte) ([]byte, error)) (*ScrubReport, error) {
+	report := &ScrubReport{Corrupted: []string{}, Quarantined: []string{}}
+	err := filepath.Walk(d.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == fsQuarantine {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		key := info.Name()
+		if isTemp(key) {
+			if quarantine && time.Since(info.ModTime()) > fsTempMaxAge {
+				if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				report.TempRemoved++
+			}
+			return nil
+		}
+		if !hasher.IsHash(key) || filepath.Join(d.getPath(key), key) != path {
+			return nil
+		}
+
+		report.Checked++
+		corrupted, err := d.corrupted(key, decode)
+		if err != nil || !corrupted {
+			return err
+		}
+		report.Corrupted = append(report.Corrupted, key)
+		if !quarantine {
+			return nil
+		}
+		moved, err := d.quarantine(key, decode)
+		if moved {
+			report.Quarantined = append(report.Quarantined, key)
+		}
+		return err
+	})
+	return report, err
+}
+
+// corrupted returns true if value of hash key does not match its key
+// (false if key no longer exists)
+func (d *Fs) corrupted(key string, decode func([]byte) ([]byte, error)) (bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(d.getPath(key), key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if decode != nil {
+		if b, err = decode(b); err != nil {
+			return true, nil
+		}
+	}
+	hash, err := hasher.GetHash(b)
+	if err != nil {
+		return false, err
+	}
+	return hash != key, nil
+}
+
+// quarantine moves corrupted value of key to quarantine directory
+// value is checked again under lock: it may have been rewritten meanwhile
+func (d *Fs) quarantine(key string, decode func([]byte) ([]byte, error)) (bool, error) {
+	unlock := d.lock(key)
+	defer unlock()
+	corrupted, err := d.corrupted(key, decode)
+	if err != nil || !corrupted {
+		return false, err
+	}
+	dir := filepath.Join(d.basePath, fsQuarantine)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return false, err
+	}
+	target := filepath.Join(dir, key+"."+time.Now().UTC().Format("20060102T150405"))
+	if err = os.Rename(filepath.Join(d.getPath(key), key), target); err != nil {
+		return false, err
+	}
+	return true, syncDir(d.getPath(key))
+}
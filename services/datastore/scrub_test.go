@@ -0,0 +1,115 @@
+package datastore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peerpx/peerpx/pkg/hasher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrub(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { ds = nil }()
+
+	// not supported
+	InitMokedDatastore(nil, nil)
+	_, err = Scrub(false)
+	assert.Equal(t, ErrNotSupported, err)
+
+	if err = InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+	good, _ := hasher.GetHash([]byte("good"))
+	bad, _ := hasher.GetHash([]byte("bad"))
+	for k, v := range map[string][]byte{good: []byte("good"), bad: []byte("truncated"), good + "_m": []byte("variant"), key: value} {
+		if err = Put(k, v); err != nil {
+			panic(err)
+		}
+	}
+	// crash leftover
+	temp := filepath.Join(dir, fsTempPrefix+good+"-1")
+	if err = ioutil.WriteFile(temp, []byte("go"), 0644); err != nil {
+		panic(err)
+	}
+	old := time.Now().Add(-2 * fsTempMaxAge)
+	if err = os.Chtimes(temp, old, old); err != nil {
+		panic(err)
+	}
+
+	// report
+	report, err := Scrub(false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, report.Checked)
+		assert.Equal(t, []string{bad}, report.Corrupted)
+		assert.Empty(t, report.Quarantined)
+		assert.Equal(t, 0, report.TempRemoved)
+	}
+	exists, _ := Exists(bad)
+	assert.True(t, exists)
+
+	// quarantine
+	report, err = Scrub(true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{bad}, report.Corrupted)
+		assert.Equal(t, []string{bad}, report.Quarantined)
+		assert.Equal(t, 1, report.TempRemoved)
+	}
+	exists, _ = Exists(bad)
+	assert.False(t, exists)
+	quarantined, _ := filepath.Glob(filepath.Join(dir, fsQuarantine, bad+".*"))
+	assert.Len(t, quarantined, 1)
+	_, err = os.Stat(temp)
+	assert.True(t, os.IsNotExist(err))
+
+	// clean
+	report, err = Scrub(true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Checked)
+		assert.Empty(t, report.Corrupted)
+	}
+}
+
+func TestFsConcurrentPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peerpx")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { ds = nil }()
+	if err = InitFilesystemDatastore(dir); err != nil {
+		panic(err)
+	}
+
+	// each value is fully written or not at all
+	values := [][]byte{}
+	for i := 1; i <= 8; i++ {
+		values = append(values, bytes.Repeat([]byte{byte(i)}, i*100000))
+	}
+	var wg sync.WaitGroup
+	for _, v := range values {
+		wg.Add(1)
+		go func(v []byte) {
+			defer wg.Done()
+			assert.NoError(t, Put(key, v))
+		}(v)
+	}
+	wg.Wait()
+
+	stored, err := Get(key)
+	if assert.NoError(t, err) {
+		assert.Contains(t, values, stored)
+	}
+	// no temp file left
+	temps, _ := filepath.Glob(filepath.Join(dir, "*", "*", fsTempPrefix+"*"))
+	assert.Empty(t, temps)
+}
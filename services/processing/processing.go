@@ -0,0 +1,109 @@
+// Package processing runs image processing jobs on a fixed-size pool
+// of workers
+// Jobs are queued up to a limit (ErrSaturated is returned beyond it) and
+// identical jobs in flight are coalesced: they are run once and all
+// callers get the same result
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrNotInitialized (pool not started)
+	ErrNotInitialized = errors.New("processing: service not initialized")
+
+	// ErrSaturated is returned by Do when the queue is full
+	ErrSaturated = errors.New("processing: queue is full")
+)
+
+// call is a job in flight, shared by coalesced callers
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// job is a queued call
+type job struct {
+	key string
+	fn  func() ([]byte, error)
+	c   *call
+}
+
+var (
+	mu    sync.Mutex
+	calls map[string]*call // jobs in flight by key
+	jobs  chan job
+)
+
+// InitPool starts workers workers processing up to queueSize queued jobs
+// (a running pool is stopped once its queued jobs are done)
+func InitPool(workers, queueSize int) error {
+	if workers < 1 || queueSize < 0 {
+		return fmt.Errorf("processing: bad pool size (%d workers, queue of %d)", workers, queueSize)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if jobs != nil {
+		close(jobs)
+	}
+	calls = make(map[string]*call)
+	jobs = make(chan job, queueSize)
+	for i := 0; i < workers; i++ {
+		go worker(jobs)
+	}
+	return nil
+}
+
+// Do runs fn on the pool and returns its result
+// calls with the same key while fn is queued or running share its result
+// ErrSaturated is returned if the queue is full
+func Do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	mu.Lock()
+	if jobs == nil {
+		mu.Unlock()
+		return nil, ErrNotInitialized
+	}
+	if c, found := calls[key]; found {
+		mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	select {
+	case jobs <- job{key: key, fn: fn, c: c}:
+		calls[key] = c
+		mu.Unlock()
+	default:
+		mu.Unlock()
+		return nil, ErrSaturated
+	}
+	<-c.done
+	return c.value, c.err
+}
+
+// worker runs jobs until queue is closed
+func worker(queue chan job) {
+	for j := range queue {
+		run(j)
+	}
+}
+
+// run runs job j and releases its callers
+func run(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			j.c.value, j.c.err = nil, fmt.Errorf("processing: %s panicked: %v", j.key, r)
+		}
+		mu.Lock()
+		if calls[j.key] == j.c {
+			delete(calls, j.key)
+		}
+		mu.Unlock()
+		close(j.c.done)
+	}()
+	j.c.value, j.c.err = j.fn()
+}
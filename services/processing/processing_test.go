@@ -0,0 +1,89 @@
+package processing
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	jobs = nil
+	_, err := Do("key", func() ([]byte, error) { return nil, nil })
+	assert.Equal(t, ErrNotInitialized, err)
+	assert.Error(t, InitPool(0, 1))
+
+	if err = InitPool(2, 1); err != nil {
+		panic(err)
+	}
+	b, err := Do("key", func() ([]byte, error) { return []byte{1}, nil })
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte{1}, b)
+	}
+	_, err = Do("key", func() ([]byte, error) { return nil, errors.New("mocked") })
+	assert.EqualError(t, err, "mocked")
+	_, err = Do("key", func() ([]byte, error) { panic("mocked") })
+	assert.EqualError(t, err, "processing: key panicked: mocked")
+}
+
+func TestDo_coalescing(t *testing.T) {
+	if err := InitPool(1, 1); err != nil {
+		panic(err)
+	}
+	var runs int32
+	release := make(chan struct{})
+	fn := func() ([]byte, error) {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return []byte{1}, nil
+	}
+
+	// first call is running
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		Do("a", fn)
+	}()
+	waitFor(func() bool { return atomic.LoadInt32(&runs) == 1 })
+
+	// identical calls are coalesced
+	results := make([][]byte, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = Do("a", fn)
+		}(i)
+	}
+	// let them join the running call
+	time.Sleep(20 * time.Millisecond)
+
+	// b is queued, c is rejected
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		Do("b", fn)
+	}()
+	waitFor(func() bool { return len(jobs) == 1 })
+	_, err := Do("c", fn)
+	assert.Equal(t, ErrSaturated, err)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), runs)
+	for _, b := range results {
+		assert.Equal(t, []byte{1}, b)
+	}
+}
+
+// waitFor waits until cond is true
+func waitFor(cond func() bool) {
+	for !cond() {
+		runtime.Gosched()
+	}
+}